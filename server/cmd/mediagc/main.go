@@ -0,0 +1,81 @@
+// Command mediagc runs the orphaned media collector once and prints its report as JSON.
+//
+//	go run ./cmd/mediagc -dry-run -media.gc_grace 48h
+//
+// it reads the same config as the server (see config.Load).
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/db"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/jobs"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Println(err)
+	}
+
+	dryRun := flag.Bool("dry-run", false, "only report the orphaned objects, don't delete them")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalln("Invalid config:", err)
+	}
+	logging.Init(cfg.Log)
+
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		slog.Error("Couldn't set up storage", "driver", cfg.Storage.Driver, "error", err)
+		os.Exit(1)
+	}
+	mongo, err := db.Connect(cfg.Mongo)
+	if err != nil {
+		slog.Error("Couldn't connect to mongo", "error", err)
+		os.Exit(1)
+	}
+	defer mongo.Disconnect(context.Background())
+	// deletions fall back to it when the queue can't be reached.
+	background := &utils.Background{}
+	cache, err := redis.New(cfg.Redis, store, background)
+	if err != nil {
+		slog.Error("Couldn't connect to redis", "error", err)
+		os.Exit(1)
+	}
+	defer cache.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+
+	gc := &jobs.MediaGC{
+		Store:    store,
+		Cache:    cache,
+		Messages: models.NewMongoMessages(mongo, cfg.Server.PageSize),
+		Users:    models.NewMongoUsers(mongo),
+	}
+	report, err := gc.CollectOrphanedMedia(ctx, cfg.Media.GCGrace, *dryRun)
+	if err != nil {
+		slog.Error("Media GC failed", "error", err)
+		os.Exit(1)
+	}
+	if err = background.Wait(ctx); err != nil {
+		slog.Error("Couldn't finish deleting media", "error", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(report)
+}
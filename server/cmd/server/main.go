@@ -3,21 +3,32 @@ package main
 import (
 	"context"
 	_ "crypto/tls"
-	"fmt"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net/http"
 	_ "net/http/httptest"
 	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	routes "github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/api"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/api"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/db"
-	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/middlewares"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/jobs"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/tracing"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
-	"github.com/gin-contrib/cors"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/ws"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
-	"golang.org/x/time/rate"
 )
 
 func main() {
@@ -26,37 +37,154 @@ func main() {
 		log.Println(err)
 	}
 
-	utils.InitAWS()
-	db.Init()
-	defer db.Disconnect()
-	redis.Init()
-	defer redis.Client.Close()
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatalln("Invalid config:", err)
+	}
+	logging.Init(cfg.Log)
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.Tracing, api.Version)
+	if err != nil {
+		slog.Error("Couldn't set up tracing", "error", err)
+		os.Exit(1)
+	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go redis.StartSubscriber(ctx)
+	keys, err := utils.LoadKeyring(cfg.Auth)
+	if err != nil {
+		slog.Error("Couldn't load the JWT keys", "error", err)
+		os.Exit(1)
+	}
+	webAuthn, err := utils.NewWebAuthn(cfg.WebAuthn.RPID, cfg.WebAuthn.Origins)
+	if err != nil {
+		slog.Error("Couldn't set up WebAuthn", "error", err)
+		os.Exit(1)
+	}
+
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		slog.Error("Couldn't set up storage", "driver", cfg.Storage.Driver, "error", err)
+		os.Exit(1)
+	}
+	mongo, err := db.Connect(cfg.Mongo)
+	if err != nil {
+		slog.Error("Couldn't connect to mongo", "error", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		mongo.Disconnect(ctx)
+	}()
+	background := &utils.Background{}
+	cache, err := redis.New(cfg.Redis, store, background)
+	if err != nil {
+		slog.Error("Couldn't connect to redis", "error", err)
+		os.Exit(1)
+	}
+	defer cache.Close()
+
+	media := utils.NewMedia(cfg.Media, store, cache.EnqueueDeletion, background)
+	users := models.NewMongoUsers(mongo)
+	conversations := models.NewMongoConversations(mongo)
+	messages := models.NewMongoMessages(mongo, cfg.Server.PageSize)
+	hub := ws.NewHub(cfg.Server, conversations, messages, cache, background)
+	metrics.ConnectionCount = func() int { return len(hub.GetAllConns()) }
+
+	server := &api.Server{
+		Config:        cfg,
+		Users:         users,
+		Conversations: conversations,
+		Messages:      messages,
+		Passkeys:      models.NewMongoPasskeys(mongo),
+		Media:         media,
+		Cache:         cache,
+		Hub:           hub,
+		Keys:          keys,
+		Passwords:     utils.NewPasswordHasher(cfg.Auth),
+		WebAuthn:      webAuthn,
+		OIDCProviders: utils.NewOIDCProviders(cfg.OIDC),
+		Background:    background,
+		Dependencies: map[string]func(context.Context) error{
+			"mongo":   mongo.Ping,
+			"redis":   cache.Ping,
+			"storage": func(ctx context.Context) error { return storage.Ping(ctx, store) },
+		},
+	}
 
-	server := gin.Default()
-	limiter := utils.NewClientLimiter(rate.Every(750*time.Millisecond), 5)
-	server.Use(middlewares.RateLimitMiddleware(limiter))
+	jobsCtx, stopJobs := context.WithCancel(context.Background())
+	defer stopJobs()
+	gc := &jobs.MediaGC{Store: store, Cache: cache, Messages: messages, Users: users}
+	var workers sync.WaitGroup
+	for _, worker := range []func(context.Context){
+		cache.StartSweeper,
+		func(ctx context.Context) { gc.Start(ctx, cfg.Media) },
+		func(ctx context.Context) { jobs.StartDeletionWorker(ctx, cache, store) },
+	} {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			worker(jobsCtx)
+		}()
+	}
 
-	routes.ManagerInit()
+	httpServers := []*http.Server{{Addr: cfg.Server.Addr, Handler: server.Router()}}
+	if cfg.Server.MetricsAddr != "" {
+		metricsRouter := gin.New()
+		metricsRouter.GET("/metrics", metrics.Handler())
+		httpServers = append(httpServers, &http.Server{Addr: cfg.Server.MetricsAddr, Handler: metricsRouter})
+	}
+	for _, httpServer := range httpServers {
+		go func() {
+			if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Error("Couldn't start the http server", "addr", httpServer.Addr, "error", err)
+				os.Exit(1)
+			}
+		}()
+	}
 
-	server.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{os.Getenv("FRONTEND_URL"), "http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Authorization", "Content-Type", "Accept", "Origin"},
-		AllowCredentials: true,
-	}))
+	signals, stopSignals := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stopSignals()
+	<-signals.Done()
+	stopSignals()
 
-	routes.Register(server)
+	slog.Info("Shutting down")
+	shutdown(hub, httpServers, background, &workers, stopJobs, cfg.Server.ShutdownTimeout)
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "localhost:8080"
-	} else {
-		port = fmt.Sprintf(":%s", port)
+	// the spans of the last requests and jobs are still buffered.
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancelFlush()
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Couldn't flush traces", "error", err)
 	}
+}
 
-	server.Run(port)
+// Stops the server in order: websocket connections are told to reconnect and closed once the messages they
+// sent are saved, then the http servers stop, then the work started by requests and the background jobs
+// finish. mongo and redis are closed by main's defers afterwards.
+func shutdown(hub *ws.Hub, httpServers []*http.Server, background *utils.Background, workers *sync.WaitGroup, stopJobs context.CancelFunc, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := hub.Drain(ctx); err != nil {
+		slog.Error("Couldn't drain websocket connections", "error", err)
+	}
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			slog.Error("Couldn't shut down the http server", "addr", httpServer.Addr, "error", err)
+		}
+	}
+	if err := background.Wait(ctx); err != nil {
+		slog.Error("Couldn't finish background work", "error", err)
+	}
+
+	stopJobs()
+	stopped := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Error("Couldn't stop background jobs", "error", ctx.Err())
+	}
 }
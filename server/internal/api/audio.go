@@ -0,0 +1,54 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func (s *Server) uploadAudioHandler(ctx *gin.Context) {
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	path, meta, code, err := s.Media.ExtractAudioAndUpload(ctx.Writer, ctx.Request, "audio")
+	if err != nil {
+		ctx.JSON(code, gin.H{"message": err.Error()})
+		return
+	}
+
+	err = s.Cache.SetTempAudio(ctx.Request.Context(), path, &meta, userID)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't save temp voice note", "error", err)
+		s.Media.DeleteLater(path)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't upload voice note, please try again later."})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Voice note uploaded successfully.", "path": path, "audioMeta": meta})
+}
+
+func (s *Server) deleteAudioHandler(ctx *gin.Context) {
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	path, err := s.Cache.GetTempAudio(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Voice note not found."})
+		return
+	}
+
+	s.Media.DeleteLater(path)
+	bgCtx := context.WithoutCancel(ctx.Request.Context())
+	s.Background.Go(func() { s.Cache.DeleteAudioKeys(bgCtx, userID) })
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Deleting voice note."})
+}
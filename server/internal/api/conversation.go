@@ -2,28 +2,25 @@ package api
 
 import (
 	"context"
-	"fmt"
-	"log"
 	"net/http"
 
 	snapws "github.com/Atheer-Ganayem/SnapWS"
-	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/ws"
 	"github.com/gin-gonic/gin"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
-	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
-func getConversations(ctx *gin.Context) {
+func (s *Server) getConversations(ctx *gin.Context) {
 	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
 		return
 	}
 
-	conversations, err := models.GetMyPopulateConversations(userID)
+	conversations, err := s.Conversations.GetPopulated(ctx.Request.Context(), userID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't get your conversations, please try again later."})
 		return
@@ -33,13 +30,13 @@ func getConversations(ctx *gin.Context) {
 	for i, cnv := range conversations {
 		IDs[i] = cnv.Participant.ID
 	}
-	online := FilterOnlineUsers(IDs)
+	online := s.Hub.FilterOnlineUsers(IDs)
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Conversation fetched successfully.",
 		"conversations": conversations, "online": online})
 }
 
-func createConversation(ctx *gin.Context) {
+func (s *Server) createConversation(ctx *gin.Context) {
 	// Parsing & validating request body
 	type CreateConversationInput struct {
 		TargetUserID string `json:"targetUserID" binding:"required"`
@@ -72,7 +69,7 @@ func createConversation(ctx *gin.Context) {
 
 	// check Conversation if already exists
 	userIDs := [2]bson.ObjectID{clientID, targetID}
-	_, err = models.FindConversationByParticipants(userIDs)
+	_, err = s.Conversations.FindByParticipants(ctx.Request.Context(), userIDs)
 	if err == nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Conversation already exists."})
 		return
@@ -82,19 +79,20 @@ func createConversation(ctx *gin.Context) {
 	}
 
 	// create Conversation
-	insertedID, code, err := models.CreateConversation(userIDs)
+	insertedID, code, err := s.Conversations.Create(ctx.Request.Context(), userIDs)
 	if err != nil {
 		ctx.JSON(code, gin.H{"message": err.Error()})
 		return
 	}
 
 	// Update target user of the new conversation (if connected), and updated connected users participantsIDs slice
-	targetConn, ok := Manager.GetConn(targetID)
+	targetConn, ok := s.Hub.GetConn(targetID)
 	if ok && targetConn != nil {
-		go notifyUserOfConversationCreation(targetConn, clientID, insertedID)
+		bgCtx := context.WithoutCancel(ctx.Request.Context())
+		s.Background.Go(func() { s.notifyUserOfConversationCreation(bgCtx, targetConn, clientID, insertedID) })
 		ws.AppendParticipant(targetConn, clientID)
 	}
-	if clientConn, ok := Manager.GetConn(clientID); ok {
+	if clientConn, ok := s.Hub.GetConn(clientID); ok {
 		ws.AppendParticipant(clientConn, targetID)
 	}
 
@@ -103,19 +101,18 @@ func createConversation(ctx *gin.Context) {
 		"conversationID": insertedID, "isOnline": targetConn != nil})
 }
 
-func notifyUserOfConversationCreation(conn *snapws.ManagedConn[bson.ObjectID], clientID, cnvID bson.ObjectID) {
-	opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: 1}, {Key: "avatar", Value: 1}})
-	user, err := models.FindUser(bson.M{"_id": clientID}, opts)
+func (s *Server) notifyUserOfConversationCreation(ctx context.Context, conn *snapws.ManagedConn[bson.ObjectID], clientID, cnvID bson.ObjectID) {
+	user, err := s.Users.FindProfile(ctx, clientID)
 	if err != nil {
-		fmt.Printf("error finding user in 'notifyUserOfConversationCreation'. %s\n", err)
+		logging.From(ctx).Error("Couldn't find the creator of a conversation", "conversation_id", cnvID.Hex(), "error", err)
 		return
 	}
 	isOnline := false // if the clinet user is online not the target!!!
-	if _, ok := Manager.GetConn(clientID); ok {
+	if _, ok := s.Hub.GetConn(clientID); ok {
 		isOnline = true
 	}
 
-	if err = conn.SendJSON(context.Background(), gin.H{"type": "cnv", "user": user, "cnvId": cnvID, "isOnline": isOnline}); err != nil {
-		log.Println("error notifiy of conversation creation.")
+	if err = ws.SendJSON(ctx, conn, gin.H{"type": "cnv", "user": user, "cnvId": cnvID, "isOnline": isOnline}); err != nil {
+		logging.From(ctx).Warn("Couldn't send conversation creation via ws", "conversation_id", cnvID.Hex(), "error", err)
 	}
 }
@@ -0,0 +1,284 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// Creates a conversation between a and b over http, returns its id.
+func createConversation(t *testing.T, a, b *client, targetOnline bool) string {
+	t.Helper()
+
+	code, res := a.request(http.MethodPost, "/conversation", gin.H{"targetUserID": b.ID.Hex()})
+	conversationID, _ := res["conversationID"].(string)
+	want := gin.H{"message": "Conversation has been created successfully.", "conversationID": conversationID, "isOnline": targetOnline}
+	if code != http.StatusCreated || conversationID == "" || jsonOf(t, res) != jsonOf(t, want) {
+		t.Fatalf("creating a conversation: %d %v", code, res)
+	}
+
+	return conversationID
+}
+
+// Sends a text message and checks the acknowledgement, returns the saved message as received.
+func sendMessage(t *testing.T, sender *client, conversationID, text string) map[string]any {
+	t.Helper()
+
+	requestID := uuid.NewString()
+	sender.send(gin.H{"id": requestID, "type": "msg", "conversationId": conversationID, "message": text})
+
+	ack := sender.next()
+	message, _ := ack["message"].(map[string]any)
+	messageID, _ := message["_id"].(string)
+	createdAt, _ := message["createdAt"].(string)
+	if at, err := time.Parse(time.RFC3339Nano, createdAt); err != nil || time.Since(at) > time.Minute {
+		t.Fatalf("acknowledged message has an invalid creation time: %v", ack)
+	}
+
+	want := gin.H{"type": "acknowledged", "id": requestID, "message": gin.H{
+		"_id":            messageID,
+		"sender":         sender.ID.Hex(),
+		"conversationId": conversationID,
+		"text":           strings.TrimSpace(text),
+		"createdAt":      createdAt,
+	}}
+	if got, want := jsonOf(t, ack), jsonOf(t, want); got != want {
+		t.Fatalf("unexpected acknowledgement\n got: %s\nwant: %s", got, want)
+	}
+
+	return message
+}
+
+// Returns message as it's read back from the database, which keeps times to the millisecond, while the
+// events carry the time it was sent at.
+func stored(t *testing.T, message map[string]any) map[string]any {
+	t.Helper()
+
+	createdAt, err := time.Parse(time.RFC3339Nano, message["createdAt"].(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	copied := map[string]any{}
+	for key, value := range message {
+		copied[key] = value
+	}
+	copied["createdAt"] = createdAt.Truncate(time.Millisecond)
+
+	return copied
+}
+
+func TestE2EConversationCreation(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bob", "bob@example.com")
+	bob.connect()
+
+	conversationID := createConversation(t, alice, bob, true)
+
+	// bob is told who started the conversation, with what other users can see of them.
+	profile, err := h.server.Users.FindProfile(context.Background(), alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob.expect(gin.H{"type": "cnv", "user": profile, "cnvId": conversationID, "isOnline": false})
+
+	// they're participants now, so they see each other come and go.
+	alice.connect()
+	bob.expect(gin.H{"type": "status", "userId": alice.ID.Hex(), "online": true})
+	alice.disconnect()
+	bob.expect(gin.H{"type": "status", "userId": alice.ID.Hex(), "online": false})
+	bob.expectNothing()
+
+	code, res := bob.request(http.MethodPost, "/conversation", gin.H{"targetUserID": alice.ID.Hex()})
+	if code != http.StatusBadRequest || res["message"] != "Conversation already exists." {
+		t.Fatalf("creating the conversation again: %d %v", code, res)
+	}
+}
+
+func TestE2EPresence(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bob", "bob@example.com")
+	carol := h.register(t, "Carol", "carol@example.com")
+	createConversation(t, alice, bob, false)
+
+	bob.connect()
+	carol.connect()
+	alice.connect()
+	bob.expect(gin.H{"type": "status", "userId": alice.ID.Hex(), "online": true})
+	alice.expectNothing()
+	// carol has no conversation with alice.
+	carol.expectNothing()
+
+	code, res := alice.request(http.MethodGet, "/conversations", nil)
+	online, _ := res["online"].([]any)
+	if code != http.StatusOK || len(online) != 1 || online[0] != bob.ID.Hex() {
+		t.Fatalf("getting conversations: %d %v", code, res)
+	}
+
+	bob.disconnect()
+	alice.expect(gin.H{"type": "status", "userId": bob.ID.Hex(), "online": false})
+	carol.expectNothing()
+
+	code, res = alice.request(http.MethodGet, "/conversations", nil)
+	if online, _ := res["online"].([]any); code != http.StatusOK || len(online) != 0 {
+		t.Fatalf("getting conversations after bob left: %d %v", code, res)
+	}
+}
+
+func TestE2EMessaging(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bob", "bob@example.com")
+	conversationID := createConversation(t, alice, bob, false)
+	alice.connect()
+	bob.connect()
+	alice.expect(gin.H{"type": "status", "userId": bob.ID.Hex(), "online": true})
+
+	message := sendMessage(t, alice, conversationID, "  hi bob  ")
+	if message["text"] != "hi bob" {
+		t.Fatalf("message text wasn't trimmed: %v", message)
+	}
+	bob.expect(gin.H{"type": "msg", "message": message})
+
+	reply := sendMessage(t, bob, conversationID, "hi alice")
+	alice.expect(gin.H{"type": "msg", "message": reply})
+
+	// messages sent while the receiver is offline are only saved.
+	bob.disconnect()
+	alice.expect(gin.H{"type": "status", "userId": bob.ID.Hex(), "online": false})
+	offline := sendMessage(t, alice, conversationID, "are you there?")
+	alice.expectNothing()
+	h.waitForBackground(t)
+
+	code, res := bob.request(http.MethodGet, "/messages/"+conversationID, nil)
+	want := gin.H{"message": "Messages fetched successfully", "messages": []any{stored(t, offline), stored(t, reply), stored(t, message)}}
+	if got, want := jsonOf(t, res), jsonOf(t, want); code != http.StatusOK || got != want {
+		t.Fatalf("getting messages: %d\n got: %s\nwant: %s", code, got, want)
+	}
+
+	code, res = bob.request(http.MethodGet, "/conversations", nil)
+	conversations, _ := res["conversations"].([]any)
+	if code != http.StatusOK || len(conversations) != 1 {
+		t.Fatalf("getting conversations: %d %v", code, res)
+	}
+	lastMessage, _ := conversations[0].(map[string]any)["lastMessage"].(map[string]any)
+	if lastMessage["_id"] != offline["_id"] || lastMessage["text"] != "are you there?" {
+		t.Fatalf("last message = %v", lastMessage)
+	}
+}
+
+func TestE2EInvalidMessages(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bob", "bob@example.com")
+	carol := h.register(t, "Carol", "carol@example.com")
+	conversationID := createConversation(t, alice, bob, false)
+	alice.connect()
+	bob.connect()
+	alice.expect(gin.H{"type": "status", "userId": bob.ID.Hex(), "online": true})
+	carol.connect()
+
+	valid := gin.H{"id": uuid.NewString(), "type": "msg", "conversationId": conversationID, "message": "hi"}
+	invalid := []struct {
+		change gin.H
+		error  string
+	}{
+		{gin.H{"id": "1"}, "Invalid request ID. Must be a valid UUID."},
+		{gin.H{"type": "delete"}, "Invalid type."},
+		{gin.H{"message": "   "}, "Message cannot be empty."},
+		{gin.H{"image": "images/a.webp", "audio": "audio/a.ogg"}, "A message can't have both an image and a voice note."},
+		{gin.H{"conversationId": "nope"}, "Invalid conversation ID."},
+		// the image must be the one alice just uploaded.
+		{gin.H{"image": "images/someone-elses.webp"}, "Couldn't send image."},
+	}
+	for _, tc := range invalid {
+		payload := gin.H{}
+		for key, value := range valid {
+			payload[key] = value
+		}
+		for key, value := range tc.change {
+			payload[key] = value
+		}
+		alice.send(payload)
+		alice.expect(gin.H{"type": "err", "message": tc.error})
+	}
+
+	// carol isn't a participant.
+	payload := gin.H{"id": uuid.NewString(), "type": "msg", "conversationId": conversationID, "message": "hi"}
+	carol.send(payload)
+	carol.expect(gin.H{"type": "err", "message": "Couldn't send message."})
+
+	bob.expectNothing()
+	code, res := bob.request(http.MethodGet, "/messages/"+conversationID, nil)
+	if messages, _ := res["messages"].([]any); code != http.StatusOK || len(messages) != 0 {
+		t.Fatalf("invalid messages were saved: %d %v", code, res)
+	}
+}
+
+func TestE2EDeletion(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bob", "bob@example.com")
+	conversationID := createConversation(t, alice, bob, false)
+	alice.connect()
+	bob.connect()
+	alice.expect(gin.H{"type": "status", "userId": bob.ID.Hex(), "online": true})
+
+	first := sendMessage(t, alice, conversationID, "first")
+	bob.expect(gin.H{"type": "msg", "message": first})
+	second := sendMessage(t, alice, conversationID, "oops")
+	bob.expect(gin.H{"type": "msg", "message": second})
+	messageID := second["_id"].(string)
+
+	// only the sender can delete a message.
+	code, res := bob.request(http.MethodDelete, "/message/"+messageID, nil)
+	if code != http.StatusNotFound || res["message"] != "Message not found (1)." {
+		t.Fatalf("deleting someone else's message: %d %v", code, res)
+	}
+
+	code, res = alice.request(http.MethodDelete, "/message/"+messageID, nil)
+	if code != http.StatusOK || res["message"] != "Message has been deleted successuflly." {
+		t.Fatalf("deleting: %d %v", code, res)
+	}
+	bob.expect(gin.H{"type": "delete", "messageId": messageID})
+	alice.expectNothing()
+	h.waitForBackground(t)
+
+	code, res = bob.request(http.MethodGet, "/messages/"+conversationID, nil)
+	if messages, _ := res["messages"].([]any); code != http.StatusOK || len(messages) != 1 || messages[0].(map[string]any)["_id"] != first["_id"] {
+		t.Fatalf("getting messages after deleting: %d %v", code, res)
+	}
+	code, res = bob.request(http.MethodGet, "/conversations", nil)
+	conversations, _ := res["conversations"].([]any)
+	if code != http.StatusOK || len(conversations) != 1 || conversations[0].(map[string]any)["lastMessage"].(map[string]any)["_id"] != first["_id"] {
+		t.Fatalf("the last message wasn't updated: %d %v", code, res)
+	}
+}
+
+func TestE2EAuthentication(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+
+	anonymous := &client{t: t, h: h}
+	if code, _ := anonymous.request(http.MethodGet, "/conversations", nil); code != http.StatusUnauthorized {
+		t.Fatalf("getting conversations without a token: %d", code)
+	}
+
+	url := "ws" + strings.TrimPrefix(h.http.URL, "http") + "/ws"
+	for _, query := range []string{"", "?token=invalid", "?token=" + alice.token + "x"} {
+		_, res, err := websocket.DefaultDialer.Dial(url+query, nil)
+		if err == nil || res == nil || res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("connecting with %q: %v (%v)", query, err, res)
+		}
+	}
+
+	alice.connect()
+	alice.expectNothing()
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/gin-gonic/gin"
+)
+
+// Serves a stored object, with support for single range requests.
+func (s *Server) serveObject(ctx *gin.Context, key, cacheControl string) {
+	rangeGetter, canRange := storage.As[storage.RangeGetter](s.Media.Store)
+	if rangeHeader := ctx.GetHeader("Range"); canRange && rangeHeader != "" {
+		obj, err := s.Media.Store.Stat(ctx.Request.Context(), key)
+		if err != nil {
+			objectError(ctx, err)
+			return
+		}
+
+		start, length, err := parseRange(rangeHeader, obj.Size)
+		if err == errUnsatisfiableRange {
+			ctx.Header("Content-Range", fmt.Sprintf("bytes */%d", obj.Size))
+			ctx.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"message": "Invalid range."})
+			return
+		} else if err == nil {
+			body, obj, err := rangeGetter.GetRange(ctx.Request.Context(), key, start, length)
+			if err != nil {
+				objectError(ctx, err)
+				return
+			}
+			defer body.Close()
+
+			ctx.DataFromReader(http.StatusPartialContent, length, obj.ContentType, body, map[string]string{
+				"Cache-Control": cacheControl,
+				"Accept-Ranges": "bytes",
+				"Content-Range": fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, obj.Size),
+			})
+			return
+		}
+		// multiple ranges, the whole object is sent instead.
+	}
+
+	body, obj, err := s.Media.Store.Get(ctx.Request.Context(), key)
+	if err != nil {
+		objectError(ctx, err)
+		return
+	}
+	defer body.Close()
+
+	ctx.Header("Cache-Control", cacheControl)
+	if rs, ok := body.(io.ReadSeeker); ok {
+		ctx.Header("Content-Type", obj.ContentType)
+		http.ServeContent(ctx.Writer, ctx.Request, "", obj.ModTime, rs)
+		return
+	}
+
+	headers := map[string]string{}
+	if canRange {
+		headers["Accept-Ranges"] = "bytes"
+	}
+	ctx.DataFromReader(http.StatusOK, obj.Size, obj.ContentType, body, headers)
+}
+
+func objectError(ctx *gin.Context, err error) {
+	if err == storage.ErrNotFound || err == storage.ErrInvalidKey {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "File not found."})
+		return
+	}
+
+	logging.From(ctx.Request.Context()).Error("Couldn't get file", "error", err)
+	ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't get file, please try again later."})
+}
+
+var (
+	errUnsatisfiableRange = errors.New("unsatisfiable range")
+	errMultipleRanges     = errors.New("multiple ranges")
+)
+
+// Parses a single "bytes=start-end", "bytes=start-" or "bytes=-suffix" range of an object of size bytes.
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0, 0, errUnsatisfiableRange
+	}
+	if strings.Contains(spec, ",") {
+		return 0, 0, errMultipleRanges
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errUnsatisfiableRange
+	}
+
+	if first == "" {
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix <= 0 {
+			return 0, 0, errUnsatisfiableRange
+		}
+		suffix = min(suffix, size)
+		return size - suffix, suffix, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return 0, 0, errUnsatisfiableRange
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errUnsatisfiableRange
+		}
+		end = min(end, size-1)
+	}
+
+	return start, end - start + 1, nil
+}
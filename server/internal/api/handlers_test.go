@@ -0,0 +1,416 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestSearchUsersAndChangeName(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+	h.register(t, "Bob", "bob@example.com")
+
+	if code, _ := alice.request(http.MethodGet, "/users", nil); code != http.StatusBadRequest {
+		t.Fatalf("searching without a term: %d", code)
+	}
+
+	code, res := alice.request(http.MethodGet, "/users?search=ali", nil)
+	users, _ := res["users"].([]any)
+	if code != http.StatusOK || len(users) != 1 || users[0].(map[string]any)["_id"] != alice.ID.Hex() {
+		t.Fatalf("search: %d %v", code, res)
+	}
+	if _, ok := users[0].(map[string]any)["password"]; ok {
+		t.Fatal("search responded with a password")
+	}
+
+	if code, _ := alice.request(http.MethodPut, "/user/name", gin.H{"name": " a "}); code != http.StatusBadRequest {
+		t.Fatalf("changing to a short name: %d", code)
+	}
+	if code, res := alice.request(http.MethodPut, "/user/name", gin.H{"name": "Alicia"}); code != http.StatusOK {
+		t.Fatalf("changing name: %d %v", code, res)
+	}
+	if user, _ := h.server.Users.FindByID(context.Background(), alice.ID); user.Name != "Alicia" {
+		t.Fatalf("name wasn't changed: %+v", user)
+	}
+}
+
+func TestCreateAndGetConversations(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bob", "bob@example.com")
+
+	conversationID := createConversation(t, alice, bob, false)
+
+	// a pair can only have one conversation, in either direction.
+	if code, res := bob.request(http.MethodPost, "/conversation", gin.H{"targetUserID": alice.ID.Hex()}); code != http.StatusBadRequest {
+		t.Fatalf("creating it again: %d %v", code, res)
+	}
+	if code, _ := alice.request(http.MethodPost, "/conversation", gin.H{"targetUserID": "nope"}); code != http.StatusBadRequest {
+		t.Fatalf("creating with an invalid id: %d", code)
+	}
+
+	code, res := bob.request(http.MethodGet, "/conversations", nil)
+	conversations, _ := res["conversations"].([]any)
+	if code != http.StatusOK || len(conversations) != 1 {
+		t.Fatalf("getting: %d %v", code, res)
+	}
+	conversation := conversations[0].(map[string]any)
+	participant := conversation["participant"].(map[string]any)
+	if conversation["_id"] != conversationID || participant["_id"] != alice.ID.Hex() || participant["name"] != "Alice" {
+		t.Fatalf("getting: %v", conversation)
+	}
+	if online, _ := res["online"].([]any); len(online) != 0 {
+		t.Fatalf("nobody is connected but online = %v", online)
+	}
+}
+
+func TestGetAndDeleteMessages(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) { cfg.Server.PageSize = 2 })
+	ctx := context.Background()
+	alice := h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bob", "bob@example.com")
+	carol := h.register(t, "Carol", "carol@example.com")
+	conversationID, _ := bson.ObjectIDFromHex(createConversation(t, alice, bob, false))
+
+	var messages []models.Message
+	for i, sender := range []bson.ObjectID{alice.ID, bob.ID, alice.ID} {
+		message := models.Message{ID: bson.NewObjectID(), Sender: sender, ConversationID: conversationID, Text: "hi", CreatedAt: time.Now().Add(time.Duration(i) * time.Second)}
+		if err := h.server.Messages.Save(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, message)
+	}
+	h.server.Conversations.UpdateLastMessage(ctx, conversationID, messages[2].ID)
+
+	path := "/messages/" + conversationID.Hex()
+	code, res := bob.request(http.MethodGet, path, nil)
+	page, _ := res["messages"].([]any)
+	if code != http.StatusOK || len(page) != 2 || page[0].(map[string]any)["_id"] != messages[2].ID.Hex() {
+		t.Fatalf("first page: %d %v", code, res)
+	}
+	code, res = bob.request(http.MethodGet, path+"?page=2", nil)
+	page, _ = res["messages"].([]any)
+	if code != http.StatusOK || len(page) != 1 || page[0].(map[string]any)["_id"] != messages[0].ID.Hex() {
+		t.Fatalf("second page: %d %v", code, res)
+	}
+	if code, _ := carol.request(http.MethodGet, path, nil); code != http.StatusBadRequest {
+		t.Fatalf("getting another conversation's messages: %d", code)
+	}
+
+	// only the sender can delete a message.
+	if code, _ := bob.request(http.MethodDelete, "/message/"+messages[2].ID.Hex(), nil); code != http.StatusNotFound {
+		t.Fatalf("deleting someone else's message: %d", code)
+	}
+	if code, res := alice.request(http.MethodDelete, "/message/"+messages[2].ID.Hex(), nil); code != http.StatusOK {
+		t.Fatalf("deleting: %d %v", code, res)
+	}
+	h.waitForBackground(t)
+
+	if _, err := h.server.Messages.FindBySender(ctx, messages[2].ID, alice.ID); err == nil {
+		t.Fatal("message wasn't deleted")
+	}
+	if conversation, _ := h.server.Conversations.FindWithParticipant(ctx, conversationID, alice.ID); conversation.LastMessage != messages[1].ID {
+		t.Fatalf("last message wasn't updated: %v", conversation.LastMessage.Hex())
+	}
+}
+
+func TestMediaHasItsOwnRateLimit(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) {
+		cfg.Server.RateInterval = time.Hour
+		cfg.Server.RateBurst = 3
+	})
+	// registering and logging in take two of the three requests.
+	alice := h.register(t, "Alice", "alice@example.com")
+
+	for range 20 {
+		if code, res := alice.request(http.MethodGet, "/signed/avatars/nope?exp=0&sig=nope", nil); code != http.StatusForbidden {
+			t.Fatalf("getting media: %d %v", code, res)
+		}
+	}
+
+	if code, _ := alice.request(http.MethodGet, "/users?search=ali", nil); code != http.StatusOK {
+		t.Fatalf("the api's last request: %d", code)
+	}
+	if code, _ := alice.request(http.MethodGet, "/users?search=ali", nil); code != http.StatusTooManyRequests {
+		t.Fatalf("the api wasn't rate limited: %d", code)
+	}
+}
+
+// the body is cut off while it's read, not only checked once the whole file was received.
+func TestOversizedUploads(t *testing.T) {
+	h := newHarness(t, func(cfg *config.Config) { cfg.Media.MaxUploadBytes = 1 << 10 })
+	alice := h.register(t, "Alice", "alice@example.com")
+	oversized := bytes.Repeat([]byte{0}, 1<<20)
+
+	for _, upload := range []struct{ method, path, field string }{
+		{http.MethodPost, "/image", "image"},
+		{http.MethodPost, "/audio", "audio"},
+		{http.MethodPut, "/user/avatar", "file"},
+		{http.MethodPost, "/register", "avatar"},
+	} {
+		var body bytes.Buffer
+		form := multipart.NewWriter(&body)
+		form.WriteField("name", "Mallory")
+		form.WriteField("email", "mallory@example.com")
+		form.WriteField("password", "correct horse battery staple")
+		file, _ := form.CreateFormFile(upload.field, "file")
+		file.Write(oversized)
+		form.Close()
+
+		if code, res := alice.do(upload.method, upload.path, form.FormDataContentType(), &body); code != http.StatusRequestEntityTooLarge {
+			t.Fatalf("uploading to %s: %d %v", upload.path, code, res)
+		}
+	}
+}
+
+// of the confirms racing for the same upload, only one gets to process it.
+func TestConfirmUploadOnce(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+
+	img := avatarPNG(t)
+	upload := redis.PendingUpload{UserID: alice.ID.Hex(), RawKey: "chatify-3/raw/race", ContentType: "image/png", Size: int64(len(img)), Status: redis.UploadPending}
+	if err := h.server.Cache.SetPendingUpload(context.Background(), "race", upload, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.server.Media.Store.Put(context.Background(), upload.RawKey, bytes.NewReader(img), upload.Size, upload.ContentType); err != nil {
+		t.Fatal(err)
+	}
+
+	codes := make(chan int, 10)
+	// every confirm has checked the upload is pending by the time the object is looked up.
+	h.server.Media.Store = &barrierStat{Storage: h.server.Media.Store, waiting: cap(codes), release: make(chan struct{})}
+	var wg sync.WaitGroup
+	for range cap(codes) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, _ := alice.request(http.MethodPost, "/image/confirm", gin.H{"uploadId": "race"})
+			codes <- code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	if counts[http.StatusAccepted] != 1 || counts[http.StatusConflict] != cap(codes)-1 {
+		t.Fatalf("confirming concurrently: %v", counts)
+	}
+	h.waitForBackground(t)
+}
+
+// barrierStat holds Stat calls until waiting of them were made.
+type barrierStat struct {
+	storage.Storage
+	mu      sync.Mutex
+	waiting int
+	release chan struct{}
+}
+
+func (b *barrierStat) Stat(ctx context.Context, key string) (storage.Object, error) {
+	b.mu.Lock()
+	if b.waiting--; b.waiting == 0 {
+		close(b.release)
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-b.release:
+	case <-time.After(eventTimeout):
+	}
+
+	return b.Storage.Stat(ctx, key)
+}
+
+// without METRICS_ADDR the metrics are on the main server, for admins only.
+func TestMetricsAreAdminOnly(t *testing.T) {
+	// filled in once the admin is registered, IsAdmin shares the slice.
+	admins := []string{""}
+	h := newHarness(t, func(cfg *config.Config) { cfg.Auth.Admins = admins })
+	alice := h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bob", "bob@example.com")
+	admins[0] = alice.ID.Hex()
+
+	scrape := func(c *client) int {
+		req, _ := http.NewRequest(http.MethodGet, h.http.URL+"/metrics", nil)
+		if c != nil {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+
+	if code := scrape(nil); code != http.StatusUnauthorized {
+		t.Fatalf("scraping anonymously: %d", code)
+	}
+	if code := scrape(bob); code != http.StatusForbidden {
+		t.Fatalf("scraping as a user: %d", code)
+	}
+	if code := scrape(alice); code != http.StatusOK {
+		t.Fatalf("scraping as an admin: %d", code)
+	}
+}
+
+// Logs in without a client, returns the status and the Retry-After header.
+func login(t *testing.T, h *harness, email, password string) (int, string) {
+	t.Helper()
+
+	body, _ := json.Marshal(gin.H{"email": email, "password": password})
+	res, err := http.Post(h.http.URL+"/login", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	return res.StatusCode, res.Header.Get("Retry-After")
+}
+
+// an account gets 3 free failures, then waits 1s, 2s, 4s... and is locked for 15 minutes at the 10th.
+func TestLoginBackoffAndLockout(t *testing.T) {
+	h := newHarness(t)
+	alice := h.register(t, "Alice", "alice@example.com")
+	alice.connect()
+	const password = "correct horse battery staple"
+
+	for attempt := 1; attempt <= 3; attempt++ {
+		if code, retry := login(t, h, "alice@example.com", "wrong"); code != http.StatusBadRequest || retry != "" {
+			t.Fatalf("free failure %d: %d %q", attempt, code, retry)
+		}
+	}
+	for attempt, wait := 4, 1; attempt < 10; attempt, wait = attempt+1, wait*2 {
+		if code, _ := login(t, h, "alice@example.com", "wrong"); code != http.StatusBadRequest {
+			t.Fatalf("failure %d: %d", attempt, code)
+		}
+		// even the right password waits, and the email is matched case insensitively.
+		if code, retry := login(t, h, "ALICE@example.com", password); code != http.StatusTooManyRequests || retry != strconv.Itoa(wait) {
+			t.Fatalf("after failure %d: %d, Retry-After %q, want %d", attempt, code, retry, wait)
+		}
+		h.redis.FastForward(time.Duration(wait) * time.Second)
+	}
+
+	if code, _ := login(t, h, "alice@example.com", "wrong"); code != http.StatusBadRequest {
+		t.Fatalf("10th failure: %d", code)
+	}
+	if event := alice.next(); event["type"] != "security" || event["event"] != "lockout" {
+		t.Fatalf("lockout event: %v", event)
+	}
+	if code, retry := login(t, h, "alice@example.com", password); code != http.StatusTooManyRequests || retry != "900" {
+		t.Fatalf("after the lockout: %d, Retry-After %q", code, retry)
+	}
+	h.redis.FastForward(15 * time.Minute)
+	if code, _ := login(t, h, "alice@example.com", password); code != http.StatusOK {
+		t.Fatalf("once the lockout expired: %d", code)
+	}
+}
+
+// a successful login forgets the account's failures.
+func TestLoginResetsFailures(t *testing.T) {
+	h := newHarness(t)
+	h.register(t, "Alice", "alice@example.com")
+	const password = "correct horse battery staple"
+
+	for range 2 {
+		for range 3 {
+			login(t, h, "alice@example.com", "wrong")
+		}
+		if code, _ := login(t, h, "alice@example.com", password); code != http.StatusOK {
+			t.Fatalf("logging in: %d", code)
+		}
+	}
+	if code, retry := login(t, h, "alice@example.com", "wrong"); code != http.StatusBadRequest || retry != "" {
+		t.Fatalf("4th failure, but the first since logging in: %d %q", code, retry)
+	}
+}
+
+// an ip gets 10 free failures across accounts, then backs off up to 15 minutes and is locked at the 50th.
+func TestLoginIPBackoff(t *testing.T) {
+	h := newHarness(t)
+	h.register(t, "Alice", "alice@example.com")
+
+	for attempt := 1; attempt <= 50; attempt++ {
+		// a new account every time, so only the ip's failures add up.
+		if code, _ := login(t, h, fmt.Sprintf("mallory%d@example.com", attempt), "wrong"); code != http.StatusBadRequest {
+			t.Fatalf("failure %d: %d", attempt, code)
+		}
+
+		wait := ""
+		switch {
+		case attempt > 10 && attempt < 21:
+			wait = strconv.Itoa(1 << (attempt - 11))
+		case attempt >= 21:
+			// 1<<10 seconds and more are capped.
+			wait = "900"
+		}
+		code, retry := login(t, h, "alice@example.com", "correct horse battery staple")
+		if retry != wait || (wait == "") != (code == http.StatusOK) {
+			t.Fatalf("after failure %d: %d, Retry-After %q, want %q", attempt, code, retry, wait)
+		}
+		if code == http.StatusOK {
+			continue
+		}
+		h.redis.FastForward(15 * time.Minute)
+	}
+}
+
+// an email without passkeys gets the same discoverable challenge as an unknown one or none at all,
+// only an account with passkeys gets its credentials back.
+func TestBeginPasskeyLogin(t *testing.T) {
+	h := newHarness(t)
+	h.register(t, "Alice", "alice@example.com")
+	bob := h.register(t, "Bobby", "bob@example.com")
+	passkey := &models.Passkey{UserID: bob.ID, Name: "Laptop", Credential: webauthn.Credential{ID: []byte("bob-credential")}}
+	if err := h.server.Passkeys.Save(context.Background(), passkey); err != nil {
+		t.Fatal(err)
+	}
+
+	anonymous := &client{t: t, h: h}
+	begin := func(body gin.H) map[string]any {
+		t.Helper()
+		code, res := anonymous.request(http.MethodPost, "/login/passkey/begin", body)
+		options, _ := res["options"].(map[string]any)
+		publicKey, _ := options["publicKey"].(map[string]any)
+		if code != http.StatusOK || res["sessionId"] == "" || publicKey["challenge"] == nil {
+			t.Fatalf("beginning a passkey login with %v: %d %v", body, code, res)
+		}
+		// the challenge is random, the rest has to match.
+		delete(publicKey, "challenge")
+		return options
+	}
+
+	discoverable := begin(gin.H{})
+	for _, email := range []string{"alice@example.com", "nobody@example.com"} {
+		if options := begin(gin.H{"email": email}); !reflect.DeepEqual(options, discoverable) {
+			t.Errorf("%s: %v, want %v", email, options, discoverable)
+		}
+	}
+
+	options := begin(gin.H{"email": "Bob@example.com"})
+	allowed, _ := options["publicKey"].(map[string]any)["allowCredentials"].([]any)
+	if len(allowed) != 1 {
+		t.Fatalf("bob's credentials: %v", options)
+	}
+}
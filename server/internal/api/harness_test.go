@@ -0,0 +1,349 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/ws"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// how long a client waits for an event before failing.
+const eventTimeout = time.Second * 5
+
+// harness runs the whole router, wired like main, over http with in-process stand-ins: the in-memory
+// repositories for mongo, miniredis for redis and the memory storage driver.
+type harness struct {
+	server *Server
+	http   *httptest.Server
+	// e.g. to fast-forward the ttls of lockouts.
+	redis *miniredis.Miniredis
+}
+
+// configure changes the config before anything is built from it.
+func newHarness(t *testing.T, configure ...func(cfg *config.Config)) *harness {
+	gin.SetMode(gin.TestMode)
+
+	cfg := config.Default()
+	cfg.Log.Level = "error"
+	cfg.Server.CORSOrigins = []string{"http://localhost:3000"}
+	cfg.Server.RateInterval = time.Millisecond
+	cfg.Server.RateBurst = 1000
+	cfg.Server.WSRate = 100
+	cfg.Server.WSBurst = 100
+	cfg.Storage.Driver = config.DriverMemory
+	cfg.Auth.Secret = "e2e-secret"
+	cfg.Auth.Argon2Memory = 1024
+	cfg.Auth.Argon2Time = 1
+	cfg.Media.SigningKey = cfg.Auth.Secret
+	for _, change := range configure {
+		change(cfg)
+	}
+
+	logging.Init(cfg.Log)
+
+	keys, err := utils.LoadKeyring(cfg.Auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	webAuthn, err := utils.NewWebAuthn(cfg.WebAuthn.RPID, cfg.Server.CORSOrigins)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := storage.New(cfg.Storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mini := miniredis.RunT(t)
+	cfg.Redis.Addr = mini.Addr()
+	cfg.Redis.Username = ""
+	background := &utils.Background{}
+	cache, err := redis.New(cfg.Redis, store, background)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cache.Close() })
+
+	memory := models.NewMemory(cfg.Server.PageSize)
+	hub := ws.NewHub(cfg.Server, memory.Conversations, memory.Messages, cache, background)
+	server := &Server{
+		Config:        cfg,
+		Users:         memory.Users,
+		Conversations: memory.Conversations,
+		Messages:      memory.Messages,
+		Passkeys:      memory.Passkeys,
+		Media:         utils.NewMedia(cfg.Media, store, cache.EnqueueDeletion, background),
+		Cache:         cache,
+		Hub:           hub,
+		Keys:          keys,
+		WebAuthn:      webAuthn,
+		Passwords:     utils.NewPasswordHasher(cfg.Auth),
+		Background:    background,
+		Dependencies: map[string]func(context.Context) error{
+			"redis":   cache.Ping,
+			"storage": func(ctx context.Context) error { return storage.Ping(ctx, store) },
+		},
+	}
+
+	h := &harness{server: server, http: httptest.NewServer(server.Router()), redis: mini}
+	// shuts down like main: the websocket connections first, then the http server and background work.
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+		defer cancel()
+		hub.Drain(ctx)
+		h.http.Close()
+		background.Wait(ctx)
+	})
+
+	return h
+}
+
+// client is a registered user talking to the harness over http and, once connected, a websocket.
+type client struct {
+	t     *testing.T
+	h     *harness
+	ID    bson.ObjectID
+	token string
+
+	conn   *websocket.Conn
+	events chan json.RawMessage
+}
+
+func avatarPNG(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for x := range 16 {
+		for y := range 16 {
+			img.Set(x, y, color.RGBA{R: uint8(x * 16), G: uint8(y * 16), B: 128, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// Registers a user through /register, logs in and signs a token for them like the frontend does.
+func (h *harness) register(t *testing.T, name, email string) *client {
+	t.Helper()
+	const password = "correct horse battery staple"
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("name", name)
+	form.WriteField("email", email)
+	form.WriteField("password", password)
+	avatar, _ := form.CreateFormFile("avatar", "avatar.png")
+	avatar.Write(avatarPNG(t))
+	form.Close()
+
+	c := &client{t: t, h: h}
+	code, res := c.do(http.MethodPost, "/register", form.FormDataContentType(), &body)
+	if code != http.StatusCreated {
+		t.Fatalf("registering %s: %d %v", email, code, res)
+	}
+
+	code, res = c.request(http.MethodPost, "/login", gin.H{"email": email, "password": password})
+	if code != http.StatusOK {
+		t.Fatalf("logging in %s: %d %v", email, code, res)
+	}
+	user, _ := res["user"].(map[string]any)
+	id, _ := user["id"].(string)
+
+	var err error
+	if c.ID, err = bson.ObjectIDFromHex(id); err != nil {
+		t.Fatalf("logging in %s responded with user %v", email, user)
+	}
+	if c.token, err = h.server.Keys.SignToken(id, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	return c
+}
+
+// Waits for the work handlers left running in the background.
+func (h *harness) waitForBackground(t *testing.T) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventTimeout)
+	defer cancel()
+	if err := h.server.Background.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func (c *client) do(method, path, contentType string, body *bytes.Buffer) (int, map[string]any) {
+	c.t.Helper()
+
+	req, err := http.NewRequest(method, c.h.http.URL+path, body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	var decoded map[string]any
+	if err := json.NewDecoder(res.Body).Decode(&decoded); err != nil {
+		c.t.Fatalf("%s %s responded with invalid json: %v", method, path, err)
+	}
+
+	return res.StatusCode, decoded
+}
+
+// Sends an authenticated json request, returns the status and the decoded response.
+func (c *client) request(method, path string, body any) (int, map[string]any) {
+	c.t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&buf).Encode(body)
+	}
+
+	return c.do(method, path, "application/json", &buf)
+}
+
+// Opens the client's websocket and waits for the server to register it.
+func (c *client) connect() {
+	c.t.Helper()
+
+	url := "ws" + strings.TrimPrefix(c.h.http.URL, "http") + "/ws?token=" + c.token
+	conn, res, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		c.t.Fatalf("connecting: %v (%v)", err, res)
+	}
+	c.conn = conn
+	c.events = make(chan json.RawMessage, 64)
+	go func(events chan<- json.RawMessage) {
+		defer close(events)
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			events <- data
+		}
+	}(c.events)
+
+	c.waitFor(func() bool { _, ok := c.h.server.Hub.GetConn(c.ID); return ok })
+}
+
+// Closes the client's websocket and waits for the server to unregister it.
+func (c *client) disconnect() {
+	c.t.Helper()
+
+	c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	c.conn.Close()
+	c.waitFor(func() bool { _, ok := c.h.server.Hub.GetConn(c.ID); return !ok })
+}
+
+func (c *client) waitFor(condition func() bool) {
+	c.t.Helper()
+
+	deadline := time.Now().Add(eventTimeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			c.t.Fatal("timed out waiting for the hub")
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+}
+
+func (c *client) send(payload any) {
+	c.t.Helper()
+
+	if err := c.conn.WriteJSON(payload); err != nil {
+		c.t.Fatal("sending:", err)
+	}
+}
+
+// Returns the next event the client received, decoded.
+func (c *client) next() map[string]any {
+	c.t.Helper()
+
+	select {
+	case data, ok := <-c.events:
+		if !ok {
+			c.t.Fatal("the connection was closed while waiting for an event")
+		}
+		var event map[string]any
+		if err := json.Unmarshal(data, &event); err != nil {
+			c.t.Fatalf("received invalid json %q", data)
+		}
+		return event
+	case <-time.After(eventTimeout):
+		c.t.Fatal("timed out waiting for an event")
+		return nil
+	}
+}
+
+// Fails unless the next event is exactly want once both are encoded as json.
+func (c *client) expect(want any) map[string]any {
+	c.t.Helper()
+
+	got := c.next()
+	if gotJSON, wantJSON := jsonOf(c.t, got), jsonOf(c.t, want); gotJSON != wantJSON {
+		c.t.Fatalf("unexpected event\n got: %s\nwant: %s", gotJSON, wantJSON)
+	}
+
+	return got
+}
+
+// Fails if the client receives an event it wasn't expecting.
+func (c *client) expectNothing() {
+	c.t.Helper()
+
+	select {
+	case data, ok := <-c.events:
+		if ok {
+			c.t.Fatalf("unexpected event %s", data)
+		}
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+// Encodes v as json with its keys sorted at every level, so values encoded differently (structs and the
+// maps they're decoded into) can be compared.
+func jsonOf(t *testing.T, v any) string {
+	t.Helper()
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded any
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	data, _ = json.Marshal(decoded)
+
+	return string(data)
+}
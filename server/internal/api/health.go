@@ -0,0 +1,129 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// set at build time with -ldflags "-X github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/api.Version=...".
+var Version = "dev"
+
+var startedAt = time.Now()
+
+// how long each dependency has to answer.
+const checkTimeout = time.Second * 2
+
+type dependencyStatus struct {
+	OK        bool    `json:"ok"`
+	LatencyMS float64 `json:"latencyMs"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// Checks every dependency at once, returns their status and whether all of them are reachable.
+func (s *Server) checkDependencies(ctx context.Context) (map[string]dependencyStatus, bool) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[string]dependencyStatus, len(s.Dependencies))
+	allOK := true
+
+	for name, check := range s.Dependencies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			start := time.Now()
+			err := check(checkCtx)
+
+			status := dependencyStatus{OK: err == nil, LatencyMS: float64(time.Since(start).Microseconds()) / 1000}
+			if err != nil {
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			statuses[name] = status
+			allOK = allOK && status.OK
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return statuses, allOK
+}
+
+// liveness: the process is up and serving requests.
+func healthz(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// readiness: every dependency is reachable and the server isn't shutting down.
+// errors are left out, they're only shown to admins (see getDiagnostics).
+func (s *Server) readyz(ctx *gin.Context) {
+	statuses, ok := s.checkDependencies(ctx.Request.Context())
+	for name, status := range statuses {
+		status.Error = ""
+		statuses[name] = status
+	}
+
+	if !ok || s.Hub.Draining() {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "draining": s.Hub.Draining(), "dependencies": statuses})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"status": "ready", "dependencies": statuses})
+}
+
+func (s *Server) getDiagnostics(ctx *gin.Context) {
+	statuses, _ := s.checkDependencies(ctx.Request.Context())
+
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	diagnostics := gin.H{
+		"build":        buildInfo(),
+		"startedAt":    startedAt,
+		"uptime":       time.Since(startedAt).Round(time.Second).String(),
+		"draining":     s.Hub.Draining(),
+		"connections":  gin.H{"websocket": len(s.Hub.GetAllConns())},
+		"dependencies": statuses,
+		"runtime": gin.H{
+			"goroutines": runtime.NumGoroutine(),
+			"heapAlloc":  mem.HeapAlloc,
+			"heapSys":    mem.HeapSys,
+			"numGC":      mem.NumGC,
+		},
+	}
+	if stats, err := s.Cache.GetDeletionStats(ctx.Request.Context()); err == nil {
+		diagnostics["deletions"] = stats
+	}
+
+	ctx.JSON(http.StatusOK, diagnostics)
+}
+
+func buildInfo() gin.H {
+	build := gin.H{"version": Version, "goVersion": runtime.Version()}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return build
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build["revision"] = setting.Value
+		case "vcs.time":
+			build["revisionTime"] = setting.Value
+		case "vcs.modified":
+			build["modified"] = setting.Value == "true"
+		}
+	}
+
+	return build
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/ws"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Rejects the request with 429 if the account or the client's ip is locked out because of failed password attempts.
+// returns true if the request may continue. redis errors are logged and the request is allowed.
+func (s *Server) checkLoginLockout(ctx *gin.Context, email string) bool {
+	wait, err := s.Cache.GetLoginLockout(ctx.Request.Context(), email, ctx.ClientIP())
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't check login lockout", "error", err)
+		return true
+	}
+	if wait > 0 {
+		abortLockedOut(ctx, wait)
+		return false
+	}
+
+	return true
+}
+
+// Records a failed password attempt. if the attempt locked the account, a "security" event is sent to the user
+// and published on redis.
+func (s *Server) registerLoginFailure(ctx *gin.Context, email string, userID bson.ObjectID) {
+	ip := ctx.ClientIP()
+	// counted even if the client hung up, closing the connection must not be a way around the backoff.
+	failure, err := s.Cache.RegisterLoginFailure(context.WithoutCancel(ctx.Request.Context()), email, ip)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't register login failure", "error", err)
+		return
+	}
+
+	if failure.Locked {
+		until := time.Now().Add(failure.RetryAfter)
+		logging.From(ctx.Request.Context()).Warn("Account locked", "email", email, "until", until, "attempts", failure.Attempts, "ip", ip)
+		bgCtx := context.WithoutCancel(ctx.Request.Context())
+		s.Background.Go(func() { s.notifyLockout(bgCtx, email, ip, userID, until) })
+	}
+}
+
+func (s *Server) notifyLockout(ctx context.Context, email, ip string, userID bson.ObjectID, until time.Time) {
+	if err := s.Cache.PublishLockout(ctx, email, ip, until); err != nil {
+		logging.From(ctx).Error("Couldn't publish lockout event", "error", err)
+	}
+
+	if userID.IsZero() {
+		return
+	}
+	if conn, ok := s.Hub.GetConn(userID); ok && conn != nil {
+		if err := ws.SendJSON(ctx, conn, gin.H{"type": "security", "event": "lockout", "until": until}); err != nil {
+			logging.From(ctx).Warn("Couldn't send lockout event via ws", "error", err)
+		}
+	}
+}
+
+func abortLockedOut(ctx *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	ctx.Header("Retry-After", strconv.Itoa(seconds))
+	ctx.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+		"message": fmt.Sprintf("Too many failed attempts, please try again in %d seconds.", seconds)})
+}
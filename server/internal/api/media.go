@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Serves a stored file to a user allowed to see it (see canAccessMedia). stored keys never change, so the
+// response can be cached for good.
+func (s *Server) serveMedia(ctx *gin.Context) {
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	allowed, err := s.canAccessMedia(ctx.Request.Context(), userID, key)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't check media access", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't get file, please try again later."})
+		return
+	}
+	if !allowed {
+		// not telling apart files that don't exist from files the user can't see.
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "File not found."})
+		return
+	}
+
+	s.serveObject(ctx, key, "private, max-age=31536000, immutable")
+}
+
+// Serves a file through a url signed by signMediaURLs, without authentication.
+func (s *Server) serveSignedMedia(ctx *gin.Context) {
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+
+	expiresAt, err := s.Media.VerifyURL(key, ctx.Query("exp"), ctx.Query("sig"))
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	}
+
+	s.serveObject(ctx, key, fmt.Sprintf("private, max-age=%d", int(time.Until(expiresAt).Seconds())))
+}
+
+// Returns short lived signed urls for the paths the user is allowed to see, for clients that can't send the
+// Authorization header (e.g. <img> and <audio> tags). paths the user can't see are left out.
+func (s *Server) signMediaURLs(ctx *gin.Context) {
+	type reqBody struct {
+		Paths []string `json:"paths" binding:"required,min=1,max=50"`
+	}
+	var body reqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"paths\" must have between 1 and 50 paths."})
+		return
+	}
+
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	type signedURL struct {
+		URL       string    `json:"url"`
+		ExpiresAt time.Time `json:"expiresAt"`
+	}
+	urls := make(map[string]signedURL, len(body.Paths))
+	for _, path := range body.Paths {
+		allowed, err := s.canAccessMedia(ctx.Request.Context(), userID, path)
+		if err != nil {
+			logging.From(ctx.Request.Context()).Error("Couldn't check media access", "error", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't sign urls, please try again later."})
+			return
+		}
+		if allowed {
+			url, expiresAt := s.Media.SignURL(path, s.Media.URLTTL)
+			urls[path] = signedURL{url, expiresAt}
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Urls signed successfully.", "urls": urls})
+}
+
+// A user can see the images and voice notes of conversations they're part of, everyone's avatars,
+// and their own uploads that weren't sent yet.
+func (s *Server) canAccessMedia(ctx context.Context, userID bson.ObjectID, key string) (bool, error) {
+	paths := utils.StoredPaths(key)
+
+	if path, err := s.Cache.GetTempImage(ctx, userID); err == nil && slices.Contains(paths, path) {
+		return true, nil
+	}
+	if path, err := s.Cache.GetTempAudio(ctx, userID); err == nil && slices.Contains(paths, path) {
+		return true, nil
+	}
+
+	message, err := s.Messages.FindByMedia(ctx, paths)
+	if err == nil {
+		_, err = s.Conversations.FindWithParticipant(ctx, message.ConversationID, userID)
+		if err == mongo.ErrNoDocuments {
+			return false, nil
+		}
+		return err == nil, err
+	} else if err != mongo.ErrNoDocuments {
+		return false, err
+	}
+
+	return s.Users.AvatarExists(ctx, paths)
+}
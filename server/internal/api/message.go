@@ -2,18 +2,18 @@ package api
 
 import (
 	"context"
-	"log"
 	"net/http"
 	"strconv"
 
-	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/ws"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 )
 
-func getMessages(ctx *gin.Context) {
+func (s *Server) getMessages(ctx *gin.Context) {
 	conversationHexID, _ := ctx.Params.Get("conversationID")
 	conversationObjectID, err := bson.ObjectIDFromHex(conversationHexID)
 	if err != nil {
@@ -27,7 +27,7 @@ func getMessages(ctx *gin.Context) {
 		return
 	}
 
-	conversation, err := models.FindConversation(bson.M{"_id": conversationObjectID, "participants": userObjectID})
+	conversation, err := s.Conversations.FindWithParticipant(ctx.Request.Context(), conversationObjectID, userObjectID)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Conversation not found."})
 		return
@@ -38,9 +38,9 @@ func getMessages(ctx *gin.Context) {
 		page = 1
 	}
 
-	messages, err := conversation.GetMessages(page)
+	messages, err := s.Messages.GetMessages(ctx.Request.Context(), conversation.ID, page)
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx.Request.Context()).Error("Couldn't fetch messages", "conversation_id", conversation.ID.Hex(), "error", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't fetch messages."})
 		return
 	}
@@ -48,7 +48,7 @@ func getMessages(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"message": "Messages fetched successfully", "messages": messages})
 }
 
-func deleteMessage(ctx *gin.Context) {
+func (s *Server) deleteMessage(ctx *gin.Context) {
 	messageHexID, _ := ctx.Params.Get("messageID")
 	messageID, err := bson.ObjectIDFromHex(messageHexID)
 	if err != nil {
@@ -62,20 +62,21 @@ func deleteMessage(ctx *gin.Context) {
 		return
 	}
 
-	message, err := models.GetMessage(bson.M{"_id": messageID, "sender": userID})
+	message, err := s.Messages.FindBySender(ctx.Request.Context(), messageID, userID)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"message": "Message not found (1)."})
 		return
 	}
 
-	conversation, err := models.FindConversation(bson.M{"_id": message.ConversationID, "participants": userID})
+	conversation, err := s.Conversations.FindWithParticipant(ctx.Request.Context(), message.ConversationID, userID)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"message": "Couldn't delete message, conversation not found."})
 		return
 	}
 
-	err = message.Delete()
-	go utils.DeleteFile(message.Image)
+	err = s.Messages.Delete(ctx.Request.Context(), message.ID)
+	s.Media.DeleteLater(message.Image)
+	s.Media.DeleteLater(message.Audio)
 
 	if err == mongo.ErrNoDocuments {
 		ctx.JSON(http.StatusNotFound, gin.H{"message": "Message not found (2)."})
@@ -85,12 +86,13 @@ func deleteMessage(ctx *gin.Context) {
 		return
 	}
 
-	go conversation.UpdateLastMessage(bson.NilObjectID)
+	bgCtx := context.WithoutCancel(ctx.Request.Context())
+	s.Background.Go(func() { s.Conversations.UpdateLastMessage(bgCtx, conversation.ID, bson.NilObjectID) })
 
 	otherUserID := utils.GetOtherParticipant(userID, conversation.Participants)
-	if conn, ok := Manager.GetConn(otherUserID); ok && conn != nil {
-		if err = conn.SendJSON(context.Background(), gin.H{"type": "delete", "messageId": messageID}); err != nil {
-			log.Println("Coudn't send delete update via ws to other user.")
+	if conn, ok := s.Hub.GetConn(otherUserID); ok && conn != nil {
+		if err = ws.SendJSON(ctx.Request.Context(), conn, gin.H{"type": "delete", "messageId": messageID}); err != nil {
+			logging.From(ctx.Request.Context()).Warn("Couldn't send message deletion via ws", "message_id", messageID, "error", err)
 		}
 	}
 
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+// Redirects the browser to the provider's authorization endpoint.
+func (s *Server) startOAuth(ctx *gin.Context) {
+	provider, ok := s.OIDCProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Unknown provider."})
+		return
+	}
+
+	state, nonce, verifier := utils.NewOIDCSecrets()
+	err := s.Cache.SetOAuthState(ctx.Request.Context(), state, redis.OAuthState{Provider: provider.Name, Nonce: nonce, Verifier: verifier})
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't save OIDC state", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong, please try again later."})
+		return
+	}
+
+	setOAuthStateCookie(ctx, provider.Name, oauthStateHash(state), int(redis.OAuthStateTTL.Seconds()))
+	ctx.Redirect(http.StatusFound, provider.AuthURL(state, nonce, verifier))
+}
+
+// Handles the provider's redirect: validates the state, exchanges the code and links or creates the user.
+// the browser is sent back to the frontend with a one-time login code, or with an error.
+func (s *Server) oauthCallback(ctx *gin.Context) {
+	provider, ok := s.OIDCProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Unknown provider."})
+		return
+	}
+
+	// the state must come back to the browser that started the login, otherwise an attacker could have
+	// the victim's browser finish the attacker's login (login CSRF).
+	cookie, _ := ctx.Cookie(oauthStateCookie)
+	setOAuthStateCookie(ctx, provider.Name, "", -1)
+
+	if providerErr := ctx.Query("error"); providerErr != "" {
+		s.redirectToFrontend(ctx, url.Values{"error": {providerErr}})
+		return
+	}
+
+	if cookie == "" || subtle.ConstantTimeCompare([]byte(cookie), []byte(oauthStateHash(ctx.Query("state")))) != 1 {
+		s.redirectToFrontend(ctx, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	state, err := s.Cache.GetOAuthState(ctx.Request.Context(), ctx.Query("state"))
+	if err != nil || state.Provider != provider.Name {
+		s.redirectToFrontend(ctx, url.Values{"error": {"invalid_state"}})
+		return
+	}
+
+	exchangeCtx, cancel := context.WithTimeout(ctx.Request.Context(), time.Second*10)
+	defer cancel()
+	claims, err := provider.Exchange(exchangeCtx, ctx.Query("code"), state.Verifier, state.Nonce)
+	if err == utils.ErrEmailUnverified {
+		s.redirectToFrontend(ctx, url.Values{"error": {"email_unverified"}})
+		return
+	} else if err != nil {
+		logging.From(ctx.Request.Context()).Warn("OIDC exchange failed", "provider", provider.Name, "error", err)
+		s.redirectToFrontend(ctx, url.Values{"error": {"exchange_failed"}})
+		return
+	}
+
+	user, err := s.findOrCreateOIDCUser(ctx.Request.Context(), provider.Name, claims)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't find or create OIDC user", "provider", provider.Name, "error", err)
+		s.redirectToFrontend(ctx, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	code, err := s.Cache.SetLoginCode(ctx.Request.Context(), user.ID)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't save login code", "error", err)
+		s.redirectToFrontend(ctx, url.Values{"error": {"server_error"}})
+		return
+	}
+
+	s.redirectToFrontend(ctx, url.Values{"code": {code}})
+}
+
+// Exchanges the one-time code from the oauth callback for the user, same response as login.
+func (s *Server) exchangeLoginCode(ctx *gin.Context) {
+	type reqBody struct {
+		Code string `json:"code" binding:"required"`
+	}
+	var body reqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"code\" is required."})
+		return
+	}
+
+	userID, err := s.Cache.GetLoginCode(ctx.Request.Context(), body.Code)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid or expired code."})
+		return
+	}
+
+	user, err := s.Users.FindByID(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid or expired code."})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logged in successfully.", "user": gin.H{
+		"id":     user.ID,
+		"email":  user.Email,
+		"name":   user.Name,
+		"avatar": user.Avatar,
+	}})
+}
+
+// Finds the user linked to the external identity. otherwise links the identity to the user with the same (verified)
+// email, or creates a new user without a password.
+func (s *Server) findOrCreateOIDCUser(ctx context.Context, provider string, claims utils.OIDCClaims) (models.User, error) {
+	user, err := s.Users.FindByIdentity(ctx, provider, claims.Subject)
+	if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	email := models.NormalizeEmail(claims.Email)
+	identity := models.Identity{Provider: provider, Subject: claims.Subject, Email: email, LinkedAt: time.Now()}
+
+	user, err = s.Users.FindByEmail(ctx, email)
+	if err == nil {
+		return user, s.Users.LinkIdentity(ctx, user.ID, identity)
+	} else if err != mongo.ErrNoDocuments {
+		return user, err
+	}
+
+	user = models.User{Name: oidcUserName(claims), Email: email, Identities: []models.Identity{identity}}
+	err = s.Users.Save(ctx, &user)
+
+	return user, err
+}
+
+// the name must be 3-30 letters like in register, falls back to the email's local part.
+func oidcUserName(claims utils.OIDCClaims) string {
+	name := strings.TrimSpace(claims.Name)
+	if len([]rune(name)) < 3 {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	for len([]rune(name)) < 3 {
+		name += "_"
+	}
+	if runes := []rune(name); len(runes) > 30 {
+		name = string(runes[:30])
+	}
+
+	return name
+}
+
+const oauthStateCookie = "oauth_state"
+
+// The cookie binding the login to the browser holds a hash of the state, and is only sent to the provider's callback.
+func setOAuthStateCookie(ctx *gin.Context, provider, value string, maxAge int) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     oauthStateCookie,
+		Value:    value,
+		Path:     "/oauth/" + provider,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func oauthStateHash(state string) string {
+	hash := sha256.Sum256([]byte(state))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+func (s *Server) redirectToFrontend(ctx *gin.Context, query url.Values) {
+	ctx.Redirect(http.StatusFound, strings.TrimSuffix(s.Config.Server.FrontendURL, "/")+"/oauth?"+query.Encode())
+}
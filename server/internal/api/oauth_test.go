@@ -0,0 +1,156 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils/oidctest"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var oidcUser = jwt.MapClaims{"sub": "42", "email": "jane@chatify.dev", "email_verified": true, "name": "Jane"}
+
+// Registers the mock provider as "mock" on the harness.
+func setupOAuth(t *testing.T) (*harness, *oidctest.Provider) {
+	h := newHarness(t)
+	mock := oidctest.New(t)
+	provider, err := utils.NewOIDCProvider(context.Background(), "mock", mock.URL, oidctest.ClientID, oidctest.ClientSecret, h.http.URL+"/oauth/mock/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.server.OIDCProviders = map[string]*utils.OIDCProvider{"mock": provider}
+
+	return h, mock
+}
+
+// Sends a GET without following the redirect, returns the response (with its body closed).
+func getNoRedirect(t *testing.T, rawURL string, cookies ...*http.Cookie) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	client := http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	return res
+}
+
+// Starts a login like the browser would for the user with claims, returns the provider's code and state, and the
+// state cookie.
+func startLogin(t *testing.T, h *harness, mock *oidctest.Provider, claims jwt.MapClaims) (code, state string, cookie *http.Cookie) {
+	t.Helper()
+
+	res := getNoRedirect(t, h.http.URL+"/oauth/mock")
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("starting the login: %d", res.StatusCode)
+	}
+	for _, c := range res.Cookies() {
+		if c.Name == oauthStateCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil || !cookie.HttpOnly || !cookie.Secure || cookie.SameSite != http.SameSiteLaxMode || cookie.MaxAge <= 0 || cookie.Path != "/oauth/mock" {
+		t.Fatalf("unexpected state cookie: %v", res.Header.Values("Set-Cookie"))
+	}
+
+	code, state = mock.Authorize(t, res.Header.Get("Location"), claims)
+	if cookie.Value == state {
+		t.Fatal("the cookie holds the state itself")
+	}
+
+	return code, state, cookie
+}
+
+// Finishes the login at the callback, returns the query the frontend was redirected with.
+func callback(t *testing.T, h *harness, code, state string, cookies ...*http.Cookie) url.Values {
+	t.Helper()
+
+	res := getNoRedirect(t, h.http.URL+"/oauth/mock/callback?"+url.Values{"code": {code}, "state": {state}}.Encode(), cookies...)
+	location, err := url.Parse(res.Header.Get("Location"))
+	if res.StatusCode != http.StatusFound || err != nil || location.Path != "/oauth" {
+		t.Fatalf("callback: %d %q", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	cleared := false
+	for _, c := range res.Cookies() {
+		cleared = cleared || c.Name == oauthStateCookie && c.MaxAge < 0
+	}
+	if !cleared {
+		t.Fatalf("the state cookie wasn't cleared: %v", res.Header.Values("Set-Cookie"))
+	}
+
+	return location.Query()
+}
+
+func TestOAuthLogin(t *testing.T) {
+	h, mock := setupOAuth(t)
+
+	code, state, cookie := startLogin(t, h, mock, oidcUser)
+	query := callback(t, h, code, state, cookie)
+	if query.Get("code") == "" {
+		t.Fatalf("callback: %v", query)
+	}
+
+	anonymous := &client{t: t, h: h}
+	status, res := anonymous.request(http.MethodPost, "/oauth/exchange", gin.H{"code": query.Get("code")})
+	user, _ := res["user"].(map[string]any)
+	if status != http.StatusOK || user["email"] != "jane@chatify.dev" || user["name"] != "Jane" {
+		t.Fatalf("exchanging the login code: %d %v", status, res)
+	}
+}
+
+// the provider's email is matched against the registered one whatever its case.
+func TestOAuthLinksAccountWithDifferentlyCasedEmail(t *testing.T) {
+	h, mock := setupOAuth(t)
+	jane := h.register(t, "Jane", "Jane@Chatify.dev")
+
+	claims := jwt.MapClaims{"sub": "42", "email": "JANE@chatify.dev", "email_verified": true, "name": "Jane"}
+	code, state, cookie := startLogin(t, h, mock, claims)
+	query := callback(t, h, code, state, cookie)
+
+	anonymous := &client{t: t, h: h}
+	status, res := anonymous.request(http.MethodPost, "/oauth/exchange", gin.H{"code": query.Get("code")})
+	user, _ := res["user"].(map[string]any)
+	if status != http.StatusOK || user["id"] != jane.ID.Hex() || user["email"] != "jane@chatify.dev" {
+		t.Fatalf("exchanging the login code: %d %v", status, res)
+	}
+
+	if status, res := anonymous.request(http.MethodPost, "/login", gin.H{"email": "jane@CHATIFY.dev", "password": "correct horse battery staple"}); status != http.StatusOK {
+		t.Fatalf("logging in with another case: %d %v", status, res)
+	}
+}
+
+// the callback is rejected unless it comes from the browser that started the login.
+func TestOAuthCallbackRequiresStateCookie(t *testing.T) {
+	h, mock := setupOAuth(t)
+
+	// the attacker's login, finished in the victim's browser.
+	code, state, _ := startLogin(t, h, mock, oidcUser)
+	if query := callback(t, h, code, state); query.Get("error") != "invalid_state" {
+		t.Fatalf("callback without the cookie: %v", query)
+	}
+	_, _, victimCookie := startLogin(t, h, mock, oidcUser)
+	if query := callback(t, h, code, state, victimCookie); query.Get("error") != "invalid_state" {
+		t.Fatalf("callback with another login's cookie: %v", query)
+	}
+
+	// a cookie for a state that was never issued doesn't help either.
+	forged := &http.Cookie{Name: oauthStateCookie, Value: oauthStateHash("forged")}
+	if query := callback(t, h, code, "forged", forged); query.Get("error") != "invalid_state" {
+		t.Fatalf("callback with a forged state: %v", query)
+	}
+}
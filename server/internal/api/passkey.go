@@ -0,0 +1,299 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func (s *Server) beginPasskeyRegistration(ctx *gin.Context) {
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	user, err := s.passkeyUserByID(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "User doesn't exist."})
+		return
+	}
+
+	// exclude already registered credentials so the same authenticator isn't registered twice
+	creation, session, err := s.WebAuthn.BeginRegistration(user,
+		webauthn.WithExclusions(webauthn.Credentials(user.WebAuthnCredentials()).CredentialDescriptors()))
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't begin passkey registration", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't start passkey registration."})
+		return
+	}
+
+	sessionID, err := s.Cache.SetWebAuthnSession(ctx.Request.Context(), session)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't save WebAuthn session", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't start passkey registration."})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Passkey registration started.", "sessionId": sessionID, "options": creation})
+}
+
+func (s *Server) finishPasskeyRegistration(ctx *gin.Context) {
+	type reqBody struct {
+		SessionID  string          `json:"sessionId" binding:"required"`
+		Name       string          `json:"name" binding:"max=30"`
+		Credential json.RawMessage `json:"credential" binding:"required"`
+	}
+	var body reqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"sessionId\" and \"credential\" are required, name must be 30 letters at most."})
+		return
+	}
+	body.Name = strings.TrimSpace(body.Name)
+	if body.Name == "" {
+		body.Name = "Passkey"
+	}
+
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	session, err := s.Cache.GetWebAuthnSession(ctx.Request.Context(), body.SessionID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Registration session expired, please try again."})
+		return
+	}
+
+	user, err := s.passkeyUserByID(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "User doesn't exist."})
+		return
+	}
+
+	credential, err := utils.FinishPasskeyRegistration(s.WebAuthn, user, session, body.Credential)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Warn("Couldn't verify passkey registration", "error", err)
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Couldn't verify passkey."})
+		return
+	}
+
+	passkey := models.Passkey{UserID: userID, Name: body.Name, Credential: *credential}
+	if err = s.Passkeys.Save(ctx.Request.Context(), &passkey); err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't save passkey", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't save passkey, please try again later."})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Passkey registered successfully.", "passkey": passkey})
+}
+
+func (s *Server) getPasskeys(ctx *gin.Context) {
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	passkeys, err := s.Passkeys.GetPasskeys(ctx.Request.Context(), userID)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't get your passkeys, please try again later."})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Passkeys fetched successfully.", "passkeys": passkeys})
+}
+
+func (s *Server) renamePasskey(ctx *gin.Context) {
+	type reqBody struct {
+		Name string `json:"name" binding:"required,max=30"`
+	}
+	var body reqBody
+	err := ctx.ShouldBindJSON(&body)
+	body.Name = strings.TrimSpace(body.Name)
+	if err != nil || body.Name == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Name is required and must be 30 letters at most."})
+		return
+	}
+
+	passkeyHexID, _ := ctx.Params.Get("passkeyID")
+	passkeyID, err := bson.ObjectIDFromHex(passkeyHexID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid passkey id."})
+		return
+	}
+
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	err = s.Passkeys.Rename(ctx.Request.Context(), passkeyID, userID, body.Name)
+	if err == mongo.ErrNoDocuments {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Passkey not found."})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't rename passkey, please try again later."})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Passkey renamed successfully."})
+}
+
+func (s *Server) deletePasskey(ctx *gin.Context) {
+	passkeyHexID, _ := ctx.Params.Get("passkeyID")
+	passkeyID, err := bson.ObjectIDFromHex(passkeyHexID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid passkey id."})
+		return
+	}
+
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	err = s.Passkeys.Delete(ctx.Request.Context(), passkeyID, userID)
+	if err == mongo.ErrNoDocuments {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Passkey not found."})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't delete passkey, please try again later."})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Passkey deleted successfully."})
+}
+
+// Starts a passkey login. if an email of an account with passkeys is given, only its passkeys are allowed,
+// otherwise it's a discoverable login and the authenticator picks the account. an unknown email and one without
+// passkeys get the same discoverable challenge, so the response doesn't tell whether the account exists.
+func (s *Server) beginPasskeyLogin(ctx *gin.Context) {
+	type reqBody struct {
+		Email string `json:"email" binding:"omitempty,email"`
+	}
+	var body reqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil && err != io.EOF {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid email."})
+		return
+	}
+
+	var (
+		assertion any
+		session   *webauthn.SessionData
+		err       error
+		user      *models.PasskeyUser
+	)
+	if body.Email != "" {
+		user, err = s.passkeyUserByEmail(ctx.Request.Context(), models.NormalizeEmail(body.Email))
+		if err != nil && err != mongo.ErrNoDocuments {
+			logging.From(ctx.Request.Context()).Error("Couldn't find passkey user", "error", err)
+			ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't start passkey login."})
+			return
+		}
+	}
+	if user != nil && len(user.Passkeys) > 0 {
+		assertion, session, err = s.WebAuthn.BeginLogin(user)
+	} else {
+		assertion, session, err = s.WebAuthn.BeginDiscoverableLogin()
+	}
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't begin passkey login", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't start passkey login."})
+		return
+	}
+
+	sessionID, err := s.Cache.SetWebAuthnSession(ctx.Request.Context(), session)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't save WebAuthn session", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't start passkey login."})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Passkey login started.", "sessionId": sessionID, "options": assertion})
+}
+
+func (s *Server) finishPasskeyLogin(ctx *gin.Context) {
+	type reqBody struct {
+		SessionID  string          `json:"sessionId" binding:"required"`
+		Credential json.RawMessage `json:"credential" binding:"required"`
+	}
+	var body reqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"sessionId\" and \"credential\" are required."})
+		return
+	}
+
+	session, err := s.Cache.GetWebAuthnSession(ctx.Request.Context(), body.SessionID)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Login session expired, please try again."})
+		return
+	}
+
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+		if len(userHandle) != 12 {
+			return nil, errors.New("Invalid user handle.")
+		}
+		return s.passkeyUserByID(ctx.Request.Context(), bson.ObjectID(userHandle))
+	}
+
+	webauthnUser, credential, err := utils.FinishPasskeyLogin(s.WebAuthn, session, body.Credential, lookup)
+	if err == utils.ErrPasskeyCloned {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "This passkey can't be used, please sign in with your password."})
+		return
+	} else if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid passkey."})
+		return
+	}
+
+	user := webauthnUser.(*models.PasskeyUser)
+	passkey := user.FindPasskey(credential.ID)
+	if passkey == nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid passkey."})
+		return
+	}
+	if err = s.Passkeys.UpdateCredential(ctx.Request.Context(), passkey, *credential); err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't update passkey credential", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong, please try again later."})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Logged in successfully.", "user": gin.H{
+		"id":     user.User.ID,
+		"email":  user.User.Email,
+		"name":   user.User.Name,
+		"avatar": user.User.Avatar,
+	}})
+}
+
+func (s *Server) passkeyUserByID(ctx context.Context, userID bson.ObjectID) (*models.PasskeyUser, error) {
+	user, err := s.Users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Passkeys.GetPasskeyUser(ctx, user)
+}
+
+func (s *Server) passkeyUserByEmail(ctx context.Context, email string) (*models.PasskeyUser, error) {
+	user, err := s.Users.FindByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Passkeys.GetPasskeyUser(ctx, user)
+}
@@ -0,0 +1,193 @@
+package api
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/tracing"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/ws"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func (s *Server) presignUpload(ctx *gin.Context) {
+	type reqBody struct {
+		ContentType string `json:"contentType" binding:"required"`
+		Size        int64  `json:"size" binding:"required,gt=0"`
+	}
+	var body reqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"contentType\" and \"size\" are required."})
+		return
+	}
+	if !utils.IsImageContentType(body.ContentType) {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"message": utils.ErrUnsupportedImage.Error()})
+		return
+	}
+	if body.Size > s.Media.MaxUploadBytes {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": utils.ErrImageTooLarge.Error()})
+		return
+	}
+
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	uploadID := uuid.New().String()
+	rawKey := "chatify-3/raw/" + uploadID
+
+	upload, err := storage.PresignPut(ctx.Request.Context(), s.Media.Store, rawKey, body.ContentType, body.Size, s.Config.Media.PresignTTL)
+	if err == storage.ErrPresignUnsupported {
+		ctx.JSON(http.StatusNotImplemented, gin.H{"message": err.Error()})
+		return
+	} else if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't presign upload", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't create upload, please try again later."})
+		return
+	}
+
+	err = s.Cache.SetPendingUpload(ctx.Request.Context(), uploadID, redis.PendingUpload{
+		UserID:      userID.Hex(),
+		RawKey:      rawKey,
+		ContentType: body.ContentType,
+		Size:        body.Size,
+		Status:      redis.UploadPending,
+	}, s.Config.Media.PresignTTL)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't save pending upload", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't create upload, please try again later."})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Upload created.", "uploadId": uploadID, "upload": upload})
+}
+
+// Called by the client after the PUT to the presigned url succeeded. checks the stored object matches what was
+// presigned and starts processing it in the background.
+func (s *Server) confirmUpload(ctx *gin.Context) {
+	type reqBody struct {
+		UploadID string `json:"uploadId" binding:"required"`
+	}
+	var body reqBody
+	if err := ctx.ShouldBindJSON(&body); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"uploadId\" is required."})
+		return
+	}
+
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	upload, err := s.Cache.GetPendingUpload(ctx.Request.Context(), body.UploadID)
+	if err != nil || upload.UserID != userID.Hex() {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Upload not found."})
+		return
+	}
+	if upload.Status != redis.UploadPending {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "Upload was already confirmed."})
+		return
+	}
+
+	obj, err := s.Media.Store.Stat(ctx.Request.Context(), upload.RawKey)
+	if err == storage.ErrNotFound {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "The image wasn't uploaded yet."})
+		return
+	} else if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't stat upload", "upload_id", body.UploadID, "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong, please try again later."})
+		return
+	}
+
+	// another confirm of the same upload may have got here too, only the one that moves it on goes ahead.
+	if obj.Size != upload.Size || obj.ContentType != upload.ContentType {
+		err = s.Cache.ClaimUpload(ctx.Request.Context(), body.UploadID, redis.UploadPending, redis.UploadFailed)
+		if err == nil {
+			s.Media.DeleteLater(upload.RawKey)
+			ctx.JSON(http.StatusBadRequest, gin.H{"message": "Uploaded file doesn't match the requested size or type."})
+			return
+		}
+	} else {
+		err = s.Cache.ClaimUpload(ctx.Request.Context(), body.UploadID, redis.UploadPending, redis.UploadProcessing)
+	}
+	if err == redis.ErrUploadClaimed {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "Upload was already confirmed."})
+		return
+	} else if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't update upload status", "upload_id", body.UploadID, "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong, please try again later."})
+		return
+	}
+
+	bgCtx := context.WithoutCancel(ctx.Request.Context())
+	s.Background.Go(func() { s.processUpload(bgCtx, body.UploadID, upload, userID) })
+
+	ctx.JSON(http.StatusAccepted, gin.H{"message": "Processing image.", "uploadId": body.UploadID, "status": redis.UploadProcessing})
+}
+
+func (s *Server) getUploadStatus(ctx *gin.Context) {
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	upload, err := s.Cache.GetPendingUpload(ctx.Request.Context(), ctx.Param("uploadID"))
+	if err != nil || upload.UserID != userID.Hex() {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Upload not found."})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Upload fetched successfully.", "upload": upload})
+}
+
+// Produces the webp derivative and registers it as the user's pending image (like uplaodHandler does),
+// then notifies the user over ws.
+func (s *Server) processUpload(ctx context.Context, uploadID string, upload redis.PendingUpload, userID bson.ObjectID) {
+	ctx, span := tracing.Start(ctx, "upload.process", trace.WithAttributes(attribute.String("chatify.upload_id", uploadID)))
+	defer span.End()
+	logger := logging.From(ctx).With("upload_id", uploadID)
+
+	status := redis.UploadDone
+	path, meta, err := s.Media.ProcessRawUpload(ctx, upload.RawKey)
+	if err == nil {
+		err = s.Cache.SetTempImage(ctx, path, &meta, userID)
+		if err != nil {
+			s.Media.DeleteLater(path)
+		}
+	}
+	if err != nil {
+		logger.Error("Couldn't process upload", "error", err)
+		tracing.Fail(span, err)
+		status, path = redis.UploadFailed, ""
+	}
+
+	if err = s.Cache.SetUploadStatus(ctx, uploadID, status, path); err != nil {
+		logger.Error("Couldn't update upload status", "error", err)
+	}
+
+	s.notifyUpload(ctx, userID, uploadID, status, path, &meta)
+}
+
+func (s *Server) notifyUpload(ctx context.Context, userID bson.ObjectID, uploadID, status, path string, meta *utils.ImageMeta) {
+	if conn, ok := s.Hub.GetConn(userID); ok && conn != nil {
+		msg := gin.H{"type": "upload", "uploadId": uploadID, "status": status}
+		if path != "" {
+			msg["path"] = path
+			msg["imageMeta"] = meta
+		}
+		if err := ws.SendJSON(ctx, conn, msg); err != nil {
+			logging.From(ctx).Warn("Couldn't send upload status via ws", "upload_id", uploadID, "error", err)
+		}
+	}
+}
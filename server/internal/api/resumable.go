@@ -0,0 +1,256 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Resumable uploads follow the tus 1.0.0 core protocol (https://tus.io/protocols/resumable-upload) with the
+// termination extension: POST creates an upload, HEAD returns its offset and PATCH appends a chunk at that offset.
+// every chunk is stored on its own, once the last one is received they're joined into the raw object and
+// processed like a confirmed direct upload (see processUpload).
+const (
+	tusVersion   = "1.0.0"
+	maxChunkSize = 5 << 20
+)
+
+func (s *Server) createResumableUpload(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+
+	size, err := strconv.ParseInt(ctx.GetHeader("Upload-Length"), 10, 64)
+	if err != nil || size <= 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"Upload-Length\" header is required."})
+		return
+	}
+	if size > s.Media.MaxUploadBytes {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": utils.ErrImageTooLarge.Error()})
+		return
+	}
+
+	contentType := parseUploadMetadata(ctx.GetHeader("Upload-Metadata"))["filetype"]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	} else if !utils.IsImageContentType(contentType) {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"message": utils.ErrUnsupportedImage.Error()})
+		return
+	}
+
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return
+	}
+
+	uploadID := uuid.New().String()
+	err = s.Cache.SetPendingUpload(ctx.Request.Context(), uploadID, redis.PendingUpload{
+		UserID:      userID.Hex(),
+		RawKey:      "chatify-3/raw/" + uploadID,
+		ContentType: contentType,
+		Size:        size,
+		Resumable:   true,
+		Status:      redis.UploadPending,
+	}, s.Config.Media.ResumableTTL)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't save pending upload", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't create upload, please try again later."})
+		return
+	}
+
+	ctx.Header("Location", "/image/uploads/"+uploadID)
+	ctx.Header("Upload-Expires", time.Now().Add(s.Config.Media.ResumableTTL).UTC().Format(http.TimeFormat))
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Upload created.", "uploadId": uploadID})
+}
+
+func (s *Server) getResumableUpload(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+	ctx.Header("Cache-Control", "no-store")
+
+	upload, _, ok := s.findResumableUpload(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	ctx.Header("Upload-Length", strconv.FormatInt(upload.Size, 10))
+	ctx.Status(http.StatusOK)
+}
+
+func (s *Server) patchResumableUpload(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+
+	upload, userID, ok := s.findResumableUpload(ctx)
+	if !ok {
+		return
+	}
+	uploadID := ctx.Param("uploadID")
+
+	if ctx.ContentType() != "application/offset+octet-stream" {
+		ctx.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "Content-Type must be application/offset+octet-stream."})
+		return
+	}
+	offset, err := strconv.ParseInt(ctx.GetHeader("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"Upload-Offset\" header is required."})
+		return
+	}
+	if upload.Status != redis.UploadPending {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "Upload is already complete."})
+		return
+	}
+	if offset != upload.Offset {
+		ctx.Header("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+		ctx.JSON(http.StatusConflict, gin.H{"message": "Upload-Offset doesn't match the upload's offset."})
+		return
+	}
+
+	limit := min(upload.Size-offset, maxChunkSize)
+	chunk, err := io.ReadAll(io.LimitReader(ctx.Request.Body, limit+1))
+	if err != nil {
+		// the client went away mid chunk, it will ask for the offset and send it again.
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Couldn't read chunk."})
+		return
+	}
+	if int64(len(chunk)) > limit {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": fmt.Sprintf("Chunks can be %d bytes at most and can't exceed Upload-Length.", limit)})
+		return
+	}
+	if len(chunk) == 0 {
+		ctx.Header("Upload-Offset", strconv.FormatInt(offset, 10))
+		ctx.Status(http.StatusNoContent)
+		return
+	}
+
+	chunkKey := fmt.Sprintf("chatify-3/chunks/%s/%d-%s", uploadID, offset, uuid.New().String())
+	err = s.Media.Store.Put(ctx.Request.Context(), chunkKey, bytes.NewReader(chunk), int64(len(chunk)), "application/octet-stream")
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't store chunk", "upload_id", uploadID, "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't store chunk, please try again."})
+		return
+	}
+
+	newOffset := offset + int64(len(chunk))
+	err = s.Cache.AppendUploadChunk(ctx.Request.Context(), uploadID, offset, newOffset, chunkKey, newOffset == upload.Size)
+	if err != nil {
+		s.Media.DeleteLater(chunkKey)
+		if err == redis.ErrOffsetMismatch {
+			ctx.JSON(http.StatusConflict, gin.H{"message": "Upload-Offset doesn't match the upload's offset."})
+			return
+		}
+		logging.From(ctx.Request.Context()).Error("Couldn't register chunk", "upload_id", uploadID, "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't store chunk, please try again."})
+		return
+	}
+
+	if newOffset < upload.Size {
+		if err = s.Cache.TouchUpload(ctx.Request.Context(), uploadID, s.Config.Media.ResumableTTL); err != nil {
+			logging.From(ctx.Request.Context()).Error("Couldn't extend upload", "upload_id", uploadID, "error", err)
+		}
+		ctx.Header("Upload-Expires", time.Now().Add(s.Config.Media.ResumableTTL).UTC().Format(http.TimeFormat))
+	} else {
+		bgCtx := context.WithoutCancel(ctx.Request.Context())
+		s.Background.Go(func() { s.finishResumableUpload(bgCtx, uploadID, upload, userID) })
+	}
+
+	ctx.Header("Upload-Offset", strconv.FormatInt(newOffset, 10))
+	ctx.Status(http.StatusNoContent)
+}
+
+func (s *Server) deleteResumableUpload(ctx *gin.Context) {
+	ctx.Header("Tus-Resumable", tusVersion)
+
+	upload, _, ok := s.findResumableUpload(ctx)
+	if !ok {
+		return
+	}
+	if upload.Status != redis.UploadPending {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "Upload is already complete."})
+		return
+	}
+
+	chunks, err := s.Cache.DeleteUpload(ctx.Request.Context(), ctx.Param("uploadID"))
+	if err == redis.ErrUploadClaimed {
+		ctx.JSON(http.StatusConflict, gin.H{"message": "Upload is already complete."})
+		return
+	} else if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't delete upload", "upload_id", ctx.Param("uploadID"), "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't delete upload, please try again later."})
+		return
+	}
+	s.Media.DeleteLater(chunks...)
+
+	ctx.Status(http.StatusNoContent)
+}
+
+// Returns the resumable upload in the uploadID param if it belongs to the user, otherwise responds with 404.
+func (s *Server) findResumableUpload(ctx *gin.Context) (redis.PendingUpload, bson.ObjectID, bool) {
+	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
+		return redis.PendingUpload{}, bson.NilObjectID, false
+	}
+
+	upload, err := s.Cache.GetPendingUpload(ctx.Request.Context(), ctx.Param("uploadID"))
+	if err != nil || upload.UserID != userID.Hex() || !upload.Resumable {
+		ctx.JSON(http.StatusNotFound, gin.H{"message": "Upload not found."})
+		return redis.PendingUpload{}, bson.NilObjectID, false
+	}
+
+	return upload, userID, true
+}
+
+// Joins the chunks into the raw object, deletes them and processes the upload.
+func (s *Server) finishResumableUpload(ctx context.Context, uploadID string, upload redis.PendingUpload, userID bson.ObjectID) {
+	logger := logging.From(ctx).With("upload_id", uploadID)
+
+	chunks, err := s.Cache.GetUploadChunks(ctx, uploadID)
+	if err == nil {
+		concatCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		err = storage.Concat(concatCtx, s.Media.Store, upload.RawKey, chunks, upload.Size, upload.ContentType)
+		cancel()
+	}
+	if err != nil {
+		logger.Error("Couldn't assemble upload", "error", err)
+		if err = s.Cache.SetUploadStatus(ctx, uploadID, redis.UploadFailed, ""); err != nil {
+			logger.Error("Couldn't update upload status", "error", err)
+		}
+		s.notifyUpload(ctx, userID, uploadID, redis.UploadFailed, "", nil)
+		return
+	}
+
+	s.Media.DeleteLater(chunks...)
+
+	s.processUpload(ctx, uploadID, upload, userID)
+}
+
+// Parses the tus Upload-Metadata header: comma separated "key base64(value)" pairs.
+func parseUploadMetadata(header string) map[string]string {
+	metadata := make(map[string]string)
+	for pair := range strings.SplitSeq(header, ",") {
+		key, encoded, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			continue
+		}
+		metadata[key] = string(value)
+	}
+
+	return metadata
+}
@@ -1,42 +1,122 @@
 package api
 
 import (
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/middlewares"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/tracing"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
 )
 
-func Register(server *gin.Engine) {
-	authRoutes := server.Group("/", middlewares.IsAuth)
+// Router builds the server's engine with its middlewares and every route.
+func (s *Server) Router() *gin.Engine {
+	cfg := s.Config
+	server := gin.New()
+	server.Use(tracing.Middleware(cfg.Tracing.ServiceName), logging.Middleware, logging.Recovery, metrics.Middleware)
+	server.Use(cors.New(cors.Config{
+		AllowOrigins:     cfg.Server.CORSOrigins,
+		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "PATCH", "HEAD"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "Accept", "Origin", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Metadata", logging.RequestIDHeader, "traceparent", "tracestate"},
+		ExposeHeaders:    []string{"Location", "Tus-Resumable", "Upload-Length", "Upload-Offset", "Upload-Expires", logging.RequestIDHeader},
+		AllowCredentials: true,
+	}))
+
+	// media has its own, larger, bucket so loading a conversation's images doesn't use up the api's.
+	limiter := utils.NewClientLimiter(rate.Every(cfg.Server.RateInterval), cfg.Server.RateBurst)
+	mediaLimiter := utils.NewClientLimiter(rate.Every(cfg.Server.MediaRateInterval), cfg.Server.MediaRateBurst)
+	routes := server.Group("/", middlewares.RateLimitMiddleware(limiter))
+	media := server.Group("/", middlewares.RateLimitMiddleware(mediaLimiter))
+
+	isAuth := middlewares.IsAuth(s.Keys, s.Users)
+	authRoutes := routes.Group("/", isAuth)
+
+	routes.GET("/.well-known/jwks.json", s.getJWKS)
+
+	{
+		routes.POST("/register", s.register)
+		routes.POST("/login", s.login)
+		routes.GET("/users", s.searchUsers)
+		authRoutes.PUT("/user/name", s.changeUserName)
+		authRoutes.PUT("/user/password", s.changePassword)
+		authRoutes.PUT("/user/avatar", s.changeAvatar)
+	}
 
 	{
-		server.POST("/register", register)
-		server.POST("/login", login)
-		server.GET("/users", searchUsers)
-		authRoutes.PUT("/user/name", changeUserName)
-		authRoutes.PUT("/user/password", changePassword)
-		authRoutes.PUT("/user/avatar", changeAvatar)
+		routes.POST("/login/passkey/begin", s.beginPasskeyLogin)
+		routes.POST("/login/passkey/finish", s.finishPasskeyLogin)
+		routes.GET("/oauth/:provider", s.startOAuth)
+		routes.GET("/oauth/:provider/callback", s.oauthCallback)
+		routes.POST("/oauth/exchange", s.exchangeLoginCode)
+		authRoutes.POST("/passkey/register/begin", s.beginPasskeyRegistration)
+		authRoutes.POST("/passkey/register/finish", s.finishPasskeyRegistration)
+		authRoutes.GET("/passkeys", s.getPasskeys)
+		authRoutes.PUT("/passkey/:passkeyID", s.renamePasskey)
+		authRoutes.DELETE("/passkey/:passkeyID", s.deletePasskey)
 	}
 
 	{
-		authRoutes.GET("/conversations", getConversations)
-		authRoutes.POST("/conversation", createConversation)
+		authRoutes.GET("/conversations", s.getConversations)
+		authRoutes.POST("/conversation", s.createConversation)
 	}
 
 	{
-		authRoutes.GET("/messages/:conversationID", getMessages)
-		authRoutes.DELETE("/message/:messageID", deleteMessage)
+		authRoutes.GET("/messages/:conversationID", s.getMessages)
+		authRoutes.DELETE("/message/:messageID", s.deleteMessage)
 	}
 
 	{
-		authRoutes.GET("/ws", connectWS)
+		authRoutes.GET("/ws", s.connectWS)
 	}
 
 	{
-		authRoutes.POST("/image", uplaodHandler)
-		authRoutes.DELETE("/image", deleteHandler)
+		authRoutes.POST("/image", s.uplaodHandler)
+		authRoutes.DELETE("/image", s.deleteHandler)
+		authRoutes.POST("/image/presign", s.presignUpload)
+		authRoutes.POST("/image/confirm", s.confirmUpload)
+		authRoutes.GET("/image/upload/:uploadID", s.getUploadStatus)
+		authRoutes.POST("/image/uploads", s.createResumableUpload)
+		authRoutes.HEAD("/image/uploads/:uploadID", s.getResumableUpload)
+		authRoutes.PATCH("/image/uploads/:uploadID", s.patchResumableUpload)
+		authRoutes.DELETE("/image/uploads/:uploadID", s.deleteResumableUpload)
+	}
+
+	{
+		authRoutes.POST("/audio", s.uploadAudioHandler)
+		authRoutes.DELETE("/audio", s.deleteAudioHandler)
+	}
+
+	{
+		mediaRoutes := media.Group("/", middlewares.AllowQueryToken, isAuth)
+		mediaRoutes.GET("/media/*key", s.serveMedia)
+		authRoutes.POST("/media/sign", s.signMediaURLs)
+		media.GET("/signed/*key", s.serveSignedMedia)
+
+		// the path files were served from before /media, only for drivers that aren't publicly reachable.
+		if storage.ServedByServer(s.Media.Store) {
+			mediaRoutes.GET("/files/*key", s.serveMedia)
+		}
+	}
+
+	{
+		routes.GET("/healthz", healthz)
+		routes.GET("/readyz", s.readyz)
+		adminRoutes := authRoutes.Group("/admin", middlewares.IsAdmin(cfg.Auth.Admins))
+		adminRoutes.GET("/diagnostics", s.getDiagnostics)
+	}
+
+	// served on its own address when METRICS_ADDR is set, see main. otherwise only admins can scrape it,
+	// with a token like any other request.
+	if cfg.Server.MetricsAddr == "" {
+		authRoutes.GET("/metrics", middlewares.IsAdmin(cfg.Auth.Admins), metrics.Handler())
 	}
 
 	// authRoutes.GET("/ping", func(ctx *gin.Context) {
 	// 	ctx.JSON(http.StatusOK, gin.H{"message": "pong!", "Id": ctx.GetString("userID")})
 	// })
+
+	return server
 }
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/ws"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Server holds everything the handlers depend on, it's built once in main and its routes are served by Router.
+type Server struct {
+	Config *config.Config
+
+	Users         models.Users
+	Conversations models.Conversations
+	Messages      models.Messages
+	Passkeys      models.Passkeys
+
+	Media *utils.Media
+	Cache *redis.Cache
+	Hub   *ws.Hub
+
+	// signs and verifies tokens, see utils.LoadKeyring.
+	Keys      *utils.Keyring
+	Passwords *utils.PasswordHasher
+	WebAuthn  *webauthn.WebAuthn
+	// keyed by provider name, see utils.NewOIDCProviders.
+	OIDCProviders map[string]*utils.OIDCProvider
+	// what handlers leave to do after responding, shutdown waits for it.
+	Background *utils.Background
+
+	// checked by /readyz and the admin diagnostics, keyed by the name they're reported under.
+	Dependencies map[string]func(context.Context) error
+}
@@ -1,53 +1,51 @@
 package api
 
 import (
-	"fmt"
 	"net/http"
 
-	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
-	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
-func uplaodHandler(ctx *gin.Context) {
+func (s *Server) uplaodHandler(ctx *gin.Context) {
 	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
 		return
 	}
 
-	path, code, err := utils.ExtractFileAndUpload(ctx.Request, "image")
+	path, meta, code, err := s.Media.ExtractFileAndUpload(ctx.Writer, ctx.Request, "image")
 	if err != nil {
 		ctx.JSON(code, gin.H{"message": err.Error()})
 		return
 	}
 
-	err = redis.SetTempImage(path, userID)
+	err = s.Cache.SetTempImage(ctx.Request.Context(), path, &meta, userID)
 	if err != nil {
-		fmt.Println(err)
-		go utils.DeleteFile(path)
+		logging.From(ctx.Request.Context()).Error("Couldn't save temp image", "error", err)
+		s.Media.DeleteLater(path)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't upload image, please try again later."})
 		return
 	}
 
-	ctx.JSON(http.StatusCreated, gin.H{"message": "Image uploaded successfully.", "path": path})
+	ctx.JSON(http.StatusCreated, gin.H{"message": "Image uploaded successfully.", "path": path, "imageMeta": meta})
 }
 
-func deleteHandler(ctx *gin.Context) {
+func (s *Server) deleteHandler(ctx *gin.Context) {
 	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
 		return
 	}
 
-	path, err := redis.GetTempImage(userID)
+	path, err := s.Cache.GetTempImage(ctx.Request.Context(), userID)
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{"message": "Image not found."})
 		return
 	}
 
-	go utils.DeleteFile(path)
+	s.Media.DeleteLater(path)
 
 	ctx.SecureJSON(http.StatusOK, gin.H{"message": "Deleting image."})
 }
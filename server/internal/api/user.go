@@ -1,28 +1,39 @@
 package api
 
 import (
-	"log"
+	"context"
 	"net/http"
 	"strings"
 
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/bson"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func register(ctx *gin.Context) {
+func (s *Server) register(ctx *gin.Context) {
+	// binding parses the whole form, avatar included.
+	s.Media.LimitBody(ctx.Writer, ctx.Request)
 	var user models.User
 	err := ctx.ShouldBind(&user)
-	if err != nil {
+	if utils.IsBodyTooLarge(err) {
+		ctx.JSON(http.StatusRequestEntityTooLarge, gin.H{"message": utils.ErrImageTooLarge.Error()})
+		return
+	} else if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
+	user.Email = models.NormalizeEmail(user.Email)
 
-	exists, err := models.UserExists(bson.M{"email": user.Email})
+	if err = utils.ValidatePasswordPolicy(user.Password); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
+		return
+	}
+
+	exists, err := s.Users.EmailExists(ctx.Request.Context(), user.Email)
 	if err != nil {
-		log.Println(err)
+		logging.From(ctx.Request.Context()).Error("Couldn't check if user exists", "error", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error, please try again later."})
 		return
 	}
@@ -31,16 +42,24 @@ func register(ctx *gin.Context) {
 		return
 	}
 
-	filePath, code, err := utils.ExtractFileAndUpload(ctx.Request, "avatar")
+	user.Password, err = s.Passwords.Hash(user.Password)
+	if err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't hash password", "error", err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't create user."})
+		return
+	}
+
+	filePath, meta, code, err := s.Media.ExtractFileAndUpload(ctx.Writer, ctx.Request, "avatar")
 	if err != nil {
 		ctx.JSON(code, gin.H{"message": err.Error()})
 		return
 	}
 
 	user.Avatar = filePath
-	err = user.Save()
+	user.AvatarMeta = &meta
+	err = s.Users.Save(ctx.Request.Context(), &user)
 	if err != nil {
-		log.Println(err.Error())
+		logging.From(ctx.Request.Context()).Error("Couldn't create user", "error", err)
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't create user."})
 		return
 	}
@@ -48,42 +67,58 @@ func register(ctx *gin.Context) {
 	ctx.JSON(http.StatusCreated, gin.H{"message": "User created Successfully."})
 }
 
-func login(ctx *gin.Context) {
+func (s *Server) login(ctx *gin.Context) {
 	var body models.LoginBody
 	err := ctx.ShouldBindJSON(&body)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
+	body.Email = models.NormalizeEmail(body.Email)
+
+	if !s.checkLoginLockout(ctx, body.Email) {
+		return
+	}
 
-	user, err := models.FindUser(bson.M{"email": body.Email}, nil)
+	user, err := s.Users.FindByEmail(ctx.Request.Context(), body.Email)
 	if err != nil {
+		s.registerLoginFailure(ctx, body.Email, bson.NilObjectID)
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid email or password."})
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.Password))
+	needsRehash, err := s.Passwords.Verify(user.Password, body.Password)
 	if err != nil {
+		s.registerLoginFailure(ctx, body.Email, user.ID)
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "Invalid email or password."})
 		return
 	}
+	if needsRehash {
+		bgCtx := context.WithoutCancel(ctx.Request.Context())
+		s.Background.Go(func() { s.rehashPassword(bgCtx, user.ID, body.Password) })
+	}
+
+	if err = s.Cache.ResetLoginFailures(ctx.Request.Context(), body.Email); err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't reset login failures", "error", err)
+	}
 
 	ctx.JSON(http.StatusOK, gin.H{"message": "Logged in successfully.", "user": gin.H{
-		"id":     user.ID,
-		"email":  user.Email,
-		"name":   user.Name,
-		"avatar": user.Avatar,
+		"id":         user.ID,
+		"email":      user.Email,
+		"name":       user.Name,
+		"avatar":     user.Avatar,
+		"avatarMeta": user.AvatarMeta,
 	}})
 }
 
-func searchUsers(ctx *gin.Context) {
+func (s *Server) searchUsers(ctx *gin.Context) {
 	searchTerm := ctx.Query("search")
 	if searchTerm == "" {
 		ctx.JSON(http.StatusBadRequest, gin.H{"message": "\"search\" query parameter is required."})
 		return
 	}
 
-	users, err := models.SearchUsers(searchTerm)
+	users, err := s.Users.Search(ctx.Request.Context(), searchTerm)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "something went wrong, please try again later."})
 		return
@@ -91,7 +126,7 @@ func searchUsers(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"message": "Users fetched successfully.", "users": users})
 }
 
-func changeUserName(ctx *gin.Context) {
+func (s *Server) changeUserName(ctx *gin.Context) {
 	type reqBody struct {
 		Name string `json:"name" binding:"required,min=3,max=30"`
 	}
@@ -109,8 +144,7 @@ func changeUserName(ctx *gin.Context) {
 		return
 	}
 
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: body.Name}}}}
-	err = models.UpdateUser(userID, update)
+	err = s.Users.SetName(ctx.Request.Context(), userID, body.Name)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Couldn't update your username."})
 		return
@@ -119,15 +153,20 @@ func changeUserName(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"message": "Username updated successfully."})
 }
 
-func changePassword(ctx *gin.Context) {
+func (s *Server) changePassword(ctx *gin.Context) {
 	type reqBody struct {
 		CurrentPassword string `json:"currentPassword"`
-		NewPassword     string `json:"newPassword" binding:"required,min=6"`
+		NewPassword     string `json:"newPassword" binding:"required"`
 	}
 	var body reqBody
 	err := ctx.ShouldBindJSON(&body)
 	if err != nil {
-		ctx.JSON(http.StatusBadGateway, gin.H{"message": "New password should be at least of length 6."})
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": "New password is required."})
+		return
+	}
+
+	if err = utils.ValidatePasswordPolicy(body.NewPassword); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"message": err.Error()})
 		return
 	}
 
@@ -137,26 +176,34 @@ func changePassword(ctx *gin.Context) {
 		return
 	}
 
-	user, err := models.FindUser(bson.M{"_id": userID}, nil)
+	user, err := s.Users.FindByID(ctx.Request.Context(), userID)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong, please try agaon later."})
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(body.CurrentPassword))
+	if !s.checkLoginLockout(ctx, user.Email) {
+		return
+	}
+
+	_, err = s.Passwords.Verify(user.Password, body.CurrentPassword)
 	if err != nil {
+		s.registerLoginFailure(ctx, user.Email, user.ID)
 		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Invalid current password."})
 		return
 	}
 
-	newHashedPW, err := utils.HashPassword(body.NewPassword)
+	if err = s.Cache.ResetLoginFailures(ctx.Request.Context(), user.Email); err != nil {
+		logging.From(ctx.Request.Context()).Error("Couldn't reset login failures", "error", err)
+	}
+
+	newHashedPW, err := s.Passwords.Hash(body.NewPassword)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong, please try agaon later."})
 		return
 	}
 
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: newHashedPW}}}}
-	err = models.UpdateUser(userID, update)
+	err = s.Users.SetPassword(ctx.Request.Context(), userID, newHashedPW)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong, please try agaon later."})
 		return
@@ -165,8 +212,8 @@ func changePassword(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"message": "Your password has been changed successfully."})
 }
 
-func changeAvatar(ctx *gin.Context) {
-	filePath, code, err := utils.ExtractFileAndUpload(ctx.Request, "file")
+func (s *Server) changeAvatar(ctx *gin.Context) {
+	filePath, meta, code, err := s.Media.ExtractFileAndUpload(ctx.Writer, ctx.Request, "file")
 	if err != nil {
 		ctx.JSON(code, gin.H{"message": err.Error()})
 		return
@@ -178,19 +225,32 @@ func changeAvatar(ctx *gin.Context) {
 		return
 	}
 
-	user, err := models.FindUser(bson.M{"_id": userID}, nil)
+	user, err := s.Users.FindByID(ctx.Request.Context(), userID)
 	if err != nil {
 		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "User doesn't exist."})
+		return
 	}
 
-	update := bson.D{{Key: "$set", Value: bson.D{{Key: "avatar", Value: filePath}}}}
-	err = models.UpdateUser(userID, update)
+	err = s.Users.SetAvatar(ctx.Request.Context(), userID, filePath, meta)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"message": "Something went wrong, please try agaon later."})
 		return
 	}
 
-	go utils.DeleteFile(user.Avatar)
+	s.Media.DeleteLater(user.Avatar)
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "Your avatar has been changed successfully.", "avatar": filePath, "avatarMeta": meta})
+}
+
+// Replaces a legacy (bcrypt or outdated argon2 parameters) hash with a fresh one after a successful login.
+func (s *Server) rehashPassword(ctx context.Context, userID bson.ObjectID, password string) {
+	hashedPW, err := s.Passwords.Hash(password)
+	if err != nil {
+		logging.From(ctx).Error("Couldn't rehash password", "error", err)
+		return
+	}
 
-	ctx.JSON(http.StatusOK, gin.H{"message": "Your avatar has been changed successfully.", "avatar": filePath})
+	if err = s.Users.SetPassword(ctx, userID, hashedPW); err != nil {
+		logging.From(ctx).Error("Couldn't save rehashed password", "error", err)
+	}
 }
@@ -1,94 +1,41 @@
 package api
 
 import (
-	"context"
-	"fmt"
 	"net/http"
 
-	snapws "github.com/Atheer-Ganayem/SnapWS"
-	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/ws"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
-var Manager *snapws.Manager[bson.ObjectID]
-
-func ManagerInit() {
-	u := snapws.NewUpgrader(&snapws.Options{MaxMessageSize: 2048,
-		ReaderMaxFragments: 5,
-	})
-
-	u.Limiter = snapws.NewRateLimiter(2, 3)
-	u.Limiter.OnRateLimitHit = func(conn *snapws.Conn) error {
-		conn.SendJSON(context.Background(), gin.H{"type": "err", "message": "Too fast."})
-		return nil
-	}
-
-	Manager = snapws.NewManager[bson.ObjectID](u)
-	Manager.OnRegister = func(conn *snapws.ManagedConn[bson.ObjectID]) {
-		ids, err := models.GetParticipantsIDs(conn.Key)
-		if err != nil {
-			// report error to client
-			conn.Close()
-			return
-		}
-		conn.MetaData.Store("participantsIDs", ws.NewParticipantIDs(ids))
-
-		for _, pID := range ids {
-			if pConn, ok := Manager.GetConn(pID); ok {
-				pConn.SendJSON(context.Background(), gin.H{"type": "status", "userId": conn.Key, "online": true})
-			}
-		}
-	}
-
-	Manager.OnUnregister = func(conn *snapws.ManagedConn[bson.ObjectID]) {
-		val, ok := conn.MetaData.Load("participantsIDs")
-		if !ok {
-			return
-		}
-
-		safeIDs, ok := val.(*ws.SafeIDs)
-		if !ok {
-			return
-		}
-
-		safeIDs.Mu.RLock()
-		defer safeIDs.Mu.RUnlock()
-
-		for _, pID := range safeIDs.IDs {
-			if pConn, ok := Manager.GetConn(pID); ok {
-				pConn.SendJSON(context.Background(), gin.H{"type": "status", "userId": conn.Key, "online": false})
-			}
-		}
+func (s *Server) connectWS(ctx *gin.Context) {
+	if s.Hub.Draining() {
+		ctx.JSON(http.StatusServiceUnavailable, gin.H{"message": ws.ErrDraining.Error()})
+		return
 	}
-}
 
-func connectWS(ctx *gin.Context) {
 	userID, err := bson.ObjectIDFromHex(ctx.GetString("userID"))
 	if err != nil {
-		fmt.Println("object id err")
 		ctx.JSON(http.StatusUnauthorized, gin.H{"message": "Not authenticated."})
 		return
 	}
 
-	conn, err := Manager.Connect(userID, ctx.Writer, ctx.Request)
-	if err != nil {
-		fmt.Println("connect error", err)
+	// every log of the connection carries its ID, along with the upgrade request's ID.
+	connCtx := logging.With(ctx.Request.Context(), "conn_id", logging.NewID())
+	logger := logging.From(connCtx)
+
+	conn, err := s.Hub.Connect(userID, ctx.Writer, ctx.Request)
+	if err == ws.ErrDraining {
+		logger.Info("Websocket connected while draining, told to reconnect")
+		return
+	} else if err != nil {
+		logger.Warn("Couldn't upgrade websocket connection", "error", err)
 		return
 	}
 	defer conn.Close()
 
-	ws.ReadPump(Manager, conn, userID)
-}
-
-func FilterOnlineUsers(ids []bson.ObjectID) []bson.ObjectID {
-	online := make([]bson.ObjectID, 0, len(ids))
-	for _, id := range ids {
-		if _, ok := Manager.GetConn(id); ok {
-			online = append(online, id)
-		}
-	}
-
-	return online
+	logger.Info("Websocket connected")
+	s.Hub.ReadPump(connCtx, conn, userID)
+	logger.Info("Websocket disconnected")
 }
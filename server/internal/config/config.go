@@ -0,0 +1,310 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Config is everything the server can be configured with. values are loaded by Load, in order of precedence:
+// flags, env variables, the config file and the defaults below.
+// the file key and flag name of a field is its section and key joined by a dot (e.g. "mongo.uri").
+type Config struct {
+	Server   Server   `key:"server"`
+	Mongo    Mongo    `key:"mongo"`
+	Redis    Redis    `key:"redis"`
+	Storage  Storage  `key:"storage"`
+	Auth     Auth     `key:"auth"`
+	WebAuthn WebAuthn `key:"webauthn"`
+	OIDC     OIDC     `key:"oidc"`
+	Media    Media    `key:"media"`
+	Log      Log      `key:"log"`
+	Tracing  Tracing  `key:"tracing"`
+}
+
+type Server struct {
+	// defaults to ":{PORT}" when PORT is set (fly), "localhost:8080" otherwise.
+	Addr        string `key:"addr" env:"ADDR" usage:"address the server listens on"`
+	FrontendURL string `key:"frontend_url" env:"FRONTEND_URL" usage:"url of the frontend, oauth logins redirect there"`
+	// defaults to the frontend url and http://localhost:3000.
+	CORSOrigins []string `key:"cors_origins" env:"CORS_ORIGINS" usage:"comma separated origins allowed by CORS"`
+
+	// http requests, per client ip.
+	RateInterval time.Duration `key:"rate_interval" env:"RATE_INTERVAL" usage:"a request is allowed every interval per client"`
+	RateBurst    int           `key:"rate_burst" env:"RATE_BURST" usage:"requests a client can make at once"`
+	// media requests get their own bucket, a conversation loads many avatars and images at once.
+	MediaRateInterval time.Duration `key:"media_rate_interval" env:"MEDIA_RATE_INTERVAL" usage:"a media request is allowed every interval per client"`
+	MediaRateBurst    int           `key:"media_rate_burst" env:"MEDIA_RATE_BURST" usage:"media requests a client can make at once"`
+	// websocket messages, per connection.
+	WSRate         int `key:"ws_rate" env:"WS_RATE" usage:"websocket messages allowed per second"`
+	WSBurst        int `key:"ws_burst" env:"WS_BURST" usage:"websocket messages a connection can send at once"`
+	MaxMessageSize int `key:"max_message_size" env:"WS_MAX_MESSAGE_SIZE" usage:"max websocket message size in bytes"`
+	PageSize       int `key:"page_size" env:"MESSAGES_PAGE_SIZE" usage:"messages returned per page"`
+	// how long a shutdown waits for requests, websocket messages and background work before giving up.
+	ShutdownTimeout time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" usage:"how long shutting down may take"`
+	// when set, /metrics is served on this address only (an internal port), otherwise on the main server to admins.
+	MetricsAddr string `key:"metrics_addr" env:"METRICS_ADDR" usage:"address the prometheus metrics are served on"`
+}
+
+type Mongo struct {
+	URI      string `key:"uri" env:"MONGODB_URI" usage:"mongodb connection string"`
+	Database string `key:"database" env:"MONGODB_DATABASE" usage:"mongodb database name"`
+}
+
+type Redis struct {
+	Addr     string `key:"addr" env:"REDIS_ADDR" usage:"redis address"`
+	Username string `key:"username" env:"REDIS_USERNAME" usage:"redis username"`
+	Password string `key:"password" env:"REDIS_PW" usage:"redis password"`
+	DB       int    `key:"db" env:"REDIS_DB" usage:"redis database"`
+	// how long an uploaded image or voice note is kept before it's sent.
+	TempTTL time.Duration `key:"temp_ttl" env:"TEMP_MEDIA_TTL" usage:"how long unsent uploads are kept"`
+}
+
+type Storage struct {
+	Driver string `key:"driver" env:"STORAGE_DRIVER" usage:"storage driver: s3, local or memory"`
+	Bucket string `key:"bucket" env:"AWS_BUCKET_NAME" usage:"s3 bucket, required by the s3 driver"`
+	Dir    string `key:"dir" env:"STORAGE_DIR" usage:"directory of the local driver"`
+}
+
+type Auth struct {
+	Secret string `key:"secret" env:"AUTH_SECRET" usage:"HS256 secret verifying tokens without a kid"`
+	// "kid:secret" pairs.
+	HMACKeys      []string `key:"hmac_keys" env:"JWT_HMAC_KEYS" usage:"extra HS256 keys as kid:secret,kid:secret"`
+	KeysDir       string   `key:"keys_dir" env:"JWT_KEYS_DIR" usage:"directory of <kid>.pem keys"`
+	SigningKID    string   `key:"signing_kid" env:"JWT_SIGNING_KID" usage:"key id signing new tokens"`
+	Argon2Memory  uint32   `key:"argon2_memory" env:"ARGON2_MEMORY" usage:"argon2id memory in KiB"`
+	Argon2Time    uint32   `key:"argon2_time" env:"ARGON2_TIME" usage:"argon2id iterations"`
+	Argon2Threads uint8    `key:"argon2_threads" env:"ARGON2_THREADS" usage:"argon2id parallelism"`
+	// every hash allocates Argon2Memory, so at most Argon2Concurrency * Argon2Memory is used by password hashing.
+	Argon2Concurrency int `key:"argon2_concurrency" env:"ARGON2_CONCURRENCY" usage:"max passwords hashed at once"`
+	// ids of the users allowed to use the admin routes.
+	Admins []string `key:"admins" env:"ADMIN_USER_IDS" usage:"comma separated ids of admin users"`
+}
+
+type WebAuthn struct {
+	RPID string `key:"rp_id" env:"WEBAUTHN_RP_ID" usage:"webauthn relying party id"`
+	// defaults to the frontend url and http://localhost:3000.
+	Origins []string `key:"rp_origins" env:"WEBAUTHN_RP_ORIGINS" usage:"comma separated webauthn origins"`
+}
+
+type OIDC struct {
+	Providers    []string `key:"providers" env:"OIDC_PROVIDERS" usage:"comma separated oidc provider names"`
+	RedirectBase string   `key:"redirect_base" env:"OIDC_REDIRECT_BASE" usage:"base url of the oauth callbacks"`
+	// per provider, read from the "oidc.<name>" section or OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and
+	// OIDC_<NAME>_CLIENT_SECRET.
+	Clients map[string]OIDCClient `key:"-"`
+}
+
+type OIDCClient struct {
+	Issuer       string `key:"issuer" env:"ISSUER"`
+	ClientID     string `key:"client_id" env:"CLIENT_ID"`
+	ClientSecret string `key:"client_secret" env:"CLIENT_SECRET"`
+}
+
+type Media struct {
+	// defaults to a key derived from AUTH_SECRET.
+	SigningKey     string        `key:"signing_key" env:"MEDIA_SIGNING_KEY" usage:"key signing media urls"`
+	URLTTL         time.Duration `key:"url_ttl" env:"MEDIA_URL_TTL" usage:"how long signed media urls stay valid"`
+	MaxUploadBytes int64         `key:"max_upload_bytes" env:"MAX_UPLOAD_BYTES" usage:"max upload size in bytes"`
+	// decoding allocates ~4 bytes per pixel, the dimensions are checked from the header before decoding.
+	MaxImagePixels int `key:"max_image_pixels" env:"MAX_IMAGE_PIXELS" usage:"max width*height of an uploaded image"`
+	MaxImageSide   int `key:"max_image_side" env:"MAX_IMAGE_SIDE" usage:"max width or height of an uploaded image"`
+	// every image decode (multipart, presigned and resumable uploads) shares these slots.
+	MaxDecodes         int           `key:"max_decodes" env:"MAX_IMAGE_DECODES" usage:"images decoded at once"`
+	MaxAnimationFrames int           `key:"max_animation_frames" env:"MAX_ANIMATION_FRAMES" usage:"max frames of an animated image"`
+	ImageQuality       float32       `key:"image_quality" env:"IMAGE_QUALITY" usage:"webp quality of stored images, 1-100"`
+	PresignTTL         time.Duration `key:"presign_ttl" env:"PRESIGN_TTL" usage:"how long presigned upload urls stay valid"`
+	ResumableTTL       time.Duration `key:"resumable_ttl" env:"RESUMABLE_UPLOAD_TTL" usage:"how long a resumable upload waits for its next chunk"`
+	// 0 disables the collector.
+	GCInterval time.Duration `key:"gc_interval" env:"MEDIA_GC_INTERVAL" usage:"how often orphaned media is collected, 0 disables it"`
+	GCGrace    time.Duration `key:"gc_grace" env:"MEDIA_GC_GRACE" usage:"orphaned media younger than this is never collected"`
+}
+
+type Log struct {
+	Level string `key:"level" env:"LOG_LEVEL" usage:"debug, info, warn or error"`
+	// json in production, text is easier to read locally.
+	Format string `key:"format" env:"LOG_FORMAT" usage:"text or json"`
+}
+
+type Tracing struct {
+	// none disables tracing, stdout prints the spans (for local debugging).
+	Exporter string `key:"exporter" env:"TRACING_EXPORTER" usage:"none, otlp or stdout"`
+	// defaults to the OTEL_EXPORTER_OTLP_ENDPOINT variable, then http://localhost:4318.
+	Endpoint    string  `key:"endpoint" env:"TRACING_ENDPOINT" usage:"url of the OTLP/HTTP collector"`
+	SampleRatio float64 `key:"sample_ratio" env:"TRACING_SAMPLE_RATIO" usage:"fraction of traces that are recorded, 0 to 1"`
+	ServiceName string  `key:"service_name" env:"OTEL_SERVICE_NAME" usage:"service name the traces are reported under"`
+}
+
+const (
+	DriverS3     = "s3"
+	DriverLocal  = "local"
+	DriverMemory = "memory"
+
+	LogText = "text"
+	LogJSON = "json"
+
+	TracingNone   = "none"
+	TracingOTLP   = "otlp"
+	TracingStdout = "stdout"
+
+	defaultFrontendURL = "http://localhost:3000"
+)
+
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Addr:              "localhost:8080",
+			FrontendURL:       defaultFrontendURL,
+			RateInterval:      time.Millisecond * 750,
+			RateBurst:         5,
+			MediaRateInterval: time.Millisecond * 20,
+			MediaRateBurst:    200,
+			WSRate:            2,
+			WSBurst:           3,
+			MaxMessageSize:    2048,
+			PageSize:          30,
+			// fly kills the machine kill_timeout (30s) after the signal.
+			ShutdownTimeout: time.Second * 25,
+		},
+		Mongo: Mongo{Database: "Chatify-3"},
+		Redis: Redis{Username: "default", TempTTL: time.Minute * 10},
+		Storage: Storage{
+			Driver: DriverS3,
+			Dir:    "./uploads",
+		},
+		Auth: Auth{
+			SigningKID:    "legacy",
+			Argon2Memory:  64 * 1024,
+			Argon2Time:    3,
+			Argon2Threads: 2,
+			// 128MiB, next to the images being decoded on a 512MB VM.
+			Argon2Concurrency: 2,
+		},
+		WebAuthn: WebAuthn{RPID: "localhost"},
+		OIDC:     OIDC{Clients: map[string]OIDCClient{}},
+		Media: Media{
+			URLTTL:         time.Minute * 15,
+			MaxUploadBytes: 10 << 20,
+			MaxImagePixels: 40_000_000,
+			MaxImageSide:   12_000,
+			// up to ~160MB each at the max pixels.
+			MaxDecodes:         2,
+			MaxAnimationFrames: 300,
+			ImageQuality:       75,
+			PresignTTL:         time.Minute * 15,
+			ResumableTTL:       time.Hour,
+			GCInterval:         time.Hour * 24,
+			GCGrace:            time.Hour * 24,
+		},
+		Log:     Log{Level: "info", Format: LogText},
+		Tracing: Tracing{Exporter: TracingNone, SampleRatio: 1, ServiceName: "chatify-server"},
+	}
+}
+
+// fills in the defaults that depend on other values.
+func (c *Config) derive() {
+	if len(c.Server.CORSOrigins) == 0 {
+		c.Server.CORSOrigins = compactOrigins(c.Server.FrontendURL, defaultFrontendURL)
+	}
+	if len(c.WebAuthn.Origins) == 0 {
+		c.WebAuthn.Origins = compactOrigins(c.Server.FrontendURL, defaultFrontendURL)
+	}
+	if c.Media.SigningKey == "" {
+		c.Media.SigningKey = c.Auth.Secret
+	}
+	c.OIDC.RedirectBase = strings.TrimSuffix(c.OIDC.RedirectBase, "/")
+}
+
+func compactOrigins(origins ...string) []string {
+	return slices.Compact(slices.DeleteFunc(origins, func(origin string) bool { return origin == "" }))
+}
+
+// Validate returns every invalid value, joined.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Server.Addr != "", "server.addr is required.")
+	check(c.Server.RateInterval > 0, "server.rate_interval must be positive.")
+	check(c.Server.RateBurst > 0, "server.rate_burst must be positive.")
+	check(c.Server.MediaRateInterval > 0, "server.media_rate_interval must be positive.")
+	check(c.Server.MediaRateBurst > 0, "server.media_rate_burst must be positive.")
+	check(c.Server.WSRate > 0, "server.ws_rate must be positive.")
+	check(c.Server.WSBurst > 0, "server.ws_burst must be positive.")
+	check(c.Server.MaxMessageSize > 0, "server.max_message_size must be positive.")
+	check(c.Server.PageSize > 0, "server.page_size must be positive.")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout must be positive.")
+
+	check(c.Mongo.URI != "", "mongo.uri (MONGODB_URI) is required.")
+	check(c.Mongo.Database != "", "mongo.database is required.")
+
+	check(c.Redis.Addr != "", "redis.addr (REDIS_ADDR) is required.")
+	check(c.Redis.TempTTL > 0, "redis.temp_ttl must be positive.")
+
+	switch c.Storage.Driver {
+	case DriverS3:
+		check(c.Storage.Bucket != "", "storage.bucket (AWS_BUCKET_NAME) is required by the s3 storage driver.")
+	case DriverLocal:
+		check(c.Storage.Dir != "", "storage.dir is required by the local storage driver.")
+	case DriverMemory:
+	default:
+		check(false, "Unknown storage driver %q.", c.Storage.Driver)
+	}
+
+	check(c.Auth.Secret != "", "auth.secret (AUTH_SECRET) is required.")
+	for _, pair := range c.Auth.HMACKeys {
+		kid, secret, ok := strings.Cut(pair, ":")
+		check(ok && kid != "" && secret != "", "auth.hmac_keys (JWT_HMAC_KEYS) must be a comma separated list of kid:secret.")
+	}
+	check(c.Auth.SigningKID != "", "auth.signing_kid is required.")
+	check(c.Auth.Argon2Memory > 0 && c.Auth.Argon2Time > 0 && c.Auth.Argon2Threads > 0,
+		"auth.argon2_memory, auth.argon2_time and auth.argon2_threads must be positive.")
+	check(c.Auth.Argon2Concurrency > 0, "auth.argon2_concurrency must be positive.")
+	for _, id := range c.Auth.Admins {
+		_, err := bson.ObjectIDFromHex(id)
+		check(err == nil, "auth.admins (ADMIN_USER_IDS) has an invalid user id %q.", id)
+	}
+
+	check(c.WebAuthn.RPID != "", "webauthn.rp_id is required.")
+
+	check(slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.Log.Level)),
+		"log.level must be debug, info, warn or error.")
+	check(c.Log.Format == LogText || c.Log.Format == LogJSON, "log.format must be text or json.")
+
+	check(slices.Contains([]string{TracingNone, TracingOTLP, TracingStdout}, c.Tracing.Exporter),
+		"tracing.exporter must be none, otlp or stdout.")
+	check(c.Tracing.SampleRatio >= 0 && c.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1.")
+	check(c.Tracing.ServiceName != "", "tracing.service_name is required.")
+
+	if len(c.OIDC.Providers) > 0 {
+		check(c.OIDC.RedirectBase != "", "oidc.redirect_base (OIDC_REDIRECT_BASE) is required when oidc.providers is set.")
+	}
+	for _, name := range c.OIDC.Providers {
+		client := c.OIDC.Clients[name]
+		check(client.Issuer != "" && client.ClientID != "",
+			"OIDC provider %q needs an issuer and a client id (OIDC_%s_ISSUER, OIDC_%s_CLIENT_ID).",
+			name, strings.ToUpper(name), strings.ToUpper(name))
+	}
+
+	check(c.Media.SigningKey != "", "media.signing_key (MEDIA_SIGNING_KEY) or auth.secret is required.")
+	check(c.Media.URLTTL > 0, "media.url_ttl must be positive.")
+	check(c.Media.MaxUploadBytes > 0, "media.max_upload_bytes must be positive.")
+	check(c.Media.MaxImagePixels > 0 && c.Media.MaxImageSide > 0, "media.max_image_pixels and media.max_image_side must be positive.")
+	check(c.Media.MaxDecodes > 0, "media.max_decodes must be positive.")
+	check(c.Media.MaxAnimationFrames > 0, "media.max_animation_frames must be positive.")
+	check(c.Media.ImageQuality > 0 && c.Media.ImageQuality <= 100, "media.image_quality must be between 1 and 100.")
+	check(c.Media.PresignTTL > 0, "media.presign_ttl must be positive.")
+	check(c.Media.ResumableTTL > 0, "media.resumable_ttl must be positive.")
+	check(c.Media.GCInterval >= 0, "media.gc_interval can't be negative.")
+	check(c.Media.GCGrace >= 0, "media.gc_grace can't be negative.")
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,228 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// a configurable field: its file key / flag name, env variable and value.
+type field struct {
+	key   string
+	env   string
+	usage string
+	value reflect.Value
+}
+
+// Load registers a flag for every field on fs (plus -config, the config file), parses args and builds the
+// config from the defaults, the config file (-config or CONFIG_FILE, yaml or toml by extension), env variables
+// and flags, in increasing precedence. the result is validated.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	fields := fieldsOf(reflect.ValueOf(cfg).Elem(), "")
+
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "yaml or toml config file")
+	flags := map[string]string{}
+	for _, f := range fields {
+		fs.Func(f.key, f.usage, func(value string) error {
+			flags[f.key] = value
+			return nil
+		})
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	file := map[string]string{}
+	if *configFile != "" {
+		var err error
+		if file, err = readFile(*configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	for _, f := range fields {
+		value, ok := flags[f.key]
+		if !ok {
+			value, ok = os.LookupEnv(f.env)
+			ok = ok && value != ""
+		}
+		if !ok {
+			value, ok = file[f.key]
+		}
+		delete(file, f.key)
+		if !ok {
+			continue
+		}
+
+		if err := set(f.value, value); err != nil {
+			errs = append(errs, fmt.Errorf("Invalid %s (%s) %q: %w", f.key, f.env, value, err))
+		}
+	}
+
+	// PORT is set by fly, ADDR takes precedence.
+	if _, ok := flags["server.addr"]; !ok && os.Getenv("ADDR") == "" && os.Getenv("PORT") != "" {
+		cfg.Server.Addr = ":" + os.Getenv("PORT")
+	}
+
+	for i, name := range cfg.OIDC.Providers {
+		name = strings.ToLower(name)
+		cfg.OIDC.Providers[i] = name
+		client := OIDCClient{}
+		for _, f := range fieldsOf(reflect.ValueOf(&client).Elem(), "oidc."+name) {
+			env := "OIDC_" + strings.ToUpper(name) + "_" + f.env
+			if value := os.Getenv(env); value != "" {
+				f.value.SetString(value)
+			} else if value, ok := file[f.key]; ok {
+				f.value.SetString(value)
+			}
+			delete(file, f.key)
+		}
+		cfg.OIDC.Clients[name] = client
+	}
+
+	for key := range file {
+		errs = append(errs, fmt.Errorf("Unknown key %q in %s.", key, *configFile))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	cfg.derive()
+
+	return cfg, cfg.Validate()
+}
+
+// Returns the fields of a config struct, sections are flattened.
+func fieldsOf(v reflect.Value, prefix string) []field {
+	var fields []field
+	for i := range v.NumField() {
+		structField := v.Type().Field(i)
+		key := structField.Tag.Get("key")
+		if key == "" || key == "-" {
+			continue
+		}
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if structField.Type.Kind() == reflect.Struct && structField.Type != reflect.TypeFor[time.Duration]() {
+			fields = append(fields, fieldsOf(v.Field(i), key)...)
+			continue
+		}
+		fields = append(fields, field{
+			key:   key,
+			env:   structField.Tag.Get("env"),
+			usage: structField.Tag.Get("usage"),
+			value: v.Field(i),
+		})
+	}
+
+	return fields
+}
+
+func set(v reflect.Value, value string) error {
+	value = strings.TrimSpace(value)
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int64:
+		if v.Type() == reflect.TypeFor[time.Duration]() {
+			d, err := time.ParseDuration(value)
+			if err != nil {
+				return err
+			}
+			v.SetInt(int64(d))
+			return nil
+		}
+		fallthrough
+	case reflect.Int:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint8, reflect.Uint32:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	case reflect.Slice:
+		var items []string
+		for item := range strings.SplitSeq(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
+
+// Reads a yaml or toml file into "section.key": value pairs. lists are joined with commas.
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("Unsupported config file %q, use .yaml, .yml or .toml.", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten(values, "", raw)
+
+	return values, nil
+}
+
+func flatten(values map[string]string, prefix string, raw map[string]any) {
+	for key, value := range raw {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		switch value := value.(type) {
+		case map[string]any:
+			flatten(values, key, value)
+		case []any:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			values[key] = strings.Join(items, ",")
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// unsets every variable Load reads so the environment of the machine running the tests doesn't leak in.
+func clearEnv(t *testing.T) {
+	for _, f := range fieldsOf(reflect.ValueOf(Default()).Elem(), "") {
+		t.Setenv(f.env, "")
+	}
+	for _, env := range []string{"PORT", "CONFIG_FILE"} {
+		t.Setenv(env, "")
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func load(args ...string) (*Config, error) {
+	fs := flag.NewFlagSet("chatify", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	return Load(fs, args)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+mongo:
+  uri: mongodb://file
+redis:
+  addr: file:6379
+storage:
+  driver: memory
+auth:
+  secret: file-secret
+server:
+  ws_rate: 4
+  ws_burst: 6
+  page_size: 10
+  max_message_size: 4096
+  cors_origins: [https://a.example, https://b.example]
+`,
+		"config.toml": `
+[mongo]
+uri = "mongodb://file"
+[redis]
+addr = "file:6379"
+[storage]
+driver = "memory"
+[auth]
+secret = "file-secret"
+[server]
+ws_rate = 4
+ws_burst = 6
+page_size = 10
+max_message_size = 4096
+cors_origins = ["https://a.example", "https://b.example"]
+`,
+	}
+
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv("CONFIG_FILE", writeFile(t, name, content))
+			t.Setenv("WS_BURST", "8")
+			t.Setenv("MESSAGES_PAGE_SIZE", "20")
+			t.Setenv("REDIS_ADDR", "env:6379")
+
+			cfg, err := load("-server.page_size", "40")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tests := []struct {
+				name      string
+				got, want any
+			}{
+				{"default only", cfg.Server.RateBurst, 5},
+				{"file over default", cfg.Server.WSRate, 4},
+				{"env over file", cfg.Server.WSBurst, 8},
+				{"flag over env", cfg.Server.PageSize, 40},
+				{"an empty env variable is unset", cfg.Server.MaxMessageSize, 4096},
+				{"file string", cfg.Mongo.URI, "mongodb://file"},
+				{"env string", cfg.Redis.Addr, "env:6379"},
+				{"file list", strings.Join(cfg.Server.CORSOrigins, ","), "https://a.example,https://b.example"},
+				{"derived from the file", cfg.Media.SigningKey, "file-secret"},
+			}
+			for _, test := range tests {
+				if test.got != test.want {
+					t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+				}
+			}
+		})
+	}
+}
+
+// the -config flag wins over CONFIG_FILE, and PORT is only used without ADDR.
+func TestLoadConfigFileAndPort(t *testing.T) {
+	required := "mongo:\n  uri: mongodb://file\nredis:\n  addr: file:6379\nstorage:\n  driver: memory\nauth:\n  secret: s\n"
+
+	clearEnv(t)
+	t.Setenv("CONFIG_FILE", writeFile(t, "ignored.yaml", "server:\n  ws_rate: 1\n"+required))
+	t.Setenv("PORT", "9000")
+	cfg, err := load("-config", writeFile(t, "config.yaml", "server:\n  ws_rate: 2\n"+required))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.WSRate != 2 || cfg.Server.Addr != ":9000" {
+		t.Fatalf("ws_rate = %d, addr = %q", cfg.Server.WSRate, cfg.Server.Addr)
+	}
+
+	t.Setenv("ADDR", "0.0.0.0:8080")
+	if cfg, err = load("-config", writeFile(t, "config.yaml", required)); err != nil || cfg.Server.Addr != "0.0.0.0:8080" {
+		t.Fatalf("addr = %q, %v", cfg.Server.Addr, err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	required := "mongo:\n  uri: mongodb://file\nredis:\n  addr: file:6379\nstorage:\n  driver: memory\nauth:\n  secret: s\n"
+
+	tests := []struct {
+		name string
+		file string
+		env  map[string]string
+		args []string
+		err  string
+	}{
+		{name: "unknown key", file: required + "server:\n  colour: blue\n", err: `Unknown key "server.colour"`},
+		{name: "unknown section", file: required + "cache:\n  addr: x\n", err: `Unknown key "cache.addr"`},
+		{name: "client of an unlisted oidc provider", file: required + "oidc:\n  github:\n    client_id: x\n", err: `Unknown key "oidc.github.client_id"`},
+		{name: "invalid file value", file: required + "server:\n  ws_rate: fast\n", err: "Invalid server.ws_rate (WS_RATE)"},
+		{name: "invalid env value", file: required, env: map[string]string{"RATE_INTERVAL": "soon"}, err: "Invalid server.rate_interval (RATE_INTERVAL)"},
+		{name: "invalid flag value", file: required, args: []string{"-server.rate_burst", "many"}, err: "Invalid server.rate_burst (RATE_BURST)"},
+		{name: "unknown flag", file: required, args: []string{"-server.colour", "blue"}, err: "flag provided but not defined"},
+		{name: "unsupported file type", err: "Unsupported config file"},
+		{name: "invalid after loading", file: required, env: map[string]string{"STORAGE_DRIVER": "ftp"}, err: `Unknown storage driver "ftp".`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clearEnv(t)
+			name := "config.yaml"
+			if test.file == "" {
+				name = "config.json"
+			}
+			t.Setenv("CONFIG_FILE", writeFile(t, name, test.file))
+			for env, value := range test.env {
+				t.Setenv(env, value)
+			}
+
+			if _, err := load(test.args...); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Load error = %v, want %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := func() *Config {
+		cfg := Default()
+		cfg.Mongo.URI = "mongodb://localhost"
+		cfg.Redis.Addr = "localhost:6379"
+		cfg.Storage.Bucket = "chatify"
+		cfg.Auth.Secret = "secret"
+		cfg.derive()
+		return cfg
+	}
+	if err := valid().Validate(); err != nil {
+		t.Fatalf("Validate of a valid config = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(cfg *Config)
+		err    string
+	}{
+		{"no mongo uri", func(cfg *Config) { cfg.Mongo.URI = "" }, "mongo.uri (MONGODB_URI) is required."},
+		{"no redis addr", func(cfg *Config) { cfg.Redis.Addr = "" }, "redis.addr (REDIS_ADDR) is required."},
+		{"s3 without a bucket", func(cfg *Config) { cfg.Storage.Bucket = "" }, "storage.bucket (AWS_BUCKET_NAME) is required"},
+		{"local without a dir", func(cfg *Config) { cfg.Storage.Driver, cfg.Storage.Dir = DriverLocal, "" }, "storage.dir is required"},
+		{"unknown storage driver", func(cfg *Config) { cfg.Storage.Driver = "ftp" }, `Unknown storage driver "ftp".`},
+		{"no auth secret", func(cfg *Config) { cfg.Auth.Secret = "" }, "auth.secret (AUTH_SECRET) is required."},
+		{"hmac key without a kid", func(cfg *Config) { cfg.Auth.HMACKeys = []string{"secret"} }, "auth.hmac_keys (JWT_HMAC_KEYS)"},
+		{"invalid admin id", func(cfg *Config) { cfg.Auth.Admins = []string{"root"} }, `invalid user id "root"`},
+		{"zero rate interval", func(cfg *Config) { cfg.Server.RateInterval = 0 }, "server.rate_interval must be positive."},
+		{"negative shutdown timeout", func(cfg *Config) { cfg.Server.ShutdownTimeout = -time.Second }, "server.shutdown_timeout must be positive."},
+		{"unknown log level", func(cfg *Config) { cfg.Log.Level = "verbose" }, "log.level must be"},
+		{"unknown log format", func(cfg *Config) { cfg.Log.Format = "xml" }, "log.format must be text or json."},
+		{"sample ratio above 1", func(cfg *Config) { cfg.Tracing.SampleRatio = 2 }, "tracing.sample_ratio must be between 0 and 1."},
+		{"oidc without a redirect base", func(cfg *Config) {
+			cfg.OIDC.Providers = []string{"google"}
+			cfg.OIDC.Clients["google"] = OIDCClient{Issuer: "https://accounts.google.com", ClientID: "id"}
+		}, "oidc.redirect_base (OIDC_REDIRECT_BASE) is required"},
+		{"oidc provider without a client", func(cfg *Config) {
+			cfg.OIDC.Providers = []string{"google"}
+			cfg.OIDC.RedirectBase = "https://chatify.dev"
+		}, `OIDC provider "google" needs an issuer and a client id`},
+		{"image quality above 100", func(cfg *Config) { cfg.Media.ImageQuality = 101 }, "media.image_quality must be between 1 and 100."},
+		{"no decodes", func(cfg *Config) { cfg.Media.MaxDecodes = 0 }, "media.max_decodes must be positive."},
+		{"negative gc grace", func(cfg *Config) { cfg.Media.GCGrace = -time.Hour }, "media.gc_grace can't be negative."},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := valid()
+			test.change(cfg)
+			if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("Validate error = %v, want %q", err, test.err)
+			}
+		})
+	}
+
+	// every invalid value is reported, not just the first.
+	cfg := valid()
+	cfg.Mongo.URI, cfg.Redis.Addr = "", ""
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "mongo.uri") || !strings.Contains(err.Error(), "redis.addr") {
+		t.Fatalf("Validate error = %v", err)
+	}
+}
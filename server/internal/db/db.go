@@ -2,44 +2,42 @@ package db
 
 import (
 	"context"
-	"log"
-	"os"
+	"log/slog"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
 
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
+	"go.mongodb.org/mongo-driver/v2/mongo/readpref"
 )
 
-var (
-	client        *mongo.Client
-	DB            *mongo.Database
-	Users         *mongo.Collection
-	Conversations *mongo.Collection
-	Messages      *mongo.Collection
-)
-
-func Init() {
-	uri := os.Getenv("MONGODB_URI")
-	if uri == "" {
-		panic("You must set your 'MONGODB_URI' environment variable.")
-	}
+// Mongo is a connection to the app's database, the repositories (see models.NewMongoUsers) take their
+// collections from it.
+type Mongo struct {
+	client   *mongo.Client
+	Database *mongo.Database
+}
 
-	opts := options.Client().ApplyURI(uri)
+func Connect(cfg config.Mongo) (*Mongo, error) {
+	opts := options.Client().ApplyURI(cfg.URI).SetMonitor(newTracingMonitor())
 	client, err := mongo.Connect(opts)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	DB = client.Database("Chatify-3")
-	Users = DB.Collection("users")
-	Conversations = DB.Collection("conversations")
-	Messages = DB.Collection("messages")
+	slog.Info("DB connected")
 
-	log.Println("DB connected!")
+	return &Mongo{client: client, Database: client.Database(cfg.Database)}, nil
 }
 
-func Disconnect() {
-	if err := client.Disconnect(context.TODO()); err != nil {
-		panic(err)
-	}
+func (m *Mongo) Collection(name string) *mongo.Collection {
+	return m.Database.Collection(name)
+}
+
+func (m *Mongo) Ping(ctx context.Context) error {
+	return m.client.Ping(ctx, readpref.Primary())
+}
 
+func (m *Mongo) Disconnect(ctx context.Context) error {
+	return m.client.Disconnect(ctx)
 }
@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"sync"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/tracing"
+	"go.mongodb.org/mongo-driver/v2/event"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Traces the commands run with a context that has a span (see tracing.StartChild). the driver reports the
+// start and the end of a command separately, open spans are kept by request ID in between.
+func newTracingMonitor() *event.CommandMonitor {
+	var spans sync.Map
+
+	end := func(requestID int64, err error) {
+		if span, ok := spans.LoadAndDelete(requestID); ok {
+			tracing.End(span.(trace.Span), err)
+		}
+	}
+
+	return &event.CommandMonitor{
+		Started: func(ctx context.Context, evt *event.CommandStartedEvent) {
+			attrs := []attribute.KeyValue{
+				attribute.String("db.system.name", "mongodb"),
+				attribute.String("db.namespace", evt.DatabaseName),
+				attribute.String("db.operation.name", evt.CommandName),
+			}
+			if collection, ok := evt.Command.Lookup(evt.CommandName).StringValueOK(); ok {
+				attrs = append(attrs, attribute.String("db.collection.name", collection))
+			}
+
+			_, span := tracing.StartChild(ctx, "mongo."+evt.CommandName, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+			if span.IsRecording() {
+				spans.Store(evt.RequestID, span)
+			}
+		},
+		Succeeded: func(_ context.Context, evt *event.CommandSucceededEvent) {
+			end(evt.RequestID, nil)
+		},
+		Failed: func(_ context.Context, evt *event.CommandFailedEvent) {
+			end(evt.RequestID, evt.Failure)
+		},
+	}
+}
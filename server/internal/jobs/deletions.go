@@ -0,0 +1,93 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+)
+
+const (
+	deletionPollInterval = time.Second * 2
+	deletionBatchSize    = 50
+	// how long a claimed deletion is hidden from other workers, every key's lease is renewed right before
+	// it's deleted (see redis.Cache.RenewDeletionLease).
+	deletionLease   = time.Minute
+	deletionTimeout = time.Second * 10
+)
+
+// Works through the storage deletion queue (see redis.Cache.EnqueueDeletion), deleting from store, until ctx
+// is done. safe to run on every server instance.
+func StartDeletionWorker(ctx context.Context, cache *redis.Cache, store storage.Storage) {
+	ticker := time.NewTicker(deletionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			recordDeletionStats(ctx, cache)
+			for ctx.Err() == nil {
+				keys, leasedUntil, err := cache.ClaimDeletions(ctx, deletionBatchSize, deletionLease)
+				if err != nil {
+					slog.Error("Couldn't claim deletions", "error", err)
+					break
+				}
+				for _, key := range keys {
+					// the rest of the batch is claimed by another worker once the lease is over.
+					if ctx.Err() != nil {
+						return
+					}
+					if ok, err := cache.RenewDeletionLease(ctx, key, leasedUntil, deletionLease); err != nil {
+						slog.Error("Couldn't renew deletion lease", "key", key, "error", err)
+						continue
+					} else if !ok {
+						continue
+					}
+					runDeletion(ctx, cache, store, key)
+				}
+				if len(keys) < deletionBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// a deletion that started is finished even if the worker is stopped meanwhile.
+func runDeletion(ctx context.Context, cache *redis.Cache, store storage.Storage, key string) {
+	ctx = context.WithoutCancel(ctx)
+	deleteCtx, cancel := context.WithTimeout(ctx, deletionTimeout)
+	err := store.Delete(deleteCtx, key)
+	cancel()
+
+	if err == nil {
+		if err = cache.CompleteDeletion(ctx, key); err != nil {
+			slog.Error("Couldn't complete deletion", "key", key, "error", err)
+		}
+		return
+	}
+
+	dead, failErr := cache.FailDeletion(ctx, key, err)
+	if failErr != nil {
+		slog.Error("Couldn't reschedule deletion", "key", key, "error", failErr)
+	} else if dead {
+		slog.Error("Giving up on deletion", "key", key, "error", err)
+	}
+}
+
+// the queue's depth and dead letters are shared by every instance, so they're read from redis.
+func recordDeletionStats(ctx context.Context, cache *redis.Cache) {
+	stats, err := cache.GetDeletionStats(ctx)
+	if err != nil {
+		slog.Error("Couldn't get deletion stats", "error", err)
+		return
+	}
+
+	metrics.DeletionsPending.Set(float64(stats.Pending))
+	metrics.DeletionsDead.Set(float64(stats.Dead))
+}
@@ -0,0 +1,138 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+)
+
+// every object the app stores lives under this prefix.
+const MediaPrefix = "chatify-3/"
+
+type MediaGCReport struct {
+	StartedAt  time.Time     `json:"startedAt"`
+	Duration   time.Duration `json:"duration"`
+	DryRun     bool          `json:"dryRun"`
+	Scanned    int           `json:"scanned"`
+	Referenced int           `json:"referenced"`
+	// unreferenced objects still inside the grace period.
+	TooRecent   int              `json:"tooRecent"`
+	Orphans     []storage.Object `json:"orphans"`
+	OrphanBytes int64            `json:"orphanBytes"`
+	Deleted     int              `json:"deleted"`
+	Failed      int              `json:"failed"`
+}
+
+// MediaGC collects the stored objects nothing refers to anymore.
+type MediaGC struct {
+	Store    storage.Storage
+	Cache    *redis.Cache
+	Messages models.Messages
+	Users    models.Users
+}
+
+// Lists every stored object and deletes the ones no message, user or pending upload refers to, if they're
+// older than grace. with dryRun nothing is deleted, the report lists what would be.
+//
+// pending uploads are read before the database: a temp image is only removed from redis after the message
+// referencing it was saved, so an object is always seen in at least one of them.
+func (gc *MediaGC) CollectOrphanedMedia(ctx context.Context, grace time.Duration, dryRun bool) (MediaGCReport, error) {
+	report := MediaGCReport{StartedAt: time.Now(), DryRun: dryRun, Orphans: []storage.Object{}}
+
+	objects, err := gc.Store.List(ctx, MediaPrefix)
+	if err != nil {
+		return report, err
+	}
+	report.Scanned = len(objects)
+
+	referenced := make(map[string]bool)
+	sources := []func(context.Context) ([]string, error){
+		gc.Cache.GetPendingMediaPaths,
+		gc.Messages.MediaPaths,
+		gc.Users.AvatarPaths,
+	}
+	for _, source := range sources {
+		paths, err := source(ctx)
+		if err != nil {
+			return report, err
+		}
+		for _, path := range paths {
+			for _, p := range utils.VariantPaths(path) {
+				referenced[p] = true
+			}
+		}
+	}
+
+	cutoff := report.StartedAt.Add(-grace)
+	for _, object := range objects {
+		switch {
+		case referenced[object.Key]:
+			report.Referenced++
+		case object.ModTime.After(cutoff):
+			report.TooRecent++
+		default:
+			report.Orphans = append(report.Orphans, object)
+			report.OrphanBytes += object.Size
+		}
+	}
+
+	if !dryRun {
+		for _, object := range report.Orphans {
+			if err := gc.Store.Delete(ctx, object.Key); err != nil {
+				slog.Error("Couldn't delete orphaned object", "key", object.Key, "error", err)
+				report.Failed++
+				continue
+			}
+			report.Deleted++
+		}
+	}
+	report.Duration = time.Since(report.StartedAt)
+
+	return report, nil
+}
+
+// Runs CollectOrphanedMedia every cfg.GCInterval (MEDIA_GC_INTERVAL, 0 disables it) with a grace period of
+// cfg.GCGrace (MEDIA_GC_GRACE), on one server instance at a time. objects younger than the grace period are
+// never collected: an upload is stored before it's registered in redis.
+func (gc *MediaGC) Start(ctx context.Context, cfg config.Media) {
+	interval, grace := cfg.GCInterval, cfg.GCGrace
+	if interval <= 0 {
+		slog.Info("Media GC disabled")
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			unlock, ok, err := gc.Cache.TryLock(ctx, "media-gc", interval)
+			if err != nil {
+				slog.Error("Couldn't lock media GC", "error", err)
+				continue
+			} else if !ok {
+				continue
+			}
+
+			runCtx, cancel := context.WithTimeout(ctx, interval)
+			report, err := gc.CollectOrphanedMedia(runCtx, grace, false)
+			cancel()
+			unlock()
+			if err != nil {
+				slog.Error("Media GC failed", "error", err)
+				continue
+			}
+			slog.Info("Media GC finished", "scanned", report.Scanned, "deleted", report.Deleted,
+				"orphan_bytes", report.OrphanBytes, "failed", report.Failed)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const Redacted = "[REDACTED]"
+
+// attributes whose value is never logged, whatever group they're in. message text is "text" in logs, the
+// ws payload logs its length only (see ws.WSPayload.LogValue).
+var redactedKeys = map[string]bool{
+	"text":          true,
+	"token":         true,
+	"access_token":  true,
+	"refresh_token": true,
+	"id_token":      true,
+	"password":      true,
+	"secret":        true,
+	"authorization": true,
+	"cookie":        true,
+	"code":          true,
+	"state":         true,
+	"sig":           true,
+}
+
+// Sets the default slog logger. the standard log package writes through it as well, so anything still using
+// log.Println ends up in the same output at info level.
+func Init(cfg config.Log) {
+	slog.SetDefault(slog.New(newHandler(os.Stderr, cfg)))
+}
+
+func newHandler(w io.Writer, cfg config.Log) slog.Handler {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		level = slog.LevelInfo
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	if cfg.Format == config.LogJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if redactedKeys[strings.ToLower(attr.Key)] {
+		attr.Value = slog.StringValue(Redacted)
+	}
+	return attr
+}
+
+type loggerKey struct{}
+
+// Returns a copy of ctx whose logger (see From) adds args to every record.
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, From(ctx).With(args...))
+}
+
+// Adds the trace ID of the span of ctx (see tracing.Start) to its logger, so logs and traces can be matched.
+func WithTrace(ctx context.Context) context.Context {
+	spanContext := trace.SpanContextFromContext(ctx)
+	if !spanContext.IsValid() {
+		return ctx
+	}
+	return With(ctx, "trace_id", spanContext.TraceID().String())
+}
+
+// Returns the logger of ctx, with the request or connection IDs added by With, or the default one.
+func From(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// Returns a random ID for correlating the logs of a request or a connection.
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
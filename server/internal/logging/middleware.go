@@ -0,0 +1,80 @@
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"regexp"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// IDs sent by a proxy or the client are kept if they look like one, so the request can be followed across
+// services.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Gives every request an ID (returned in the X-Request-ID header) and a logger carrying it and the trace ID,
+// see From, then logs the request once it's done.
+func Middleware(ctx *gin.Context) {
+	start := time.Now()
+
+	requestID := ctx.GetHeader(RequestIDHeader)
+	if !validRequestID.MatchString(requestID) {
+		requestID = NewID()
+	}
+	ctx.Header(RequestIDHeader, requestID)
+	ctx.Request = ctx.Request.WithContext(WithTrace(With(ctx.Request.Context(), "request_id", requestID)))
+
+	ctx.Next()
+
+	status := ctx.Writer.Status()
+	level := slog.LevelInfo
+	switch {
+	case status >= http.StatusInternalServerError:
+		level = slog.LevelError
+	case status >= http.StatusBadRequest:
+		level = slog.LevelWarn
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", ctx.Request.Method),
+		slog.String("path", ctx.Request.URL.Path),
+		slog.Int("status", status),
+		slog.Duration("latency", time.Since(start)),
+		slog.String("ip", ctx.ClientIP()),
+	}
+	if query := redactQuery(ctx.Request.URL.Query()); query != "" {
+		attrs = append(attrs, slog.String("query", query))
+	}
+	if userID := ctx.GetString("userID"); userID != "" {
+		attrs = append(attrs, slog.String("user_id", userID))
+	}
+	if len(ctx.Errors) > 0 {
+		attrs = append(attrs, slog.String("errors", ctx.Errors.String()))
+	}
+
+	From(ctx.Request.Context()).LogAttrs(ctx.Request.Context(), level, "Request", attrs...)
+}
+
+// Replaces the values of the query parameters that are credentials (the token of media and websocket urls,
+// url signatures, oauth codes).
+func redactQuery(query url.Values) string {
+	for key := range query {
+		if redactedKeys[strings.ToLower(key)] {
+			query[key] = []string{Redacted}
+		}
+	}
+	return query.Encode()
+}
+
+// Recovers from panics in handlers, the stack is logged with the request's ID.
+var Recovery = gin.CustomRecoveryWithWriter(io.Discard, func(ctx *gin.Context, err any) {
+	From(ctx.Request.Context()).Error("Panic while handling request", "panic", err, "stack", string(debug.Stack()))
+	ctx.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "Internal server error."})
+})
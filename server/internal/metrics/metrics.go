@@ -0,0 +1,125 @@
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatify_http_requests_total",
+		Help: "HTTP requests by route and status code.",
+	}, []string{"method", "route", "status"})
+	HTTPDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chatify_http_request_duration_seconds",
+		Help:    "HTTP request latency by route.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	WSMessagesIn = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chatify_ws_messages_received_total",
+		Help: "Messages read from websocket connections.",
+	})
+	WSMessagesOut = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatify_ws_messages_sent_total",
+		Help: "Events sent to websocket connections by type.",
+	}, []string{"type"})
+	WSRateLimitHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chatify_ws_rate_limit_hits_total",
+		Help: "Websocket messages rejected by the rate limiter.",
+	})
+	WSFatalReadErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chatify_ws_fatal_read_errors_total",
+		Help: "Websocket reads that ended the connection.",
+	})
+
+	MediaProcessing = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chatify_media_processing_seconds",
+		Help:    "Time spent decoding, encoding and storing uploads by kind (image, animation, audio).",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"kind"})
+	StorageOperations = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chatify_storage_operation_seconds",
+		Help:    "Storage latency by operation and result. Get is timed until the body is returned.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "result"})
+	MongoOperations = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "chatify_mongo_operation_seconds",
+		Help:    "Mongo latency by model function.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 5},
+	}, []string{"function"})
+	RedisSweeperLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chatify_redis_sweeper_lag_seconds",
+		Help:    "How long after their expiry pending uploads are cleaned up.",
+		Buckets: []float64{.5, 1, 2.5, 5, 10, 30, 60, 300, 900},
+	})
+
+	// the queue is shared by every instance, the gauges are read from redis by each worker.
+	DeletionsPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chatify_deletions_pending",
+		Help: "Storage deletions waiting in the queue, including the ones being retried.",
+	})
+	DeletionsDead = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "chatify_deletions_dead",
+		Help: "Storage deletions that were given up on, waiting to be requeued.",
+	})
+	DeletionsEnqueued = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chatify_deletions_enqueued_total",
+		Help: "Storage keys queued for deletion.",
+	})
+	Deletions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "chatify_deletions_total",
+		Help: "Storage deletions run by this instance by result (deleted, retried, failed).",
+	}, []string{"result"})
+)
+
+// the number of open websocket connections, set once the manager is created.
+var ConnectionCount = func() int { return 0 }
+
+var _ = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+	Name: "chatify_ws_connections",
+	Help: "Open websocket connections.",
+}, func() float64 { return float64(ConnectionCount()) })
+
+// Times an operation until the returned func is called:
+//
+//	defer metrics.Time(metrics.MongoOperations, "FindUser")()
+func Time(histogram *prometheus.HistogramVec, labels ...string) func() {
+	start := time.Now()
+	return func() {
+		histogram.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Returns "ok" or "error", for result labels.
+func Result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// Records every request by its route pattern (e.g. "/message/:messageID"), unmatched requests share one label
+// so scanners can't blow up the number of series.
+func Middleware(ctx *gin.Context) {
+	start := time.Now()
+	ctx.Next()
+
+	route := ctx.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	method := ctx.Request.Method
+
+	HTTPRequests.WithLabelValues(method, route, strconv.Itoa(ctx.Writer.Status())).Inc()
+	HTTPDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+}
+
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
@@ -0,0 +1,20 @@
+package middlewares
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Only lets the users in admins (ADMIN_USER_IDS) through, must come after IsAuth.
+func IsAdmin(admins []string) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if !slices.Contains(admins, ctx.GetString("userID")) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "Only admins can do that."})
+			return
+		}
+
+		ctx.Next()
+	}
+}
@@ -4,48 +4,60 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
-func IsAuth(ctx *gin.Context) {
-	var token string
+// Lets the following IsAuth read the token from the "token" query, for resources loaded by the browser
+// without an Authorization header (e.g. <img> tags).
+func AllowQueryToken(ctx *gin.Context) {
+	ctx.Set("allowQueryToken", true)
+	ctx.Next()
+}
 
-	if ctx.Request.Header.Get("Upgrade") == "websocket" {
-		token = ctx.Query("token")
-		if token == "" {
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid token query."})
-			return
+// Lets only authenticated users through: the token must be valid for keys and its user must still exist.
+func IsAuth(keys *utils.Keyring, users models.Users) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		var token string
+
+		if ctx.Request.Header.Get("Upgrade") == "websocket" || (ctx.GetBool("allowQueryToken") && ctx.GetHeader("Authorization") == "") {
+			token = ctx.Query("token")
+			if token == "" {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid token query."})
+				return
+			}
+		} else {
+			authHeader := strings.Split(ctx.GetHeader("Authorization"), " ")
+
+			if len(authHeader) != 2 || authHeader[0] != "Bearer" {
+				ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid auth header."})
+				return
+			}
+			token = authHeader[1]
 		}
-	} else {
-		authHeader := strings.Split(ctx.GetHeader("Authorization"), " ")
 
-		if len(authHeader) != 2 || authHeader[0] != "Bearer" {
-			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid auth header."})
+		userHexID, err := keys.VerifyToken(token)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication is required."})
 			return
 		}
-		token = authHeader[1]
-	}
 
-	userHexID, err := utils.VerifyToken(token)
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication is required."})
-		return
-	}
+		userObjectID, err := bson.ObjectIDFromHex(userHexID)
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication is required."})
+			return
+		}
+		exists, err := users.ExistsByID(ctx.Request.Context(), userObjectID)
+		if !exists || err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication is required."})
+			return
+		}
 
-	userObjectID, err := bson.ObjectIDFromHex(userHexID)
-	if err != nil {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication is required."})
-		return
+		ctx.Set("userID", userHexID)
+		ctx.Request = ctx.Request.WithContext(logging.With(ctx.Request.Context(), "user_id", userHexID))
+		ctx.Next()
 	}
-	exists, err := models.UserExists(bson.M{"_id": userObjectID})
-	if !exists || err != nil {
-		ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Authentication is required."})
-		return
-	}
-
-	ctx.Set("userID", userHexID)
-	ctx.Next()
 }
@@ -3,11 +3,12 @@ package models
 import (
 	"context"
 	"errors"
-	"log"
 	"net/http"
 	"time"
 
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/db"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
 	"go.mongodb.org/mongo-driver/v2/bson"
 	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
@@ -20,15 +21,29 @@ type Conversation struct {
 	CreatedAt    time.Time        `json:"createdAt" bson:"createdAt"`
 }
 
-func CreateConversation(users [2]bson.ObjectID) (bson.ObjectID, int, error) {
+// MongoConversations stores conversations in the "conversations" collection. it reads the "users" and
+// "messages" collections too, for populating conversations and finding their last message.
+type MongoConversations struct {
+	collection *mongo.Collection
+	messages   *mongo.Collection
+}
+
+func NewMongoConversations(m *db.Mongo) *MongoConversations {
+	return &MongoConversations{collection: m.Collection("conversations"), messages: m.Collection("messages")}
+}
+
+func (r *MongoConversations) Create(ctx context.Context, users [2]bson.ObjectID) (bson.ObjectID, int, error) {
+	ctx, done := observe(ctx, "Conversations.Create")
+	defer done()
+
 	if len(users) != 2 {
 		return bson.ObjectID{}, http.StatusBadRequest, errors.New("Users must be exactly 2.")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 	conversation := Conversation{ID: bson.NewObjectID(), Participants: users, CreatedAt: time.Now()}
-	_, err := db.Conversations.InsertOne(ctx, conversation)
+	_, err := r.collection.InsertOne(ctx, conversation)
 
 	if err != nil {
 		return bson.ObjectID{}, http.StatusInternalServerError, errors.New("Something went wrong, please try again later.")
@@ -37,18 +52,26 @@ func CreateConversation(users [2]bson.ObjectID) (bson.ObjectID, int, error) {
 	return conversation.ID, http.StatusCreated, nil
 }
 
-func FindConversation(filter bson.M) (Conversation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (r *MongoConversations) find(ctx context.Context, function string, filter bson.M) (Conversation, error) {
+	ctx, done := observe(ctx, function)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
 	var conversation Conversation
-	err := db.Conversations.FindOne(ctx, filter).Decode(&conversation)
+	err := r.collection.FindOne(ctx, filter).Decode(&conversation)
 
 	return conversation, err
 }
 
-func FindConversationByParticipants(users [2]bson.ObjectID) (Conversation, error) {
-	return FindConversation(bson.M{
+// Returns the conversation only if userID is one of its participants.
+func (r *MongoConversations) FindWithParticipant(ctx context.Context, conversationID, userID bson.ObjectID) (Conversation, error) {
+	return r.find(ctx, "Conversations.FindWithParticipant", bson.M{"_id": conversationID, "participants": userID})
+}
+
+func (r *MongoConversations) FindByParticipants(ctx context.Context, users [2]bson.ObjectID) (Conversation, error) {
+	return r.find(ctx, "Conversations.FindByParticipants", bson.M{
 		"participants": bson.M{
 			"$all": users,
 		},
@@ -62,16 +85,20 @@ type PopulatedConversation struct {
 }
 
 type UserPreview struct {
-	ID       bson.ObjectID `json:"_id" bson:"_id"`
-	Name     string        `json:"name"`
-	Email    string        `json:"email"`
-	Avatar   string        `json:"avatar"`
-	IsOnline bool          `json:"isOnline"`
+	ID         bson.ObjectID    `json:"_id" bson:"_id"`
+	Name       string           `json:"name"`
+	Email      string           `json:"email"`
+	Avatar     string           `json:"avatar"`
+	AvatarMeta *utils.ImageMeta `json:"avatarMeta,omitempty" bson:"avatarMeta,omitempty"`
+	IsOnline   bool             `json:"isOnline"`
 }
 
 // Takes a user id (MongoDB ObjectID), returns a slice of all the conversations that the user is associated with as a "PopulatedConversation"
-func GetMyPopulateConversations(userID bson.ObjectID) ([]PopulatedConversation, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+func (r *MongoConversations) GetPopulated(ctx context.Context, userID bson.ObjectID) ([]PopulatedConversation, error) {
+	ctx, done := observe(ctx, "Conversations.GetPopulated")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	pipeline := mongo.Pipeline{
@@ -119,21 +146,23 @@ func GetMyPopulateConversations(userID bson.ObjectID) ([]PopulatedConversation,
 		}}},
 		// Project only required fields
 		{{Key: "$project", Value: bson.M{
-			"_id":                1,
-			"participant._id":    1,
-			"participant.name":   1,
-			"participant.email":  1,
-			"participant.avatar": 1,
+			"_id":                    1,
+			"participant._id":        1,
+			"participant.name":       1,
+			"participant.email":      1,
+			"participant.avatar":     1,
+			"participant.avatarMeta": 1,
 			"lastMessage": bson.M{
 				"_id":       1,
 				"sender":    1,
 				"text":      1,
+				"audio":     1,
 				"createdAt": 1,
 			},
 		}}},
 	}
 
-	cursor, err := db.Conversations.Aggregate(ctx, pipeline)
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -147,8 +176,11 @@ func GetMyPopulateConversations(userID bson.ObjectID) ([]PopulatedConversation,
 }
 
 // Takes a message id (MongoDB ObjectID) and sets it as the last message of the conversation. if message id is a nil object id, it tryies to find the last message, if it's not found, it sets nil as the value.
-func (conversation *Conversation) UpdateLastMessage(messageID bson.ObjectID) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (r *MongoConversations) UpdateLastMessage(ctx context.Context, conversationID, messageID bson.ObjectID) {
+	ctx, done := observe(ctx, "Conversations.UpdateLastMessage")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
 	var val interface{} = messageID
@@ -156,7 +188,7 @@ func (conversation *Conversation) UpdateLastMessage(messageID bson.ObjectID) {
 	if messageID == bson.NilObjectID {
 		var lastMessage Message
 		opts := options.FindOne().SetSort(bson.D{{Key: "createdAt", Value: -1}})
-		err := db.Messages.FindOne(ctx, bson.M{"conversationId": conversation.ID}, opts).Decode(&lastMessage)
+		err := r.messages.FindOne(ctx, bson.M{"conversationId": conversationID}, opts).Decode(&lastMessage)
 		if err == nil {
 			val = lastMessage.ID
 		} else {
@@ -170,15 +202,18 @@ func (conversation *Conversation) UpdateLastMessage(messageID bson.ObjectID) {
 		}},
 	}
 
-	_, err := db.Conversations.UpdateByID(ctx, conversation.ID, update)
+	_, err := r.collection.UpdateByID(ctx, conversationID, update)
 	if err != nil {
-		log.Printf("error updating last message. conversation id: %s\n", conversation.ID)
+		logging.From(ctx).Error("Couldn't update last message", "conversation_id", conversationID.Hex(), "error", err)
 	}
 }
 
 // Takes a user id (MongoDB ObjectID), returns a slice of MongoDB ObjectIDs of those who have a conversation with the given user.
-func GetParticipantsIDs(userID bson.ObjectID) ([]bson.ObjectID, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (r *MongoConversations) GetParticipantsIDs(ctx context.Context, userID bson.ObjectID) ([]bson.ObjectID, error) {
+	ctx, done := observe(ctx, "Conversations.GetParticipantsIDs")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
 	pipeline := []bson.M{
@@ -215,7 +250,7 @@ func GetParticipantsIDs(userID bson.ObjectID) ([]bson.ObjectID, error) {
 		},
 	}
 
-	cursor, err := db.Conversations.Aggregate(ctx, pipeline)
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,536 @@
+package models
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+var errDuplicateKey = errors.New("duplicate key error")
+
+// memoryDB holds the documents of every in-memory repository, in insertion order like a collection's natural
+// order. documents are copied in and out, so callers can't change what's stored.
+type memoryDB struct {
+	mu            sync.RWMutex
+	users         []User
+	conversations []Conversation
+	messages      []Message
+	passkeys      []Passkey
+}
+
+// Memory is a set of repositories sharing the same in-memory data, for tests and running without mongo.
+type Memory struct {
+	Users         *MemoryUsers
+	Conversations *MemoryConversations
+	Messages      *MemoryMessages
+	Passkeys      *MemoryPasskeys
+}
+
+func NewMemory(pageSize int) *Memory {
+	db := &memoryDB{}
+	return &Memory{
+		Users:         &MemoryUsers{db: db},
+		Conversations: &MemoryConversations{db: db},
+		Messages:      &MemoryMessages{db: db, pageSize: int64(pageSize)},
+		Passkeys:      &MemoryPasskeys{db: db},
+	}
+}
+
+// mongo stores times with millisecond precision and decodes them as UTC.
+func storedTime(t time.Time) time.Time {
+	if t.IsZero() {
+		return time.Time{}
+	}
+	return t.Truncate(time.Millisecond).UTC()
+}
+
+func copyUser(user User) User {
+	user.Identities = slices.Clone(user.Identities)
+	if user.AvatarMeta != nil {
+		meta := *user.AvatarMeta
+		meta.Variants = maps.Clone(meta.Variants)
+		user.AvatarMeta = &meta
+	}
+	return user
+}
+
+func copyMessage(message Message) Message {
+	if message.ImageMeta != nil {
+		meta := *message.ImageMeta
+		meta.Variants = maps.Clone(meta.Variants)
+		message.ImageMeta = &meta
+	}
+	if message.AudioMeta != nil {
+		meta := *message.AudioMeta
+		meta.Waveform = slices.Clone(meta.Waveform)
+		message.AudioMeta = &meta
+	}
+	return message
+}
+
+// MemoryUsers is the in-memory counterpart of MongoUsers.
+type MemoryUsers struct {
+	db *memoryDB
+}
+
+func (r *MemoryUsers) Save(ctx context.Context, user *User) error {
+	user.ID = bson.NewObjectID()
+	user.CreatedAt = time.Now()
+
+	stored := copyUser(*user)
+	stored.CreatedAt = storedTime(stored.CreatedAt)
+	for i := range stored.Identities {
+		stored.Identities[i].LinkedAt = storedTime(stored.Identities[i].LinkedAt)
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.users = append(r.db.users, stored)
+
+	return nil
+}
+
+// Returns the index of the first user matching, or -1. the caller holds the lock.
+func (r *MemoryUsers) index(match func(User) bool) int {
+	return slices.IndexFunc(r.db.users, match)
+}
+
+func (r *MemoryUsers) exists(match func(User) bool) (bool, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	return r.index(match) != -1, nil
+}
+
+func (r *MemoryUsers) ExistsByID(ctx context.Context, userID bson.ObjectID) (bool, error) {
+	return r.exists(func(u User) bool { return u.ID == userID })
+}
+
+func (r *MemoryUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	return r.exists(func(u User) bool { return u.Email == email })
+}
+
+// users without an avatar have it stored as "", like in mongo.
+func (r *MemoryUsers) AvatarExists(ctx context.Context, paths []string) (bool, error) {
+	return r.exists(func(u User) bool { return slices.Contains(paths, u.Avatar) })
+}
+
+func (r *MemoryUsers) find(match func(User) bool) (User, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	i := r.index(match)
+	if i == -1 {
+		return User{}, mongo.ErrNoDocuments
+	}
+
+	return copyUser(r.db.users[i]), nil
+}
+
+func (r *MemoryUsers) FindByID(ctx context.Context, userID bson.ObjectID) (User, error) {
+	return r.find(func(u User) bool { return u.ID == userID })
+}
+
+func (r *MemoryUsers) FindByEmail(ctx context.Context, email string) (User, error) {
+	return r.find(func(u User) bool { return u.Email == email })
+}
+
+func (r *MemoryUsers) FindByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	return r.find(func(u User) bool {
+		return slices.ContainsFunc(u.Identities, func(i Identity) bool { return i.Provider == provider && i.Subject == subject })
+	})
+}
+
+func (r *MemoryUsers) FindProfile(ctx context.Context, userID bson.ObjectID) (User, error) {
+	user, err := r.FindByID(ctx, userID)
+	if err != nil {
+		return User{}, err
+	}
+
+	return User{ID: user.ID, Name: user.Name, Avatar: user.Avatar, AvatarMeta: user.AvatarMeta}, nil
+}
+
+// the term is a go regexp here instead of a PCRE one, they only differ for uncommon syntax.
+func (r *MemoryUsers) Search(ctx context.Context, term string) ([]User, error) {
+	regex, err := regexp.Compile("(?i)" + term)
+	if err != nil {
+		return nil, err
+	}
+
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var users []User
+	for _, user := range r.db.users {
+		if regex.MatchString(user.Name) || regex.MatchString(user.Email) {
+			user = copyUser(user)
+			users = append(users, User{ID: user.ID, Name: user.Name, Email: user.Email, Avatar: user.Avatar, AvatarMeta: user.AvatarMeta})
+		}
+	}
+
+	return users, nil
+}
+
+// like UpdateByID, updating a user that doesn't exist isn't an error.
+func (r *MemoryUsers) update(userID bson.ObjectID, update func(*User)) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if i := r.index(func(u User) bool { return u.ID == userID }); i != -1 {
+		update(&r.db.users[i])
+	}
+
+	return nil
+}
+
+func (r *MemoryUsers) SetName(ctx context.Context, userID bson.ObjectID, name string) error {
+	return r.update(userID, func(u *User) { u.Name = name })
+}
+
+func (r *MemoryUsers) SetPassword(ctx context.Context, userID bson.ObjectID, hashedPassword string) error {
+	return r.update(userID, func(u *User) { u.Password = hashedPassword })
+}
+
+func (r *MemoryUsers) SetAvatar(ctx context.Context, userID bson.ObjectID, path string, meta utils.ImageMeta) error {
+	meta.Variants = maps.Clone(meta.Variants)
+	return r.update(userID, func(u *User) {
+		u.Avatar = path
+		u.AvatarMeta = &meta
+	})
+}
+
+func (r *MemoryUsers) LinkIdentity(ctx context.Context, userID bson.ObjectID, identity Identity) error {
+	identity.LinkedAt = storedTime(identity.LinkedAt)
+	return r.update(userID, func(u *User) { u.Identities = append(u.Identities, identity) })
+}
+
+func (r *MemoryUsers) AvatarPaths(ctx context.Context) ([]string, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var paths []string
+	for _, user := range r.db.users {
+		if user.Avatar == "" {
+			continue
+		}
+		paths = append(paths, user.Avatar)
+		if user.AvatarMeta != nil {
+			paths = slices.AppendSeq(paths, maps.Values(user.AvatarMeta.Variants))
+		}
+	}
+
+	return paths, nil
+}
+
+// MemoryConversations is the in-memory counterpart of MongoConversations.
+type MemoryConversations struct {
+	db *memoryDB
+}
+
+func (r *MemoryConversations) Create(ctx context.Context, users [2]bson.ObjectID) (bson.ObjectID, int, error) {
+	conversation := Conversation{ID: bson.NewObjectID(), Participants: users, CreatedAt: storedTime(time.Now())}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.conversations = append(r.db.conversations, conversation)
+
+	return conversation.ID, http.StatusCreated, nil
+}
+
+func (r *MemoryConversations) find(match func(Conversation) bool) (Conversation, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	i := slices.IndexFunc(r.db.conversations, match)
+	if i == -1 {
+		return Conversation{}, mongo.ErrNoDocuments
+	}
+
+	return r.db.conversations[i], nil
+}
+
+func (r *MemoryConversations) FindWithParticipant(ctx context.Context, conversationID, userID bson.ObjectID) (Conversation, error) {
+	return r.find(func(c Conversation) bool {
+		return c.ID == conversationID && slices.Contains(c.Participants[:], userID)
+	})
+}
+
+func (r *MemoryConversations) FindByParticipants(ctx context.Context, users [2]bson.ObjectID) (Conversation, error) {
+	return r.find(func(c Conversation) bool {
+		return slices.Contains(c.Participants[:], users[0]) && slices.Contains(c.Participants[:], users[1])
+	})
+}
+
+func (r *MemoryConversations) GetPopulated(ctx context.Context, userID bson.ObjectID) ([]PopulatedConversation, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var results []PopulatedConversation
+	for _, conversation := range r.db.conversations {
+		if !slices.Contains(conversation.Participants[:], userID) {
+			continue
+		}
+
+		populated := PopulatedConversation{ID: conversation.ID}
+		if other := slices.IndexFunc(conversation.Participants[:], func(id bson.ObjectID) bool { return id != userID }); other != -1 {
+			otherID := conversation.Participants[other]
+			if i := slices.IndexFunc(r.db.users, func(u User) bool { return u.ID == otherID }); i != -1 {
+				user := copyUser(r.db.users[i])
+				populated.Participant = UserPreview{ID: user.ID, Name: user.Name, Email: user.Email, Avatar: user.Avatar, AvatarMeta: user.AvatarMeta}
+			}
+		}
+		if i := slices.IndexFunc(r.db.messages, func(m Message) bool { return m.ID == conversation.LastMessage }); i != -1 {
+			message := r.db.messages[i]
+			populated.LastMessage = &Message{ID: message.ID, Sender: message.Sender, Text: message.Text, Audio: message.Audio, CreatedAt: message.CreatedAt}
+		}
+
+		results = append(results, populated)
+	}
+
+	return results, nil
+}
+
+func (r *MemoryConversations) UpdateLastMessage(ctx context.Context, conversationID, messageID bson.ObjectID) {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if messageID == bson.NilObjectID {
+		var last *Message
+		for i, message := range r.db.messages {
+			if message.ConversationID == conversationID && (last == nil || message.CreatedAt.After(last.CreatedAt)) {
+				last = &r.db.messages[i]
+			}
+		}
+		if last != nil {
+			messageID = last.ID
+		}
+	}
+
+	if i := slices.IndexFunc(r.db.conversations, func(c Conversation) bool { return c.ID == conversationID }); i != -1 {
+		r.db.conversations[i].LastMessage = messageID
+	}
+}
+
+func (r *MemoryConversations) GetParticipantsIDs(ctx context.Context, userID bson.ObjectID) ([]bson.ObjectID, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	result := []bson.ObjectID{}
+	for _, conversation := range r.db.conversations {
+		if !slices.Contains(conversation.Participants[:], userID) {
+			continue
+		}
+		for _, id := range conversation.Participants {
+			if id != userID && !slices.Contains(result, id) {
+				result = append(result, id)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// MemoryMessages is the in-memory counterpart of MongoMessages.
+type MemoryMessages struct {
+	db       *memoryDB
+	pageSize int64
+}
+
+func (r *MemoryMessages) Save(ctx context.Context, message Message) error {
+	message = copyMessage(message)
+	message.CreatedAt = storedTime(message.CreatedAt)
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if slices.ContainsFunc(r.db.messages, func(m Message) bool { return m.ID == message.ID }) {
+		return errDuplicateKey
+	}
+	r.db.messages = append(r.db.messages, message)
+
+	return nil
+}
+
+func (r *MemoryMessages) GetMessages(ctx context.Context, conversationID bson.ObjectID, page int64) ([]Message, error) {
+	skip := (page - 1) * r.pageSize
+	if skip < 0 {
+		return nil, errors.New("skip must be non-negative")
+	}
+
+	// newest first, messages saved in the same millisecond too.
+	r.db.mu.RLock()
+	var messages []Message
+	for _, message := range slices.Backward(r.db.messages) {
+		if message.ConversationID == conversationID {
+			messages = append(messages, copyMessage(message))
+		}
+	}
+	r.db.mu.RUnlock()
+
+	slices.SortStableFunc(messages, func(a, b Message) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	if skip >= int64(len(messages)) {
+		return nil, nil
+	}
+	messages = messages[skip:]
+	if r.pageSize > 0 {
+		messages = messages[:min(r.pageSize, int64(len(messages)))]
+	}
+
+	return messages, nil
+}
+
+func (r *MemoryMessages) find(match func(Message) bool) (Message, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	i := slices.IndexFunc(r.db.messages, match)
+	if i == -1 {
+		return Message{}, mongo.ErrNoDocuments
+	}
+
+	return copyMessage(r.db.messages[i]), nil
+}
+
+func (r *MemoryMessages) FindBySender(ctx context.Context, messageID, senderID bson.ObjectID) (Message, error) {
+	return r.find(func(m Message) bool { return m.ID == messageID && m.Sender == senderID })
+}
+
+// messages without an image have it stored as "", while audio is left out, like in mongo.
+func (r *MemoryMessages) FindByMedia(ctx context.Context, paths []string) (Message, error) {
+	message, err := r.find(func(m Message) bool {
+		return slices.Contains(paths, m.Image) || (m.Audio != "" && slices.Contains(paths, m.Audio))
+	})
+	if err != nil {
+		return Message{}, err
+	}
+
+	return Message{ID: message.ID, Sender: message.Sender, ConversationID: message.ConversationID}, nil
+}
+
+func (r *MemoryMessages) MediaPaths(ctx context.Context) ([]string, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	var paths []string
+	for _, message := range r.db.messages {
+		if message.Image == "" && message.Audio == "" {
+			continue
+		}
+		paths = append(paths, message.Image, message.Audio)
+		if message.ImageMeta != nil {
+			paths = slices.AppendSeq(paths, maps.Values(message.ImageMeta.Variants))
+		}
+	}
+
+	return paths, nil
+}
+
+func (r *MemoryMessages) Delete(ctx context.Context, messageID bson.ObjectID) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	r.db.messages = slices.DeleteFunc(r.db.messages, func(m Message) bool { return m.ID == messageID })
+
+	return nil
+}
+
+// MemoryPasskeys is the in-memory counterpart of MongoPasskeys.
+type MemoryPasskeys struct {
+	db *memoryDB
+}
+
+func (r *MemoryPasskeys) GetPasskeyUser(ctx context.Context, user User) (*PasskeyUser, error) {
+	passkeys, err := r.GetPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasskeyUser{User: user, Passkeys: passkeys}, nil
+}
+
+func (r *MemoryPasskeys) Save(ctx context.Context, passkey *Passkey) error {
+	passkey.ID = bson.NewObjectID()
+	passkey.CreatedAt = time.Now()
+
+	stored := *passkey
+	stored.CreatedAt = storedTime(stored.CreatedAt)
+	stored.LastUsedAt = storedTime(stored.LastUsedAt)
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+	r.db.passkeys = append(r.db.passkeys, stored)
+
+	return nil
+}
+
+func (r *MemoryPasskeys) GetPasskeys(ctx context.Context, userID bson.ObjectID) ([]Passkey, error) {
+	r.db.mu.RLock()
+	defer r.db.mu.RUnlock()
+
+	passkeys := []Passkey{}
+	for _, passkey := range r.db.passkeys {
+		if passkey.UserID == userID {
+			passkeys = append(passkeys, passkey)
+		}
+	}
+	slices.SortStableFunc(passkeys, func(a, b Passkey) int { return cmp.Compare(a.CreatedAt.UnixMilli(), b.CreatedAt.UnixMilli()) })
+
+	return passkeys, nil
+}
+
+// Returns the index of the user's passkey, or -1. the caller holds the lock.
+func (r *MemoryPasskeys) index(passkeyID, userID bson.ObjectID) int {
+	return slices.IndexFunc(r.db.passkeys, func(p Passkey) bool { return p.ID == passkeyID && p.UserID == userID })
+}
+
+func (r *MemoryPasskeys) Rename(ctx context.Context, passkeyID, userID bson.ObjectID, name string) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	i := r.index(passkeyID, userID)
+	if i == -1 {
+		return mongo.ErrNoDocuments
+	}
+	r.db.passkeys[i].Name = name
+
+	return nil
+}
+
+func (r *MemoryPasskeys) Delete(ctx context.Context, passkeyID, userID bson.ObjectID) error {
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	i := r.index(passkeyID, userID)
+	if i == -1 {
+		return mongo.ErrNoDocuments
+	}
+	r.db.passkeys = slices.Delete(r.db.passkeys, i, i+1)
+
+	return nil
+}
+
+func (r *MemoryPasskeys) UpdateCredential(ctx context.Context, passkey *Passkey, credential webauthn.Credential) error {
+	passkey.Credential = credential
+	passkey.LastUsedAt = time.Now()
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	if i := slices.IndexFunc(r.db.passkeys, func(p Passkey) bool { return p.ID == passkey.ID }); i != -1 {
+		r.db.passkeys[i].Credential = credential
+		r.db.passkeys[i].LastUsedAt = storedTime(passkey.LastUsedAt)
+	}
+
+	return nil
+}
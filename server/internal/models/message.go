@@ -2,42 +2,65 @@ package models
 
 import (
 	"context"
+	"maps"
+	"slices"
 	"time"
 
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/db"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
 
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
 	"go.mongodb.org/mongo-driver/v2/mongo/options"
 )
 
-const messagesLimit = 30
-
 type Message struct {
-	ID             bson.ObjectID `json:"_id" bson:"_id"`
-	Sender         bson.ObjectID `json:"sender" bson:"sender"`
-	ConversationID bson.ObjectID `json:"conversationId" bson:"conversationId"`
-	Text           string        `json:"text" bson:"text"`
-	Image          string        `json:"image,omitempty" bson:"image"`
-	CreatedAt      time.Time     `json:"createdAt" bson:"createdAt"`
+	ID             bson.ObjectID    `json:"_id" bson:"_id"`
+	Sender         bson.ObjectID    `json:"sender" bson:"sender"`
+	ConversationID bson.ObjectID    `json:"conversationId" bson:"conversationId"`
+	Text           string           `json:"text" bson:"text"`
+	Image          string           `json:"image,omitempty" bson:"image"`
+	ImageMeta      *utils.ImageMeta `json:"imageMeta,omitempty" bson:"imageMeta,omitempty"`
+	Audio          string           `json:"audio,omitempty" bson:"audio,omitempty"`
+	AudioMeta      *utils.AudioMeta `json:"audioMeta,omitempty" bson:"audioMeta,omitempty"`
+	CreatedAt      time.Time        `json:"createdAt" bson:"createdAt"`
+}
+
+// MongoMessages stores messages in the "messages" collection.
+type MongoMessages struct {
+	collection *mongo.Collection
+	// MESSAGES_PAGE_SIZE, how many messages GetMessages returns per page.
+	pageSize int64
 }
 
-func (message Message) Save() error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func NewMongoMessages(m *db.Mongo, pageSize int) *MongoMessages {
+	return &MongoMessages{collection: m.Collection("messages"), pageSize: int64(pageSize)}
+}
+
+func (r *MongoMessages) Save(ctx context.Context, message Message) error {
+	ctx, done := observe(ctx, "Messages.Save")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
-	_, err := db.Messages.InsertOne(ctx, message)
+	_, err := r.collection.InsertOne(ctx, message)
 
 	return err
 }
 
-func (conversation *Conversation) GetMessages(page int64) ([]Message, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// Returns a page of the conversation's messages, newest first. pages start at 1.
+func (r *MongoMessages) GetMessages(ctx context.Context, conversationID bson.ObjectID, page int64) ([]Message, error) {
+	ctx, done := observe(ctx, "Messages.GetMessages")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
 	opts := options.Find().SetSort(bson.D{
 		{Key: "createdAt", Value: -1},
-	}).SetLimit(messagesLimit).SetSkip((page - 1) * messagesLimit)
-	cursor, err := db.Messages.Find(ctx, bson.M{"conversationId": conversation.ID}, opts)
+	}).SetLimit(r.pageSize).SetSkip((page - 1) * r.pageSize)
+	cursor, err := r.collection.Find(ctx, bson.M{"conversationId": conversationID}, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -48,22 +71,83 @@ func (conversation *Conversation) GetMessages(page int64) ([]Message, error) {
 	return messages, err
 }
 
-func GetMessage(filter bson.M) (Message, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Returns the message only if it was sent by senderID.
+func (r *MongoMessages) FindBySender(ctx context.Context, messageID, senderID bson.ObjectID) (Message, error) {
+	ctx, done := observe(ctx, "Messages.FindBySender")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var message Message
 
-	err := db.Messages.FindOne(ctx, filter).Decode(&message)
+	err := r.collection.FindOne(ctx, bson.M{"_id": messageID, "sender": senderID}).Decode(&message)
+
+	return message, err
+}
+
+// Returns a message with one of paths as its image or voice note.
+func (r *MongoMessages) FindByMedia(ctx context.Context, paths []string) (Message, error) {
+	ctx, done := observe(ctx, "Messages.FindByMedia")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"image": bson.M{"$in": paths}},
+		bson.M{"audio": bson.M{"$in": paths}},
+	}}
+	opts := options.FindOne().SetProjection(bson.M{"conversationId": 1, "sender": 1})
+
+	var message Message
+	err := r.collection.FindOne(ctx, filter, opts).Decode(&message)
 
 	return message, err
 }
 
-func (message *Message) Delete() error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+// Returns the storage paths of every image (and its variants) and voice note attached to a message.
+func (r *MongoMessages) MediaPaths(ctx context.Context) ([]string, error) {
+	ctx, done := observe(ctx, "Messages.MediaPaths")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	filter := bson.M{"$or": bson.A{
+		bson.M{"image": bson.M{"$nin": bson.A{"", nil}}},
+		bson.M{"audio": bson.M{"$exists": true}},
+	}}
+	opts := options.Find().SetProjection(bson.M{"image": 1, "audio": 1, "imageMeta.variants": 1})
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var paths []string
+	for cursor.Next(ctx) {
+		var message Message
+		if err := cursor.Decode(&message); err != nil {
+			return nil, err
+		}
+		paths = append(paths, message.Image, message.Audio)
+		if message.ImageMeta != nil {
+			paths = slices.AppendSeq(paths, maps.Values(message.ImageMeta.Variants))
+		}
+	}
+
+	return paths, cursor.Err()
+}
+
+func (r *MongoMessages) Delete(ctx context.Context, messageID bson.ObjectID) error {
+	ctx, done := observe(ctx, "Messages.Delete")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	_, err := db.Messages.DeleteOne(ctx, bson.M{"_id": message.ID})
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": messageID})
 
 	return err
 }
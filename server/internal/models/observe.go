@@ -0,0 +1,19 @@
+package models
+
+import (
+	"context"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/tracing"
+)
+
+// Starts the span of a model function and times it (see metrics.MongoOperations), the returned func ends both.
+func observe(ctx context.Context, function string) (context.Context, func()) {
+	stop := metrics.Time(metrics.MongoOperations, function)
+	ctx, span := tracing.StartChild(ctx, "models."+function)
+
+	return ctx, func() {
+		stop()
+		span.End()
+	}
+}
@@ -0,0 +1,173 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/db"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+type Passkey struct {
+	ID         bson.ObjectID       `json:"_id" bson:"_id"`
+	UserID     bson.ObjectID       `json:"userId" bson:"userId"`
+	Name       string              `json:"name" bson:"name"`
+	Credential webauthn.Credential `json:"-" bson:"credential"`
+	CreatedAt  time.Time           `json:"createdAt" bson:"createdAt"`
+	LastUsedAt time.Time           `json:"lastUsedAt,omitzero" bson:"lastUsedAt,omitempty"`
+}
+
+// PasskeyUser wraps a user and their registered passkeys so it can be used as a webauthn.User.
+// The user handle is the 12 bytes of the user's ObjectID.
+type PasskeyUser struct {
+	User     User
+	Passkeys []Passkey
+}
+
+func (u *PasskeyUser) WebAuthnID() []byte {
+	id := u.User.ID
+	return id[:]
+}
+
+func (u *PasskeyUser) WebAuthnName() string {
+	return u.User.Email
+}
+
+func (u *PasskeyUser) WebAuthnDisplayName() string {
+	return u.User.Name
+}
+
+func (u *PasskeyUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, len(u.Passkeys))
+	for i, passkey := range u.Passkeys {
+		credentials[i] = passkey.Credential
+	}
+
+	return credentials
+}
+
+// Returns the stored passkey matching the given credential id, or nil.
+func (u *PasskeyUser) FindPasskey(credentialID []byte) *Passkey {
+	for i := range u.Passkeys {
+		if string(u.Passkeys[i].Credential.ID) == string(credentialID) {
+			return &u.Passkeys[i]
+		}
+	}
+
+	return nil
+}
+
+// MongoPasskeys stores passkeys in the "passkeys" collection.
+type MongoPasskeys struct {
+	collection *mongo.Collection
+}
+
+func NewMongoPasskeys(m *db.Mongo) *MongoPasskeys {
+	return &MongoPasskeys{collection: m.Collection("passkeys")}
+}
+
+// Loads all of the user's passkeys.
+func (r *MongoPasskeys) GetPasskeyUser(ctx context.Context, user User) (*PasskeyUser, error) {
+	passkeys, err := r.GetPasskeys(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PasskeyUser{User: user, Passkeys: passkeys}, nil
+}
+
+func (r *MongoPasskeys) Save(ctx context.Context, passkey *Passkey) error {
+	ctx, done := observe(ctx, "Passkeys.Save")
+	defer done()
+
+	passkey.ID = bson.NewObjectID()
+	passkey.CreatedAt = time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	_, err := r.collection.InsertOne(ctx, passkey)
+
+	return err
+}
+
+func (r *MongoPasskeys) GetPasskeys(ctx context.Context, userID bson.ObjectID) ([]Passkey, error) {
+	ctx, done := observe(ctx, "Passkeys.GetPasskeys")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}})
+	cursor, err := r.collection.Find(ctx, bson.M{"userId": userID}, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	passkeys := []Passkey{}
+	err = cursor.All(ctx, &passkeys)
+
+	return passkeys, err
+}
+
+// Renames a passkey owned by the given user. returns mongo.ErrNoDocuments if there's no such passkey.
+func (r *MongoPasskeys) Rename(ctx context.Context, passkeyID, userID bson.ObjectID, name string) error {
+	ctx, done := observe(ctx, "Passkeys.Rename")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	update := bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}}}}
+	result, err := r.collection.UpdateOne(ctx, bson.M{"_id": passkeyID, "userId": userID}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// Deletes a passkey owned by the given user. returns mongo.ErrNoDocuments if there's no such passkey.
+func (r *MongoPasskeys) Delete(ctx context.Context, passkeyID, userID bson.ObjectID) error {
+	ctx, done := observe(ctx, "Passkeys.Delete")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": passkeyID, "userId": userID})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
+
+// Stores the credential returned by a successful login (new signature counter and flags) and marks the passkey as used.
+func (r *MongoPasskeys) UpdateCredential(ctx context.Context, passkey *Passkey, credential webauthn.Credential) error {
+	ctx, done := observe(ctx, "Passkeys.UpdateCredential")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	passkey.Credential = credential
+	passkey.LastUsedAt = time.Now()
+
+	update := bson.D{{Key: "$set", Value: bson.D{
+		{Key: "credential", Value: passkey.Credential},
+		{Key: "lastUsedAt", Value: passkey.LastUsedAt},
+	}}}
+	_, err := r.collection.UpdateByID(ctx, passkey.ID, update)
+
+	return err
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"context"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// the repositories are implemented against mongo (MongoUsers...) and in memory (see NewMemory), both behave
+// the same, down to returning mongo.ErrNoDocuments when nothing matches.
+
+type Users interface {
+	// Inserts the user as given, setting its ID and CreatedAt. the password must already be hashed (see utils.PasswordHasher).
+	Save(ctx context.Context, user *User) error
+	ExistsByID(ctx context.Context, userID bson.ObjectID) (bool, error)
+	EmailExists(ctx context.Context, email string) (bool, error)
+	AvatarExists(ctx context.Context, paths []string) (bool, error)
+	FindByID(ctx context.Context, userID bson.ObjectID) (User, error)
+	FindByEmail(ctx context.Context, email string) (User, error)
+	FindByIdentity(ctx context.Context, provider, subject string) (User, error)
+	FindProfile(ctx context.Context, userID bson.ObjectID) (User, error)
+	// Case-insensitive regex search over names and emails.
+	Search(ctx context.Context, term string) ([]User, error)
+	SetName(ctx context.Context, userID bson.ObjectID, name string) error
+	SetPassword(ctx context.Context, userID bson.ObjectID, hashedPassword string) error
+	SetAvatar(ctx context.Context, userID bson.ObjectID, path string, meta utils.ImageMeta) error
+	LinkIdentity(ctx context.Context, userID bson.ObjectID, identity Identity) error
+	AvatarPaths(ctx context.Context) ([]string, error)
+}
+
+type Conversations interface {
+	// Returns the new conversation's id, or the status code to respond with and the error.
+	Create(ctx context.Context, users [2]bson.ObjectID) (bson.ObjectID, int, error)
+	FindWithParticipant(ctx context.Context, conversationID, userID bson.ObjectID) (Conversation, error)
+	FindByParticipants(ctx context.Context, users [2]bson.ObjectID) (Conversation, error)
+	GetPopulated(ctx context.Context, userID bson.ObjectID) ([]PopulatedConversation, error)
+	// errors are only logged, it's always called in the background.
+	UpdateLastMessage(ctx context.Context, conversationID, messageID bson.ObjectID)
+	GetParticipantsIDs(ctx context.Context, userID bson.ObjectID) ([]bson.ObjectID, error)
+}
+
+type Messages interface {
+	Save(ctx context.Context, message Message) error
+	GetMessages(ctx context.Context, conversationID bson.ObjectID, page int64) ([]Message, error)
+	FindBySender(ctx context.Context, messageID, senderID bson.ObjectID) (Message, error)
+	FindByMedia(ctx context.Context, paths []string) (Message, error)
+	MediaPaths(ctx context.Context) ([]string, error)
+	Delete(ctx context.Context, messageID bson.ObjectID) error
+}
+
+type Passkeys interface {
+	GetPasskeyUser(ctx context.Context, user User) (*PasskeyUser, error)
+	Save(ctx context.Context, passkey *Passkey) error
+	GetPasskeys(ctx context.Context, userID bson.ObjectID) ([]Passkey, error)
+	Rename(ctx context.Context, passkeyID, userID bson.ObjectID, name string) error
+	Delete(ctx context.Context, passkeyID, userID bson.ObjectID) error
+	UpdateCredential(ctx context.Context, passkey *Passkey, credential webauthn.Credential) error
+}
+
+var (
+	_ Users         = (*MongoUsers)(nil)
+	_ Conversations = (*MongoConversations)(nil)
+	_ Messages      = (*MongoMessages)(nil)
+	_ Passkeys      = (*MongoPasskeys)(nil)
+	_ Users         = (*MemoryUsers)(nil)
+	_ Conversations = (*MemoryConversations)(nil)
+	_ Messages      = (*MemoryMessages)(nil)
+	_ Passkeys      = (*MemoryPasskeys)(nil)
+)
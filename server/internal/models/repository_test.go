@@ -0,0 +1,413 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/db"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+const testPageSize = 3
+
+type repositories struct {
+	users         Users
+	conversations Conversations
+	messages      Messages
+	passkeys      Passkeys
+}
+
+func newMemoryRepositories(t *testing.T) repositories {
+	memory := NewMemory(testPageSize)
+	return repositories{memory.Users, memory.Conversations, memory.Messages, memory.Passkeys}
+}
+
+// runs against the server at MONGODB_TEST_URI, in a database dropped once the test is done.
+func newMongoRepositories(t *testing.T) repositories {
+	uri := os.Getenv("MONGODB_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGODB_TEST_URI isn't set")
+	}
+
+	m, err := db.Connect(config.Mongo{URI: uri, Database: "chatify_test_" + bson.NewObjectID().Hex()})
+	if err != nil {
+		t.Skip("mongo is unavailable:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := m.Ping(ctx); err != nil {
+		m.Disconnect(ctx)
+		t.Skip("mongo is unavailable:", err)
+	}
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		m.Database.Drop(ctx)
+		m.Disconnect(ctx)
+	})
+
+	return repositories{NewMongoUsers(m), NewMongoConversations(m), NewMongoMessages(m, testPageSize), NewMongoPasskeys(m)}
+}
+
+// every implementation has to pass the same suite.
+func TestRepositories(t *testing.T) {
+	implementations := []struct {
+		name string
+		new  func(t *testing.T) repositories
+	}{
+		{"memory", newMemoryRepositories},
+		{"mongo", newMongoRepositories},
+	}
+
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Run("users", func(t *testing.T) { testUsers(t, impl.new(t)) })
+			t.Run("conversations", func(t *testing.T) { testConversations(t, impl.new(t)) })
+			t.Run("messages", func(t *testing.T) { testMessages(t, impl.new(t)) })
+			t.Run("passkeys", func(t *testing.T) { testPasskeys(t, impl.new(t)) })
+		})
+	}
+}
+
+func saveUser(t *testing.T, repos repositories, name, email string) User {
+	t.Helper()
+
+	user := User{Name: name, Email: email, Password: "hashed password"}
+	if err := repos.users.Save(context.Background(), &user); err != nil {
+		t.Fatal("saving user:", err)
+	}
+
+	return user
+}
+
+func ids[T any](docs []T, id func(T) bson.ObjectID) []bson.ObjectID {
+	result := make([]bson.ObjectID, len(docs))
+	for i, doc := range docs {
+		result[i] = id(doc)
+	}
+	return result
+}
+
+func testUsers(t *testing.T, repos repositories) {
+	ctx := context.Background()
+	alice := saveUser(t, repos, "Alice", "alice@example.com")
+	bob := saveUser(t, repos, "Bob", "bob@example.com")
+
+	if alice.ID.IsZero() || alice.CreatedAt.IsZero() {
+		t.Fatal("Save didn't set the id and creation time")
+	}
+	if alice.Password != "hashed password" {
+		t.Fatal("Save changed the password:", alice.Password)
+	}
+
+	if exists, err := repos.users.ExistsByID(ctx, alice.ID); err != nil || !exists {
+		t.Fatalf("ExistsByID = %v, %v", exists, err)
+	}
+	if exists, err := repos.users.ExistsByID(ctx, bson.NewObjectID()); err != nil || exists {
+		t.Fatalf("ExistsByID of an unknown id = %v, %v", exists, err)
+	}
+	if exists, err := repos.users.EmailExists(ctx, "bob@example.com"); err != nil || !exists {
+		t.Fatalf("EmailExists = %v, %v", exists, err)
+	}
+
+	found, err := repos.users.FindByEmail(ctx, "alice@example.com")
+	if err != nil || found.ID != alice.ID || found.Password != alice.Password || !found.CreatedAt.Equal(alice.CreatedAt.Truncate(time.Millisecond)) {
+		t.Fatalf("FindByEmail = %+v, %v", found, err)
+	}
+	if _, err := repos.users.FindByID(ctx, bson.NewObjectID()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("FindByID of an unknown id:", err)
+	}
+
+	profile, err := repos.users.FindProfile(ctx, bob.ID)
+	if err != nil || profile.ID != bob.ID || profile.Name != "Bob" || profile.Email != "" || profile.Password != "" {
+		t.Fatalf("FindProfile = %+v, %v", profile, err)
+	}
+
+	users, err := repos.users.Search(ctx, "^AL")
+	if err != nil || len(users) != 1 || users[0].ID != alice.ID || users[0].Email != alice.Email || users[0].Password != "" {
+		t.Fatalf("Search = %+v, %v", users, err)
+	}
+	users, err = repos.users.Search(ctx, "example\\.com")
+	if err != nil || !slices.Equal(ids(users, func(u User) bson.ObjectID { return u.ID }), []bson.ObjectID{alice.ID, bob.ID}) {
+		t.Fatalf("Search by email = %+v, %v", users, err)
+	}
+	if users, err = repos.users.Search(ctx, "carol"); err != nil || users != nil {
+		t.Fatalf("Search without matches = %+v, %v", users, err)
+	}
+
+	if err := repos.users.SetName(ctx, alice.ID, "Alicia"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.users.SetPassword(ctx, alice.ID, "hashed"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.users.SetName(ctx, bson.NewObjectID(), "Nobody"); err != nil {
+		t.Fatal("SetName of an unknown id:", err)
+	}
+	found, _ = repos.users.FindByID(ctx, alice.ID)
+	if found.Name != "Alicia" || found.Password != "hashed" {
+		t.Fatalf("after SetName and SetPassword = %+v", found)
+	}
+
+	meta := utils.ImageMeta{Width: 10, Height: 10, Variants: map[string]string{"thumb": "avatars/a-thumb.webp"}}
+	if err := repos.users.SetAvatar(ctx, alice.ID, "avatars/a.webp", meta); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := repos.users.AvatarExists(ctx, []string{"avatars/a.webp"}); err != nil || !exists {
+		t.Fatalf("AvatarExists = %v, %v", exists, err)
+	}
+	if exists, err := repos.users.AvatarExists(ctx, []string{"avatars/b.webp"}); err != nil || exists {
+		t.Fatalf("AvatarExists of an unknown path = %v, %v", exists, err)
+	}
+	paths, err := repos.users.AvatarPaths(ctx)
+	slices.Sort(paths)
+	if err != nil || !slices.Equal(paths, []string{"avatars/a-thumb.webp", "avatars/a.webp"}) {
+		t.Fatalf("AvatarPaths = %v, %v", paths, err)
+	}
+
+	identity := Identity{Provider: "google", Subject: "123", Email: "bob@gmail.com", LinkedAt: time.Now()}
+	if err := repos.users.LinkIdentity(ctx, bob.ID, identity); err != nil {
+		t.Fatal(err)
+	}
+	found, err = repos.users.FindByIdentity(ctx, "google", "123")
+	if err != nil || found.ID != bob.ID || len(found.Identities) != 1 || found.Identities[0].Email != "bob@gmail.com" {
+		t.Fatalf("FindByIdentity = %+v, %v", found, err)
+	}
+	if _, err := repos.users.FindByIdentity(ctx, "github", "123"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("FindByIdentity of another provider:", err)
+	}
+}
+
+func testConversations(t *testing.T, repos repositories) {
+	ctx := context.Background()
+	alice := saveUser(t, repos, "Alice", "alice@example.com")
+	bob := saveUser(t, repos, "Bob", "bob@example.com")
+	carol := saveUser(t, repos, "Carol", "carol@example.com")
+
+	withBob, code, err := repos.conversations.Create(ctx, [2]bson.ObjectID{alice.ID, bob.ID})
+	if err != nil || code != 201 {
+		t.Fatalf("Create = %v, %v", code, err)
+	}
+	withCarol, _, err := repos.conversations.Create(ctx, [2]bson.ObjectID{carol.ID, alice.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conversation, err := repos.conversations.FindWithParticipant(ctx, withBob, bob.ID)
+	if err != nil || conversation.Participants != [2]bson.ObjectID{alice.ID, bob.ID} || !conversation.LastMessage.IsZero() {
+		t.Fatalf("FindWithParticipant = %+v, %v", conversation, err)
+	}
+	if _, err := repos.conversations.FindWithParticipant(ctx, withBob, carol.ID); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("FindWithParticipant of a non participant:", err)
+	}
+
+	// participants match in any order.
+	conversation, err = repos.conversations.FindByParticipants(ctx, [2]bson.ObjectID{bob.ID, alice.ID})
+	if err != nil || conversation.ID != withBob {
+		t.Fatalf("FindByParticipants = %+v, %v", conversation, err)
+	}
+	if _, err := repos.conversations.FindByParticipants(ctx, [2]bson.ObjectID{bob.ID, carol.ID}); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("FindByParticipants without a conversation:", err)
+	}
+
+	participants, err := repos.conversations.GetParticipantsIDs(ctx, alice.ID)
+	if err != nil || len(participants) != 2 || !slices.Contains(participants, bob.ID) || !slices.Contains(participants, carol.ID) {
+		t.Fatalf("GetParticipantsIDs = %v, %v", participants, err)
+	}
+	if participants, err = repos.conversations.GetParticipantsIDs(ctx, bson.NewObjectID()); err != nil || participants == nil || len(participants) != 0 {
+		t.Fatalf("GetParticipantsIDs without conversations = %#v, %v", participants, err)
+	}
+
+	first := Message{ID: bson.NewObjectID(), Sender: alice.ID, ConversationID: withBob, Text: "first", CreatedAt: time.Now().Add(-time.Minute)}
+	last := Message{ID: bson.NewObjectID(), Sender: bob.ID, ConversationID: withBob, Text: "last", Audio: "audio/a.ogg", CreatedAt: time.Now()}
+	for _, message := range []Message{first, last} {
+		if err := repos.messages.Save(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repos.conversations.UpdateLastMessage(ctx, withBob, first.ID)
+	if conversation, _ := repos.conversations.FindWithParticipant(ctx, withBob, alice.ID); conversation.LastMessage != first.ID {
+		t.Fatalf("after UpdateLastMessage = %+v", conversation)
+	}
+	// a nil id looks the last message up.
+	repos.conversations.UpdateLastMessage(ctx, withBob, bson.NilObjectID)
+	if conversation, _ := repos.conversations.FindWithParticipant(ctx, withBob, alice.ID); conversation.LastMessage != last.ID {
+		t.Fatalf("after UpdateLastMessage with a nil id = %+v", conversation)
+	}
+
+	populated, err := repos.conversations.GetPopulated(ctx, alice.ID)
+	if err != nil || len(populated) != 2 {
+		t.Fatalf("GetPopulated = %+v, %v", populated, err)
+	}
+	if populated[0].ID != withBob || populated[0].Participant.ID != bob.ID || populated[0].Participant.Email != bob.Email {
+		t.Fatalf("GetPopulated, first conversation = %+v", populated[0])
+	}
+	lastMessage := populated[0].LastMessage
+	if lastMessage == nil || lastMessage.ID != last.ID || lastMessage.Text != "last" || lastMessage.Audio != "audio/a.ogg" ||
+		lastMessage.Sender != bob.ID || !lastMessage.ConversationID.IsZero() || !lastMessage.CreatedAt.Equal(last.CreatedAt.Truncate(time.Millisecond)) {
+		t.Fatalf("GetPopulated, last message = %+v", lastMessage)
+	}
+	if populated[1].ID != withCarol || populated[1].Participant.Name != "Carol" || populated[1].LastMessage != nil {
+		t.Fatalf("GetPopulated, second conversation = %+v", populated[1])
+	}
+
+	// once every message is deleted there's no last message left.
+	repos.messages.Delete(ctx, first.ID)
+	repos.messages.Delete(ctx, last.ID)
+	repos.conversations.UpdateLastMessage(ctx, withBob, bson.NilObjectID)
+	if conversation, _ := repos.conversations.FindWithParticipant(ctx, withBob, alice.ID); !conversation.LastMessage.IsZero() {
+		t.Fatalf("after deleting every message = %+v", conversation)
+	}
+	if populated, err = repos.conversations.GetPopulated(ctx, bson.NewObjectID()); err != nil || populated != nil {
+		t.Fatalf("GetPopulated without conversations = %+v, %v", populated, err)
+	}
+}
+
+func testMessages(t *testing.T, repos repositories) {
+	ctx := context.Background()
+	sender, conversationID := bson.NewObjectID(), bson.NewObjectID()
+	start := time.Now().Add(-time.Hour)
+
+	var saved []Message
+	for i := range 5 {
+		message := Message{ID: bson.NewObjectID(), Sender: sender, ConversationID: conversationID, Text: "hi", CreatedAt: start.Add(time.Duration(i) * time.Second)}
+		switch i {
+		case 1:
+			message.Image = "images/1.webp"
+			message.ImageMeta = &utils.ImageMeta{Width: 1, Height: 1, Variants: map[string]string{"thumb": "images/1-thumb.webp"}}
+		case 2:
+			message.Audio = "audio/2.ogg"
+			message.AudioMeta = &utils.AudioMeta{Duration: 1000, Codec: "opus", Waveform: []int{1, 2, 3}}
+		}
+		if err := repos.messages.Save(ctx, message); err != nil {
+			t.Fatal(err)
+		}
+		saved = append(saved, message)
+	}
+	if err := repos.messages.Save(ctx, saved[0]); err == nil {
+		t.Fatal("saving a message twice didn't fail")
+	}
+	if err := repos.messages.Save(ctx, Message{ID: bson.NewObjectID(), ConversationID: bson.NewObjectID(), CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+
+	messageIDs := func(messages []Message) []bson.ObjectID {
+		return ids(messages, func(m Message) bson.ObjectID { return m.ID })
+	}
+	page, err := repos.messages.GetMessages(ctx, conversationID, 1)
+	if err != nil || !slices.Equal(messageIDs(page), []bson.ObjectID{saved[4].ID, saved[3].ID, saved[2].ID}) {
+		t.Fatalf("GetMessages, first page = %v, %v", messageIDs(page), err)
+	}
+	if page[2].AudioMeta == nil || !slices.Equal(page[2].AudioMeta.Waveform, []int{1, 2, 3}) || !page[2].CreatedAt.Equal(saved[2].CreatedAt.Truncate(time.Millisecond)) {
+		t.Fatalf("GetMessages didn't keep the message = %+v", page[2])
+	}
+	page, err = repos.messages.GetMessages(ctx, conversationID, 2)
+	if err != nil || !slices.Equal(messageIDs(page), []bson.ObjectID{saved[1].ID, saved[0].ID}) {
+		t.Fatalf("GetMessages, second page = %v, %v", messageIDs(page), err)
+	}
+	if page, err = repos.messages.GetMessages(ctx, conversationID, 3); err != nil || page != nil {
+		t.Fatalf("GetMessages, past the last page = %v, %v", page, err)
+	}
+
+	if message, err := repos.messages.FindBySender(ctx, saved[1].ID, sender); err != nil || message.Image != "images/1.webp" || message.ImageMeta.Variants["thumb"] != "images/1-thumb.webp" {
+		t.Fatalf("FindBySender = %+v, %v", message, err)
+	}
+	if _, err := repos.messages.FindBySender(ctx, saved[1].ID, bson.NewObjectID()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("FindBySender of another sender:", err)
+	}
+
+	message, err := repos.messages.FindByMedia(ctx, []string{"audio/2.ogg", "images/unknown.webp"})
+	if err != nil || message.ID != saved[2].ID || message.ConversationID != conversationID || message.Sender != sender || message.Audio != "" || message.Text != "" {
+		t.Fatalf("FindByMedia = %+v, %v", message, err)
+	}
+	if _, err := repos.messages.FindByMedia(ctx, []string{"images/unknown.webp"}); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("FindByMedia of an unknown path:", err)
+	}
+
+	paths, err := repos.messages.MediaPaths(ctx)
+	paths = slices.DeleteFunc(paths, func(path string) bool { return path == "" })
+	slices.Sort(paths)
+	if err != nil || !slices.Equal(paths, []string{"audio/2.ogg", "images/1-thumb.webp", "images/1.webp"}) {
+		t.Fatalf("MediaPaths = %v, %v", paths, err)
+	}
+
+	if err := repos.messages.Delete(ctx, saved[4].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.messages.Delete(ctx, bson.NewObjectID()); err != nil {
+		t.Fatal("Delete of an unknown id:", err)
+	}
+	if _, err := repos.messages.FindBySender(ctx, saved[4].ID, sender); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("FindBySender of a deleted message:", err)
+	}
+}
+
+func testPasskeys(t *testing.T, repos repositories) {
+	ctx := context.Background()
+	user := saveUser(t, repos, "Alice", "alice@example.com")
+
+	if passkeys, err := repos.passkeys.GetPasskeys(ctx, user.ID); err != nil || passkeys == nil || len(passkeys) != 0 {
+		t.Fatalf("GetPasskeys without passkeys = %#v, %v", passkeys, err)
+	}
+
+	first := Passkey{UserID: user.ID, Name: "Laptop", Credential: webauthn.Credential{ID: []byte("first")}}
+	second := Passkey{UserID: user.ID, Name: "Phone", Credential: webauthn.Credential{ID: []byte("second")}}
+	for _, passkey := range []*Passkey{&first, &second} {
+		if err := repos.passkeys.Save(ctx, passkey); err != nil {
+			t.Fatal(err)
+		}
+		// creation times decide the order.
+		time.Sleep(time.Millisecond * 2)
+	}
+	if first.ID.IsZero() || first.CreatedAt.IsZero() {
+		t.Fatal("Save didn't set the id and creation time")
+	}
+
+	passkeyUser, err := repos.passkeys.GetPasskeyUser(ctx, user)
+	if err != nil || passkeyUser.User.ID != user.ID || len(passkeyUser.Passkeys) != 2 || passkeyUser.Passkeys[0].ID != first.ID {
+		t.Fatalf("GetPasskeyUser = %+v, %v", passkeyUser, err)
+	}
+	if passkey := passkeyUser.FindPasskey([]byte("second")); passkey == nil || passkey.Name != "Phone" {
+		t.Fatalf("FindPasskey = %+v", passkey)
+	}
+
+	if err := repos.passkeys.Rename(ctx, first.ID, user.ID, "Work laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.passkeys.Rename(ctx, first.ID, bson.NewObjectID(), "Stolen"); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("Rename by another user:", err)
+	}
+
+	if err := repos.passkeys.UpdateCredential(ctx, &second, webauthn.Credential{ID: []byte("second"), Authenticator: webauthn.Authenticator{SignCount: 7}}); err != nil {
+		t.Fatal(err)
+	}
+	if second.LastUsedAt.IsZero() {
+		t.Fatal("UpdateCredential didn't set the last use")
+	}
+
+	passkeys, _ := repos.passkeys.GetPasskeys(ctx, user.ID)
+	if len(passkeys) != 2 || passkeys[0].Name != "Work laptop" || passkeys[1].Credential.Authenticator.SignCount != 7 || passkeys[1].LastUsedAt.IsZero() {
+		t.Fatalf("after Rename and UpdateCredential = %+v", passkeys)
+	}
+
+	if err := repos.passkeys.Delete(ctx, first.ID, bson.NewObjectID()); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("Delete by another user:", err)
+	}
+	if err := repos.passkeys.Delete(ctx, first.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.passkeys.Delete(ctx, first.ID, user.ID); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("deleting a passkey twice:", err)
+	}
+	if passkeys, _ := repos.passkeys.GetPasskeys(ctx, user.ID); len(passkeys) != 1 || passkeys[0].ID != second.ID {
+		t.Fatalf("after Delete = %+v", passkeys)
+	}
+}
@@ -2,6 +2,9 @@ package models
 
 import (
 	"context"
+	"maps"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/db"
@@ -12,12 +15,22 @@ import (
 )
 
 type User struct {
-	ID        bson.ObjectID `json:"_id" bson:"_id"`
-	Name      string        `json:"name" bson:"name" form:"name" binding:"required,min=3,max=30"`
-	Email     string        `json:"email" bson:"email" form:"email" binding:"required,email"`
-	Avatar    string        `json:"avatar" bson:"avatar"`
-	Password  string        `json:"password,omitempty" bson:"password" form:"password" binding:"required,min=6"`
-	CreatedAt time.Time     `json:"createdAt,omitempty" bson:"createdAt"`
+	ID         bson.ObjectID    `json:"_id" bson:"_id"`
+	Name       string           `json:"name" bson:"name" form:"name" binding:"required,min=3,max=30"`
+	Email      string           `json:"email" bson:"email" form:"email" binding:"required,email"`
+	Avatar     string           `json:"avatar" bson:"avatar"`
+	AvatarMeta *utils.ImageMeta `json:"avatarMeta,omitempty" bson:"avatarMeta,omitempty"`
+	Password   string           `json:"password,omitempty" bson:"password" form:"password" binding:"required"`
+	CreatedAt  time.Time        `json:"createdAt,omitempty" bson:"createdAt"`
+	// external (OIDC) accounts linked to this user.
+	Identities []Identity `json:"-" bson:"identities,omitempty"`
+}
+
+type Identity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"subject" bson:"subject"`
+	Email    string    `json:"email" bson:"email"`
+	LinkedAt time.Time `json:"linkedAt" bson:"linkedAt"`
 }
 
 type LoginBody struct {
@@ -25,30 +38,45 @@ type LoginBody struct {
 	Password string `json:"password" binding:"required"`
 }
 
-func (user *User) Save() error {
-	hashedPw, err := utils.HashPassword(user.Password)
-	if err != nil {
-		return err
-	}
+// Emails are stored and looked up lowercase, "Jane@chatify.dev" and "jane@chatify.dev" are the same account.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// MongoUsers stores users in the "users" collection.
+type MongoUsers struct {
+	collection *mongo.Collection
+}
+
+func NewMongoUsers(m *db.Mongo) *MongoUsers {
+	return &MongoUsers{collection: m.Collection("users")}
+}
+
+// Inserts the user, its password must already be hashed (see utils.PasswordHasher).
+// users created through an OIDC provider have no password.
+func (r *MongoUsers) Save(ctx context.Context, user *User) error {
+	ctx, done := observe(ctx, "Users.Save")
+	defer done()
 
 	user.ID = bson.NewObjectID()
-	user.Password = hashedPw
 	user.CreatedAt = time.Now()
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
-	_, err = db.Users.InsertOne(ctx, user)
+	_, err := r.collection.InsertOne(ctx, user)
 
 	return err
 }
 
-func UserExists(filter bson.M) (bool, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (r *MongoUsers) exists(ctx context.Context, function string, filter bson.M) (bool, error) {
+	ctx, done := observe(ctx, function)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
-	var user User
-	err := db.Users.FindOne(ctx, filter).Decode(&user)
+	err := r.collection.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"_id": 1})).Err()
 
 	switch err {
 	case nil:
@@ -60,18 +88,56 @@ func UserExists(filter bson.M) (bool, error) {
 	}
 }
 
-func FindUser(filter bson.M, opts *options.FindOneOptionsBuilder) (User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (r *MongoUsers) ExistsByID(ctx context.Context, userID bson.ObjectID) (bool, error) {
+	return r.exists(ctx, "Users.ExistsByID", bson.M{"_id": userID})
+}
+
+func (r *MongoUsers) EmailExists(ctx context.Context, email string) (bool, error) {
+	return r.exists(ctx, "Users.EmailExists", bson.M{"email": email})
+}
+
+// Returns true if one of paths is the avatar of a user.
+func (r *MongoUsers) AvatarExists(ctx context.Context, paths []string) (bool, error) {
+	return r.exists(ctx, "Users.AvatarExists", bson.M{"avatar": bson.M{"$in": paths}})
+}
+
+func (r *MongoUsers) find(ctx context.Context, function string, filter bson.M, opts *options.FindOneOptionsBuilder) (User, error) {
+	ctx, done := observe(ctx, function)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
 	var user User
-	err := db.Users.FindOne(ctx, filter, opts).Decode(&user)
+	err := r.collection.FindOne(ctx, filter, opts).Decode(&user)
 
 	return user, err
 }
 
-func SearchUsers(term string) ([]User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+func (r *MongoUsers) FindByID(ctx context.Context, userID bson.ObjectID) (User, error) {
+	return r.find(ctx, "Users.FindByID", bson.M{"_id": userID}, nil)
+}
+
+func (r *MongoUsers) FindByEmail(ctx context.Context, email string) (User, error) {
+	return r.find(ctx, "Users.FindByEmail", bson.M{"email": email}, nil)
+}
+
+func (r *MongoUsers) FindByIdentity(ctx context.Context, provider, subject string) (User, error) {
+	return r.find(ctx, "Users.FindByIdentity",
+		bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}, nil)
+}
+
+// Returns only what other users can see of the user: their id, name and avatar.
+func (r *MongoUsers) FindProfile(ctx context.Context, userID bson.ObjectID) (User, error) {
+	opts := options.FindOne().SetProjection(bson.D{{Key: "_id", Value: 1}, {Key: "name", Value: 1}, {Key: "avatar", Value: 1}, {Key: "avatarMeta", Value: 1}})
+	return r.find(ctx, "Users.FindProfile", bson.M{"_id": userID}, opts)
+}
+
+func (r *MongoUsers) Search(ctx context.Context, term string) ([]User, error) {
+	ctx, done := observe(ctx, "Users.Search")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
 	regex := bson.M{"$regex": term, "$options": "i"} // i = case-insensitive
@@ -84,15 +150,16 @@ func SearchUsers(term string) ([]User, error) {
 	}
 
 	projection := bson.M{
-		"name":   1,
-		"email":  1,
-		"avatar": 1,
+		"name":       1,
+		"email":      1,
+		"avatar":     1,
+		"avatarMeta": 1,
 	}
 
 	opts := options.Find().SetProjection(projection)
 
 	var users []User
-	cursor, err := db.Users.Find(ctx, filter, opts)
+	cursor, err := r.collection.Find(ctx, filter, opts)
 	if err != nil {
 		return users, err
 	}
@@ -103,11 +170,61 @@ func SearchUsers(term string) ([]User, error) {
 	return users, err
 }
 
-func UpdateUser(userID bson.ObjectID, update bson.D) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+func (r *MongoUsers) update(ctx context.Context, function string, userID bson.ObjectID, update bson.D) error {
+	ctx, done := observe(ctx, function)
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	_, err := db.Users.UpdateByID(ctx, userID, update)
+	_, err := r.collection.UpdateByID(ctx, userID, update)
 
 	return err
 }
+
+func (r *MongoUsers) SetName(ctx context.Context, userID bson.ObjectID, name string) error {
+	return r.update(ctx, "Users.SetName", userID, bson.D{{Key: "$set", Value: bson.D{{Key: "name", Value: name}}}})
+}
+
+// Stores an already hashed password (see utils.PasswordHasher).
+func (r *MongoUsers) SetPassword(ctx context.Context, userID bson.ObjectID, hashedPassword string) error {
+	return r.update(ctx, "Users.SetPassword", userID, bson.D{{Key: "$set", Value: bson.D{{Key: "password", Value: hashedPassword}}}})
+}
+
+func (r *MongoUsers) SetAvatar(ctx context.Context, userID bson.ObjectID, path string, meta utils.ImageMeta) error {
+	return r.update(ctx, "Users.SetAvatar", userID, bson.D{{Key: "$set", Value: bson.D{{Key: "avatar", Value: path}, {Key: "avatarMeta", Value: meta}}}})
+}
+
+func (r *MongoUsers) LinkIdentity(ctx context.Context, userID bson.ObjectID, identity Identity) error {
+	return r.update(ctx, "Users.LinkIdentity", userID, bson.D{{Key: "$push", Value: bson.D{{Key: "identities", Value: identity}}}})
+}
+
+// Returns the storage paths of every user's avatar and its variants.
+func (r *MongoUsers) AvatarPaths(ctx context.Context) ([]string, error) {
+	ctx, done := observe(ctx, "Users.AvatarPaths")
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	opts := options.Find().SetProjection(bson.M{"avatar": 1, "avatarMeta.variants": 1})
+	cursor, err := r.collection.Find(ctx, bson.M{"avatar": bson.M{"$nin": bson.A{"", nil}}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var paths []string
+	for cursor.Next(ctx) {
+		var user User
+		if err := cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		paths = append(paths, user.Avatar)
+		if user.AvatarMeta != nil {
+			paths = slices.AppendSeq(paths, maps.Values(user.AvatarMeta.Variants))
+		}
+	}
+
+	return paths, cursor.Err()
+}
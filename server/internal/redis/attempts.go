@@ -0,0 +1,151 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	FailPrefix     = "auth:fail:"
+	LockPrefix     = "auth:lock:"
+	SecurityEvents = "events:security"
+
+	failWindow  = time.Hour
+	baseBackoff = time.Second
+	maxLockout  = time.Minute * 15
+)
+
+// after "free" failures every failure locks the subject for an exponentially growing duration (1s, 2s, 4s...).
+// after "lockoutAfter" failures the subject is locked for maxLockout.
+type attemptPolicy struct {
+	kind         string
+	free         int64
+	lockoutAfter int64
+}
+
+var (
+	accountPolicy = attemptPolicy{kind: "account", free: 3, lockoutAfter: 10}
+	ipPolicy      = attemptPolicy{kind: "ip", free: 10, lockoutAfter: 50}
+)
+
+type LoginFailure struct {
+	Attempts   int64
+	RetryAfter time.Duration
+	// true if this failure locked the account for maxLockout.
+	Locked bool
+}
+
+func (p attemptPolicy) key(prefix, subject string) string {
+	return prefix + p.kind + ":" + subject
+}
+
+func (p attemptPolicy) backoff(attempts int64) time.Duration {
+	if attempts <= p.free {
+		return 0
+	}
+	if attempts >= p.lockoutAfter {
+		return maxLockout
+	}
+
+	// larger shifts overflow the duration, they're far past maxLockout anyway.
+	if shift := attempts - p.free - 1; shift < 32 {
+		return min(baseBackoff<<shift, maxLockout)
+	}
+	return maxLockout
+}
+
+func (p attemptPolicy) fail(ctx context.Context, client redis.Cmdable, subject string) (int64, time.Duration, error) {
+	var incr *redis.IntCmd
+	_, err := client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, p.key(FailPrefix, subject))
+		pipe.Expire(ctx, p.key(FailPrefix, subject), failWindow)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	attempts := incr.Val()
+	backoff := p.backoff(attempts)
+	if backoff > 0 {
+		err = client.Set(ctx, p.key(LockPrefix, subject), attempts, backoff).Err()
+	}
+
+	return attempts, backoff, err
+}
+
+func accountSubject(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Returns how long the account or the ip must wait before trying again, 0 if they're allowed.
+func (c *Cache) GetLoginLockout(ctx context.Context, email, ip string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var accountTTL, ipTTL *redis.DurationCmd
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		accountTTL = pipe.PTTL(ctx, accountPolicy.key(LockPrefix, accountSubject(email)))
+		ipTTL = pipe.PTTL(ctx, ipPolicy.key(LockPrefix, ip))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// PTTL returns a negative duration if the key doesn't exist
+	return max(accountTTL.Val(), ipTTL.Val(), 0), nil
+}
+
+// Records a failed password attempt for both the account and the ip.
+func (c *Cache) RegisterLoginFailure(ctx context.Context, email, ip string) (LoginFailure, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	attempts, accountBackoff, err := accountPolicy.fail(ctx, c.client, accountSubject(email))
+	if err != nil {
+		return LoginFailure{}, err
+	}
+
+	_, ipBackoff, err := ipPolicy.fail(ctx, c.client, ip)
+	if err != nil {
+		return LoginFailure{}, err
+	}
+
+	return LoginFailure{
+		Attempts:   attempts,
+		RetryAfter: max(accountBackoff, ipBackoff),
+		Locked:     attempts == accountPolicy.lockoutAfter,
+	}, nil
+}
+
+// Clears the failed attempts of an account after a successful login.
+func (c *Cache) ResetLoginFailures(ctx context.Context, email string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	subject := accountSubject(email)
+	return c.client.Del(ctx, accountPolicy.key(FailPrefix, subject), accountPolicy.key(LockPrefix, subject)).Err()
+}
+
+// Publishes an account lockout on the security events channel so other instances and services can react to it.
+func (c *Cache) PublishLockout(ctx context.Context, email, ip string, until time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	payload, err := json.Marshal(map[string]any{
+		"type":  "lockout",
+		"email": accountSubject(email),
+		"ip":    ip,
+		"until": until,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.client.Publish(ctx, SecurityEvents, payload).Err()
+}
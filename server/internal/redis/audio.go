@@ -0,0 +1,83 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	AudioDataPrefix = "temp:audio:data:"
+	AudioMetaPrefix = "temp:audio:meta:"
+)
+
+// same as SetTempImage but for voice notes.
+// temp:audio:data:{userID}: {path}, cleaned up after 10 minutes if it's not sent.
+func (c *Cache) SetTempAudio(ctx context.Context, path string, meta *utils.AudioMeta, userID bson.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	var prevCmd *redis.StatusCmd
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, AudioMetaPrefix+userID.Hex(), metaJSON, tempSafetyTTL)
+		prevCmd = pipe.SetArgs(ctx, AudioDataPrefix+userID.Hex(), path, redis.SetArgs{Get: true, TTL: tempSafetyTTL})
+		registerExpiry(ctx, pipe, expiryAudio, userID.Hex(), c.tempTTL)
+		return nil
+	})
+	if err != nil && err != redis.Nil {
+		return err
+	}
+
+	prev := prevCmd.Val()
+	if prev != "" {
+		c.EnqueueDeletion(prev)
+	}
+
+	return nil
+}
+
+func (c *Cache) GetTempAudio(ctx context.Context, userID bson.ObjectID) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	return c.client.Get(ctx, AudioDataPrefix+userID.Hex()).Result()
+}
+
+// returns the metadata of the user's temp voice note, nil if there's none.
+func (c *Cache) GetTempAudioMeta(ctx context.Context, userID bson.ObjectID) (*utils.AudioMeta, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	data, err := c.client.Get(ctx, AudioMetaPrefix+userID.Hex()).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var meta *utils.AudioMeta
+	err = json.Unmarshal(data, &meta)
+
+	return meta, err
+}
+
+func (c *Cache) DeleteAudioKeys(ctx context.Context, userID bson.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, AudioDataPrefix+userID.Hex(), AudioMetaPrefix+userID.Hex())
+		unregisterExpiry(ctx, pipe, expiryAudio, userID.Hex())
+		return nil
+	})
+
+	return err
+}
@@ -2,80 +2,128 @@ package redis
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"os"
+	"encoding/json"
+	"log/slog"
 	"time"
 
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
 	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
 	"github.com/redis/go-redis/v9"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
 const (
-	DataPrefix   = "temp:image:data:"
-	ExpirePrefix = "temp:image:expire:"
+	DataPrefix = "temp:image:data:"
+	MetaPrefix = "temp:image:meta:"
 )
 
-var Client *redis.Client
+// Cache holds the redis client and the state kept in redis: pending uploads, login attempts, sessions and the
+// storage deletion queue.
+type Cache struct {
+	client *redis.Client
+	// how long an uploaded image or voice note is kept before it's cleaned up, unless it's sent.
+	tempTTL time.Duration
+	// files are deleted from it directly, in background, when the deletion queue can't be reached.
+	store      storage.Storage
+	background *utils.Background
+}
 
-func Init() {
-	Client = redis.NewClient(&redis.Options{
-		Addr:     os.Getenv("REDIS_ADDR"),
-		Username: "default",
-		Password: os.Getenv("REDIS_PW"),
-		DB:       0,
+func New(cfg config.Redis, store storage.Storage, background *utils.Background) (*Cache, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Username: cfg.Username,
+		Password: cfg.Password,
+		DB:       cfg.DB,
 	})
+	client.AddHook(tracingHook{})
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
-	result := Client.Ping(ctx)
-	if result.Err() != nil {
-		Client.Close()
-		log.Fatalf("Redis ping failed: %v", result.Err())
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
 	}
 
-	fmt.Println("Redis connected successfully.")
+	slog.Info("Redis connected")
+
+	return &Cache{client: client, tempTTL: cfg.TempTTL, store: store, background: background}, nil
+}
+
+func (c *Cache) Ping(ctx context.Context) error {
+	return c.client.Ping(ctx).Err()
+}
+
+func (c *Cache) Close() error {
+	return c.client.Close()
 }
 
 // set a temp KVP in redis.
 // temp:image:{userID}: {path}.
-// expires in 10 minutes then cealned up.
+// expires after c.tempTTL then cealned up by the sweeper.
 // of temp:image:{userID} already exists, the old value will be cleaned up and replcaed by new one
-func SetTempImage(path string, userID bson.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// the image metadata is stored next to it in temp:image:meta:{userID}.
+func (c *Cache) SetTempImage(ctx context.Context, path string, meta *utils.ImageMeta, userID bson.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
-	err := Client.Set(ctx, ExpirePrefix+userID.Hex(), path, time.Minute*10).Err()
-	if err != nil && err != redis.Nil {
+	metaJSON, err := json.Marshal(meta)
+	if err != nil {
 		return err
 	}
-
-	prev, err := Client.SetArgs(ctx, DataPrefix+userID.Hex(), path, redis.SetArgs{
-		Get: true,
-		TTL: time.Minute * 12,
-	}).Result()
-
+	var prevCmd *redis.StatusCmd
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, MetaPrefix+userID.Hex(), metaJSON, tempSafetyTTL)
+		prevCmd = pipe.SetArgs(ctx, DataPrefix+userID.Hex(), path, redis.SetArgs{Get: true, TTL: tempSafetyTTL})
+		registerExpiry(ctx, pipe, expiryImage, userID.Hex(), c.tempTTL)
+		return nil
+	})
 	if err != nil && err != redis.Nil {
 		return err
 	}
+
+	prev := prevCmd.Val()
 	if prev != "" {
-		go utils.DeleteFile(prev)
+		c.EnqueueDeletion(prev)
 	}
 
 	return nil
 }
 
-func GetTempImage(userID bson.ObjectID) (string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+func (c *Cache) GetTempImage(ctx context.Context, userID bson.ObjectID) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
-	return Client.Get(ctx, DataPrefix+userID.Hex()).Result()
+	return c.client.Get(ctx, DataPrefix+userID.Hex()).Result()
 }
 
-func DeleteKeys(userID bson.ObjectID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// returns the metadata of the user's temp image, nil if there's none.
+func (c *Cache) GetTempImageMeta(ctx context.Context, userID bson.ObjectID) (*utils.ImageMeta, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
 	defer cancel()
 
-	return Client.Del(ctx, DataPrefix+userID.Hex(), ExpirePrefix+userID.Hex()).Err()
+	data, err := c.client.Get(ctx, MetaPrefix+userID.Hex()).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var meta *utils.ImageMeta
+	err = json.Unmarshal(data, &meta)
+
+	return meta, err
+}
+
+func (c *Cache) DeleteKeys(ctx context.Context, userID bson.ObjectID) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, DataPrefix+userID.Hex(), MetaPrefix+userID.Hex())
+		unregisterExpiry(ctx, pipe, expiryImage, userID.Hex())
+		return nil
+	})
+
+	return err
 }
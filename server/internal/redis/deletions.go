@@ -0,0 +1,258 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strconv"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/redis/go-redis/v9"
+)
+
+// Storage deletions are queued in a sorted set scored by when they're due next, so failed deletions can be
+// retried later with backoff. every member is a single storage key.
+const (
+	DeletionQueue    = "queue:deletions"
+	DeletionAttempts = "queue:deletions:attempts"
+	DeletionDead     = "queue:deletions:dead"
+	DeletionStatsKey = "queue:deletions:stats"
+
+	MaxDeletionAttempts = 8
+	deletionBaseBackoff = time.Second * 5
+	deletionMaxBackoff  = time.Hour
+)
+
+// a deletion that failed MaxDeletionAttempts times.
+type DeadDeletion struct {
+	Key      string    `json:"key"`
+	Attempts int64     `json:"attempts"`
+	Error    string    `json:"error"`
+	FailedAt time.Time `json:"failedAt"`
+}
+
+type DeletionStats struct {
+	Pending  int64 `json:"pending"`
+	Dead     int64 `json:"dead"`
+	Enqueued int64 `json:"enqueued"`
+	Deleted  int64 `json:"deleted"`
+	Retried  int64 `json:"retried"`
+	Failed   int64 `json:"failed"`
+}
+
+// Queues the deletion of files and, for images, all of their variants. if the queue can't be reached the files
+// are deleted right away instead, like they used to be.
+func (c *Cache) EnqueueDeletion(filePaths ...string) {
+	var keys []redis.Z
+	now := float64(time.Now().UnixMilli())
+	for _, filePath := range filePaths {
+		if filePath == "" {
+			continue
+		}
+		for _, key := range utils.VariantPaths(filePath) {
+			keys = append(keys, redis.Z{Score: now, Member: key})
+		}
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAddNX(ctx, DeletionQueue, keys...)
+		pipe.HIncrBy(ctx, DeletionStatsKey, "enqueued", int64(len(keys)))
+		return nil
+	})
+	if err != nil {
+		slog.Error("Couldn't queue deletion, deleting right away", "error", err)
+		for _, filePath := range filePaths {
+			c.background.Go(func() { utils.DeleteFile(c.store, filePath) })
+		}
+		return
+	}
+	metrics.DeletionsEnqueued.Add(float64(len(keys)))
+}
+
+// takes up to ARGV[3] due deletions and pushes them back to ARGV[2] (the lease), so a crashed worker's
+// deletions are picked up again and two workers never get the same ones.
+var claimDeletionsScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+for _, key in ipairs(due) do
+	redis.call("ZADD", KEYS[1], ARGV[2], key)
+end
+return due
+`)
+
+// Returns up to limit storage keys that are due for deletion, leased to the caller until the returned time.
+func (c *Cache) ClaimDeletions(ctx context.Context, limit int, lease time.Duration) ([]string, time.Time, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	now := time.Now()
+	leasedUntil := now.Add(lease).Truncate(time.Millisecond)
+	keys, err := claimDeletionsScript.Run(ctx, c.client, []string{DeletionQueue},
+		now.UnixMilli(), leasedUntil.UnixMilli(), limit).StringSlice()
+
+	return keys, leasedUntil, err
+}
+
+// the score a key was claimed with tells whose lease it is: it's only renewed if it wasn't claimed again since.
+var renewDeletionScript = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score or tonumber(score) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+
+// Extends the lease on a claimed key before it's deleted, so a batch that takes longer than the lease doesn't
+// hand its last keys to another worker mid deletion. returns false if the lease was lost, the key must be skipped.
+func (c *Cache) RenewDeletionLease(ctx context.Context, key string, leasedUntil time.Time, lease time.Duration) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	ok, err := renewDeletionScript.Run(ctx, c.client, []string{DeletionQueue},
+		key, leasedUntil.UnixMilli(), time.Now().Add(lease).UnixMilli()).Int()
+
+	return ok == 1, err
+}
+
+func (c *Cache) CompleteDeletion(ctx context.Context, key string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZRem(ctx, DeletionQueue, key)
+		pipe.HDel(ctx, DeletionAttempts, key)
+		pipe.HIncrBy(ctx, DeletionStatsKey, "deleted", 1)
+		return nil
+	})
+	if err == nil {
+		metrics.Deletions.WithLabelValues("deleted").Inc()
+	}
+
+	return err
+}
+
+// Schedules a failed deletion to be retried with exponential backoff, or moves it to the dead letters once it
+// failed MaxDeletionAttempts times. returns true if it was moved to the dead letters.
+func (c *Cache) FailDeletion(ctx context.Context, key string, deleteErr error) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	attempts, err := c.client.HIncrBy(ctx, DeletionAttempts, key, 1).Result()
+	if err != nil {
+		return false, err
+	}
+
+	if attempts >= MaxDeletionAttempts {
+		dead, err := json.Marshal(DeadDeletion{Key: key, Attempts: attempts, Error: deleteErr.Error(), FailedAt: time.Now()})
+		if err != nil {
+			return false, err
+		}
+
+		_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZRem(ctx, DeletionQueue, key)
+			pipe.HDel(ctx, DeletionAttempts, key)
+			pipe.HSet(ctx, DeletionDead, key, dead)
+			pipe.HIncrBy(ctx, DeletionStatsKey, "failed", 1)
+			return nil
+		})
+		if err == nil {
+			metrics.Deletions.WithLabelValues("failed").Inc()
+		}
+		return true, err
+	}
+
+	backoff := min(deletionBaseBackoff<<(attempts-1), deletionMaxBackoff)
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.ZAdd(ctx, DeletionQueue, redis.Z{Score: float64(time.Now().Add(backoff).UnixMilli()), Member: key})
+		pipe.HIncrBy(ctx, DeletionStatsKey, "retried", 1)
+		return nil
+	})
+	if err == nil {
+		metrics.Deletions.WithLabelValues("retried").Inc()
+	}
+
+	return false, err
+}
+
+func (c *Cache) GetDeadDeletions(ctx context.Context) ([]DeadDeletion, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	values, err := c.client.HVals(ctx, DeletionDead).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	deletions := make([]DeadDeletion, 0, len(values))
+	for _, value := range values {
+		var deletion DeadDeletion
+		if err := json.Unmarshal([]byte(value), &deletion); err != nil {
+			return nil, err
+		}
+		deletions = append(deletions, deletion)
+	}
+
+	return deletions, nil
+}
+
+// Moves every dead deletion back to the queue, e.g. after the storage outage that killed them is over.
+// returns how many were requeued.
+func (c *Cache) RequeueDeadDeletions(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	keys, err := c.client.HKeys(ctx, DeletionDead).Result()
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	now := float64(time.Now().UnixMilli())
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.ZAddNX(ctx, DeletionQueue, redis.Z{Score: now, Member: key})
+		}
+		pipe.HDel(ctx, DeletionDead, keys...)
+		return nil
+	})
+
+	return len(keys), err
+}
+
+func (c *Cache) GetDeletionStats(ctx context.Context) (DeletionStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var pending, dead *redis.IntCmd
+	var counters *redis.MapStringStringCmd
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pending = pipe.ZCard(ctx, DeletionQueue)
+		dead = pipe.HLen(ctx, DeletionDead)
+		counters = pipe.HGetAll(ctx, DeletionStatsKey)
+		return nil
+	})
+	if err != nil {
+		return DeletionStats{}, err
+	}
+
+	counter := func(name string) int64 {
+		v, _ := strconv.ParseInt(counters.Val()[name], 10, 64)
+		return v
+	}
+
+	return DeletionStats{
+		Pending:  pending.Val(),
+		Dead:     dead.Val(),
+		Enqueued: counter("enqueued"),
+		Deleted:  counter("deleted"),
+		Retried:  counter("retried"),
+		Failed:   counter("failed"),
+	}, nil
+}
@@ -0,0 +1,42 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const JobLockPrefix = "lock:job:"
+
+// deletes the lock only if it's still held by the token that took it.
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Takes lock:job:{name} for ttl so only one server instance runs a job at a time.
+// returns false if another instance holds it. unlock releases it early.
+func (c *Cache) TryLock(ctx context.Context, name string, ttl time.Duration) (unlock func(), ok bool, err error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	token := uuid.New().String()
+	ok, err = c.client.SetNX(ctx, JobLockPrefix+name, token, ttl).Result()
+	if err != nil || !ok {
+		return func() {}, false, err
+	}
+
+	// the lock is released even if ctx was canceled meanwhile.
+	unlockCtx := context.WithoutCancel(ctx)
+	unlock = func() {
+		ctx, cancel := context.WithTimeout(unlockCtx, time.Second*5)
+		defer cancel()
+		unlockScript.Run(ctx, c.client, []string{JobLockPrefix + name}, token)
+	}
+
+	return unlock, true, nil
+}
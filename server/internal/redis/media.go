@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Returns every storage path held by a pending upload: temp images and voice notes that weren't sent yet,
+// raw objects and chunks of direct and resumable uploads, and the derivatives they were processed into.
+func (c *Cache) GetPendingMediaPaths(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	var paths []string
+	for _, prefix := range []string{DataPrefix, AudioDataPrefix} {
+		iter := c.client.Scan(ctx, 0, prefix+"*", 100).Iterator()
+		for iter.Next(ctx) {
+			path, err := c.client.Get(ctx, iter.Val()).Result()
+			if err != nil && err != redis.Nil {
+				return nil, err
+			}
+			paths = append(paths, path)
+		}
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	iter := c.client.Scan(ctx, 0, UploadDataPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		values, err := c.client.HMGet(ctx, iter.Val(), "rawKey", "path").Result()
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if path, ok := v.(string); ok {
+				paths = append(paths, path)
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	iter = c.client.Scan(ctx, 0, UploadChunksPrefix+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		chunks, err := c.client.LRange(ctx, iter.Val(), 0, -1).Result()
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, chunks...)
+	}
+
+	return paths, iter.Err()
+}
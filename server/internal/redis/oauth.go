@@ -0,0 +1,76 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	OAuthStatePrefix = "oauth:state:"
+	LoginCodePrefix  = "oauth:code:"
+
+	// how long the user has to finish logging in at the provider.
+	OAuthStateTTL = time.Minute * 10
+)
+
+// what the server must remember between redirecting to the provider and the callback.
+type OAuthState struct {
+	Provider string `json:"provider"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func (c *Cache) SetOAuthState(ctx context.Context, state string, data OAuthState) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return c.client.Set(ctx, OAuthStatePrefix+state, payload, OAuthStateTTL).Err()
+}
+
+// gets and deletes the state, so a callback can't be replayed.
+func (c *Cache) GetOAuthState(ctx context.Context, state string) (OAuthState, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var data OAuthState
+	payload, err := c.client.GetDel(ctx, OAuthStatePrefix+state).Bytes()
+	if err != nil {
+		return data, err
+	}
+
+	err = json.Unmarshal(payload, &data)
+
+	return data, err
+}
+
+// creates a one-time code (valid for 1 minute) the frontend exchanges for the logged in user after the oauth callback.
+func (c *Cache) SetLoginCode(ctx context.Context, userID bson.ObjectID) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	code := uuid.New().String()
+	err := c.client.Set(ctx, LoginCodePrefix+code, userID.Hex(), time.Minute).Err()
+
+	return code, err
+}
+
+func (c *Cache) GetLoginCode(ctx context.Context, code string) (bson.ObjectID, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	hexID, err := c.client.GetDel(ctx, LoginCodePrefix+code).Result()
+	if err != nil {
+		return bson.NilObjectID, err
+	}
+
+	return bson.ObjectIDFromHex(hexID)
+}
@@ -0,0 +1,159 @@
+package redis
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/redis/go-redis/v9"
+)
+
+// Pending uploads are registered in a sorted set scored by when they expire (unix ms), the sweeper cleans up
+// the ones that are due. members are "<kind>:<id>":
+//   - image:{userID}, audio:{userID}: a temp image or voice note that wasn't sent.
+//   - upload:{uploadID}: a direct or resumable upload.
+//
+// the data keys get a long safety TTL only, they're removed by the sweeper (or when the upload is used), so an
+// upload that expired while no server was running is still cleaned up when one starts.
+const (
+	ExpiryRegistry = "temp:expiries"
+
+	expiryImage  = "image"
+	expiryAudio  = "audio"
+	expiryUpload = "upload"
+
+	tempSafetyTTL  = time.Hour * 24
+	sweepInterval  = time.Second * 5
+	sweepBatchSize = 100
+	// how long a claimed expiry is hidden from other sweepers. if the sweeper dies before finishing it,
+	// another one picks it up after the lease.
+	sweepLease = time.Minute
+)
+
+func registerExpiry(ctx context.Context, pipe redis.Cmdable, kind, id string, ttl time.Duration) {
+	pipe.ZAdd(ctx, ExpiryRegistry, redis.Z{Score: float64(time.Now().Add(ttl).UnixMilli()), Member: kind + ":" + id})
+}
+
+func unregisterExpiry(ctx context.Context, pipe redis.Cmdable, kind, id string) {
+	pipe.ZRem(ctx, ExpiryRegistry, kind+":"+id)
+}
+
+// Sweeps expired uploads every few seconds until ctx is done. safe to run on every server instance: claiming
+// an expiry leases it to one sweeper, and it's only removed from the registry once it was handled.
+func (c *Cache) StartSweeper(ctx context.Context) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Info("Redis sweeper shutting down")
+			return
+		case <-ticker.C:
+			for ctx.Err() == nil {
+				expiries, err := c.claimExpiries(ctx, sweepBatchSize, sweepLease)
+				if err != nil {
+					slog.Error("Couldn't claim expired uploads", "error", err)
+					break
+				}
+				for _, expiry := range expiries {
+					metrics.RedisSweeperLag.Observe(time.Since(time.UnixMilli(int64(expiry.Score))).Seconds())
+					c.handleExpiry(ctx, expiry.Member.(string))
+				}
+				if len(expiries) < sweepBatchSize {
+					break
+				}
+			}
+		}
+	}
+}
+
+// same as the deletion queue's claim (due members are pushed back by the lease), but returns when each member
+// was due, for the sweeper lag.
+var claimExpiriesScript = redis.NewScript(`
+local due = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "WITHSCORES", "LIMIT", 0, ARGV[3])
+for i = 1, #due, 2 do
+	redis.call("ZADD", KEYS[1], ARGV[2], due[i])
+end
+return due
+`)
+
+func (c *Cache) claimExpiries(ctx context.Context, limit int, lease time.Duration) ([]redis.Z, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	now := time.Now()
+	due, err := claimExpiriesScript.Run(ctx, c.client, []string{ExpiryRegistry},
+		now.UnixMilli(), now.Add(lease).UnixMilli(), limit).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	expiries := make([]redis.Z, 0, len(due)/2)
+	for i := 0; i+1 < len(due); i += 2 {
+		score, err := strconv.ParseFloat(due[i+1], 64)
+		if err != nil {
+			return nil, err
+		}
+		expiries = append(expiries, redis.Z{Member: due[i], Score: score})
+	}
+
+	return expiries, nil
+}
+
+// expiries are handled by scripts that first check that the member is still claimed (its score is at most
+// the lease), so an upload that was registered again after it was claimed isn't touched, and one that two
+// sweepers ended up handling is only deleted once.
+var expireTempScript = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return false
+end
+redis.call("ZREM", KEYS[1], ARGV[1])
+local path = redis.call("GET", KEYS[2])
+redis.call("DEL", KEYS[2], KEYS[3])
+return path
+`)
+
+func (c *Cache) handleExpiry(ctx context.Context, member string) {
+	kind, id, _ := strings.Cut(member, ":")
+
+	var err error
+	switch kind {
+	case expiryImage:
+		err = c.expireTemp(ctx, member, DataPrefix+id, MetaPrefix+id)
+	case expiryAudio:
+		err = c.expireTemp(ctx, member, AudioDataPrefix+id, AudioMetaPrefix+id)
+	case expiryUpload:
+		err = c.handleUploadExpiry(ctx, id)
+	default:
+		slog.Warn("Unknown expiry", "member", member)
+		err = c.client.ZRem(ctx, ExpiryRegistry, member).Err()
+	}
+	if err != nil {
+		// left in the registry, it's retried once the lease is over.
+		slog.Error("Couldn't clean up expired upload", "member", member, "error", err)
+	}
+}
+
+// removes a temp image or voice note that wasn't sent and queues its deletion.
+func (c *Cache) expireTemp(ctx context.Context, member, dataKey, metaKey string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	leaseEnd := time.Now().Add(sweepLease).UnixMilli()
+	path, err := expireTempScript.Run(ctx, c.client, []string{ExpiryRegistry, dataKey, metaKey}, member, leaseEnd).Text()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	c.EnqueueDeletion(path)
+
+	return nil
+}
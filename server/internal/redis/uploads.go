@@ -0,0 +1,237 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	UploadDataPrefix   = "temp:upload:data:"
+	UploadChunksPrefix = "temp:upload:chunks:"
+
+	UploadPending    = "pending"
+	UploadProcessing = "processing"
+	UploadDone       = "done"
+	UploadFailed     = "failed"
+)
+
+// a direct upload: a presigned url was issued for RawKey, the client uploads the raw image there,
+// confirms it, and the server converts it to the webp derivative at Path.
+// resumable uploads are sent to the server in chunks instead, Offset is how many bytes it received so far.
+type PendingUpload struct {
+	UserID      string `redis:"userId" json:"-"`
+	RawKey      string `redis:"rawKey" json:"-"`
+	ContentType string `redis:"contentType" json:"-"`
+	Size        int64  `redis:"size" json:"-"`
+	Offset      int64  `redis:"offset" json:"offset,omitempty"`
+	Resumable   bool   `redis:"resumable" json:"-"`
+	Status      string `redis:"status" json:"status"`
+	Path        string `redis:"path" json:"path,omitempty"`
+}
+
+// stores a pending direct upload.
+// the upload is registered to expire after ttl, then the raw object is cleaned up unless it was processed.
+func (c *Cache) SetPendingUpload(ctx context.Context, id string, upload PendingUpload, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, UploadDataPrefix+id, upload)
+		pipe.Expire(ctx, UploadDataPrefix+id, tempSafetyTTL)
+		registerExpiry(ctx, pipe, expiryUpload, id, ttl)
+		return nil
+	})
+
+	return err
+}
+
+func (c *Cache) GetPendingUpload(ctx context.Context, id string) (PendingUpload, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var upload PendingUpload
+	cmd := c.client.HGetAll(ctx, UploadDataPrefix+id)
+	if err := cmd.Err(); err != nil {
+		return upload, err
+	}
+	if len(cmd.Val()) == 0 {
+		return upload, redis.Nil
+	}
+
+	err := cmd.Scan(&upload)
+
+	return upload, err
+}
+
+func (c *Cache) SetUploadStatus(ctx context.Context, id, status, path string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	return c.client.HSet(ctx, UploadDataPrefix+id, "status", status, "path", path).Err()
+}
+
+// sets the status only if it's still from, so of two requests racing to move an upload on, one wins.
+var claimUploadScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "status") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "status", ARGV[2])
+return 1
+`)
+
+var ErrUploadClaimed = errors.New("Upload status has changed.")
+
+// moves an upload from one status to another. returns ErrUploadClaimed if it's not in from anymore
+// (or is gone), the caller lost the race and must leave the upload alone.
+func (c *Cache) ClaimUpload(ctx context.Context, id, from, to string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	ok, err := claimUploadScript.Run(ctx, c.client, []string{UploadDataPrefix + id}, from, to).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrUploadClaimed
+	}
+
+	return nil
+}
+
+// moves the offset of a pending upload from offset to newOffset and records the chunk stored for that range,
+// only if no other request moved it first. the status is set to ARGV[5] with it, processing for the last chunk.
+var appendChunkScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "status") ~= ARGV[4] or redis.call("HGET", KEYS[1], "offset") ~= ARGV[1] then
+	return 0
+end
+redis.call("HSET", KEYS[1], "offset", ARGV[2], "status", ARGV[5])
+redis.call("RPUSH", KEYS[2], ARGV[3])
+return 1
+`)
+
+var ErrOffsetMismatch = errors.New("Upload offset doesn't match.")
+
+// records a chunk of a resumable upload. returns ErrOffsetMismatch if the upload isn't at offset anymore
+// (e.g. a retried request raced the original one), the caller should then delete its chunk.
+// the last chunk moves the upload to processing in the same step, so it can't be deleted under the processor.
+func (c *Cache) AppendUploadChunk(ctx context.Context, id string, offset, newOffset int64, chunkKey string, last bool) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	status := UploadPending
+	if last {
+		status = UploadProcessing
+	}
+	keys := []string{UploadDataPrefix + id, UploadChunksPrefix + id}
+	ok, err := appendChunkScript.Run(ctx, c.client, keys, offset, newOffset, chunkKey, UploadPending, status).Int()
+	if err != nil {
+		return err
+	}
+	if ok == 0 {
+		return ErrOffsetMismatch
+	}
+
+	return nil
+}
+
+// returns the storage keys of the chunks of a resumable upload, in order.
+func (c *Cache) GetUploadChunks(ctx context.Context, id string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	return c.client.LRange(ctx, UploadChunksPrefix+id, 0, -1).Result()
+}
+
+// pushes back the expiry of a resumable upload, every chunk received gives the client ttl more to send the next one.
+func (c *Cache) TouchUpload(ctx context.Context, id string, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Expire(ctx, UploadDataPrefix+id, tempSafetyTTL)
+		pipe.Expire(ctx, UploadChunksPrefix+id, tempSafetyTTL)
+		registerExpiry(ctx, pipe, expiryUpload, id, ttl)
+		return nil
+	})
+
+	return err
+}
+
+// removes an upload that's still pending and returns the chunks that were stored for it.
+var deleteUploadScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "status") ~= ARGV[1] then
+	return false
+end
+local chunks = redis.call("LRANGE", KEYS[2], 0, -1)
+redis.call("DEL", KEYS[1], KEYS[2])
+redis.call("ZREM", KEYS[3], ARGV[2])
+return chunks
+`)
+
+// removes a pending upload and returns the chunks that were stored for it. returns ErrUploadClaimed if the
+// upload isn't pending anymore (e.g. its last chunk was just received).
+func (c *Cache) DeleteUpload(ctx context.Context, id string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	keys := []string{UploadDataPrefix + id, UploadChunksPrefix + id, ExpiryRegistry}
+	chunks, err := deleteUploadScript.Run(ctx, c.client, keys, UploadPending, expiryUpload+":"+id).StringSlice()
+	if err == redis.Nil {
+		return nil, ErrUploadClaimed
+	}
+
+	return chunks, err
+}
+
+// removes an expired upload if it's still claimed by the sweeper (see expireTempScript). returns its status,
+// raw key and chunks, or nothing if it's still being processed, in which case it's pushed back.
+var expireUploadScript = redis.NewScript(`
+local score = redis.call("ZSCORE", KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return {}
+end
+local status = redis.call("HGET", KEYS[2], "status")
+if status == "processing" then
+	redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+	return {}
+end
+redis.call("ZREM", KEYS[1], ARGV[1])
+if not status then
+	return {}
+end
+local result = {status, redis.call("HGET", KEYS[2], "rawKey")}
+for _, chunk in ipairs(redis.call("LRANGE", KEYS[3], 0, -1)) do
+	table.insert(result, chunk)
+end
+redis.call("DEL", KEYS[2], KEYS[3])
+return result
+`)
+
+// deletes the raw object (and chunks, for resumable uploads) of an expired upload, unless it was already
+// processed (the processor deletes it itself), then removes the upload.
+// uploads that are still being processed are checked again later.
+func (c *Cache) handleUploadExpiry(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	now := time.Now()
+	result, err := expireUploadScript.Run(ctx, c.client,
+		[]string{ExpiryRegistry, UploadDataPrefix + id, UploadChunksPrefix + id},
+		expiryUpload+":"+id, now.Add(sweepLease).UnixMilli(), now.Add(sweepLease*2).UnixMilli()).StringSlice()
+	if err != nil {
+		return err
+	}
+	if len(result) < 2 {
+		return nil
+	}
+
+	if status := result[0]; status == UploadPending || status == UploadFailed {
+		// chunks first, the raw key may be empty.
+		c.EnqueueDeletion(append(result[2:], result[1])...)
+	}
+
+	return nil
+}
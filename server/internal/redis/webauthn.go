@@ -0,0 +1,45 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+)
+
+const WebAuthnSessionPrefix = "webauthn:session:"
+
+// stores a webauthn ceremony session (challenge, user handle, allowed credentials) for 5 minutes.
+// returns the session id the client must send back when finishing the ceremony.
+func (c *Cache) SetWebAuthnSession(ctx context.Context, session *webauthn.SessionData) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", err
+	}
+
+	id := uuid.New().String()
+	err = c.client.Set(ctx, WebAuthnSessionPrefix+id, data, time.Minute*5).Err()
+
+	return id, err
+}
+
+// gets and deletes a webauthn session, so every challenge can be used only once.
+func (c *Cache) GetWebAuthnSession(ctx context.Context, id string) (webauthn.SessionData, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+
+	var session webauthn.SessionData
+	data, err := c.client.GetDel(ctx, WebAuthnSessionPrefix+id).Bytes()
+	if err != nil {
+		return session, err
+	}
+
+	err = json.Unmarshal(data, &session)
+
+	return session, err
+}
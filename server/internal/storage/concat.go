@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+)
+
+var ErrSizeMismatch = errors.New("Concatenated size doesn't match.")
+
+// Stores the objects at srcs, in order, as a single object at dst. the result is buffered in memory so it should
+// only be used for objects of bounded size. the sources are left in place.
+func Concat(ctx context.Context, store Storage, dst string, srcs []string, size int64, contentType string) error {
+	var buf bytes.Buffer
+	buf.Grow(int(size))
+
+	for _, src := range srcs {
+		body, _, err := store.Get(ctx, src)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(&buf, io.LimitReader(body, size-int64(buf.Len())+1))
+		body.Close()
+		if err != nil {
+			return err
+		}
+		if int64(buf.Len()) > size {
+			return ErrSizeMismatch
+		}
+	}
+	if int64(buf.Len()) != size {
+		return ErrSizeMismatch
+	}
+
+	return store.Put(ctx, dst, bytes.NewReader(buf.Bytes()), size, contentType)
+}
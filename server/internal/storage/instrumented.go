@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Records the latency of every operation of a driver (see metrics.StorageOperations) and traces it.
+type instrumented struct {
+	Storage
+}
+
+// Starts the span of an operation, the returned func records its latency and ends the span. missing keys
+// aren't counted as errors.
+func observe(ctx context.Context, operation, key string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.StartChild(ctx, "storage."+operation, trace.WithAttributes(attribute.String("storage.key", key)))
+
+	return ctx, func(err error) {
+		if errors.Is(err, ErrNotFound) {
+			err = nil
+		}
+		metrics.StorageOperations.WithLabelValues(operation, metrics.Result(err)).Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}
+}
+
+func (s instrumented) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	ctx, done := observe(ctx, "put", key)
+	err := s.Storage.Put(ctx, key, body, size, contentType)
+	done(err)
+	return err
+}
+
+func (s instrumented) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	ctx, done := observe(ctx, "get", key)
+	body, object, err := s.Storage.Get(ctx, key)
+	done(err)
+	return body, object, err
+}
+
+func (s instrumented) Delete(ctx context.Context, key string) error {
+	ctx, done := observe(ctx, "delete", key)
+	err := s.Storage.Delete(ctx, key)
+	done(err)
+	return err
+}
+
+func (s instrumented) Stat(ctx context.Context, key string) (Object, error) {
+	ctx, done := observe(ctx, "stat", key)
+	object, err := s.Storage.Stat(ctx, key)
+	done(err)
+	return object, err
+}
+
+func (s instrumented) List(ctx context.Context, prefix string) ([]Object, error) {
+	ctx, done := observe(ctx, "list", prefix)
+	objects, err := s.Storage.List(ctx, prefix)
+	done(err)
+	return objects, err
+}
+
+// Returns the driver behind store as T, for the features only some drivers have (e.g. RangeGetter).
+func As[T any](store Storage) (T, bool) {
+	if s, ok := store.(instrumented); ok {
+		store = s.Storage
+	}
+
+	t, ok := store.(T)
+	return t, ok
+}
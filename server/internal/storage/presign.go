@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var ErrPresignUnsupported = errors.New("Direct uploads aren't supported by the storage driver.")
+
+type PresignedUpload struct {
+	URL    string `json:"url"`
+	Method string `json:"method"`
+	// headers the client must send exactly as given, the signature covers them.
+	Headers   map[string]string `json:"headers"`
+	ExpiresAt time.Time         `json:"expiresAt"`
+}
+
+// Presigner is implemented by drivers that can let clients upload directly, without going through the server.
+type Presigner interface {
+	PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (PresignedUpload, error)
+}
+
+// Presigns a PUT of exactly size bytes of contentType to key, if the driver of store supports it.
+func PresignPut(ctx context.Context, store Storage, key, contentType string, size int64, ttl time.Duration) (PresignedUpload, error) {
+	presigner, ok := As[Presigner](store)
+	if !ok {
+		return PresignedUpload{}, ErrPresignUnsupported
+	}
+
+	return presigner.PresignPut(ctx, key, contentType, size, ttl)
+}
@@ -0,0 +1,12 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// RangeGetter is implemented by drivers whose Get bodies can't seek, so byte ranges are fetched from the driver.
+type RangeGetter interface {
+	// returns length bytes of key starting at offset. the returned Object describes the whole object.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error)
+}
@@ -0,0 +1,153 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+type S3 struct {
+	client *s3.Client
+	bucket string
+}
+
+func NewS3(ctx context.Context, bucket string) (*S3, error) {
+	if bucket == "" {
+		return nil, errors.New("AWS_BUCKET_NAME is a required env variable for the s3 storage driver.")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3{client: s3.NewFromConfig(cfg), bucket: bucket}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        &s.bucket,
+		Key:           aws.String(key),
+		Body:          body,
+		ContentLength: aws.Int64(size),
+		ContentType:   aws.String(contentType),
+	})
+
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, Object, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: aws.String(key)})
+	if err != nil {
+		return nil, Object{}, mapS3Error(err)
+	}
+
+	return output.Body, Object{
+		Key:         key,
+		Size:        aws.ToInt64(output.ContentLength),
+		ContentType: aws.ToString(output.ContentType),
+		ModTime:     aws.ToTime(output.LastModified),
+	}, nil
+}
+
+func (s *S3) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, Object, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		return nil, Object{}, mapS3Error(err)
+	}
+
+	// Content-Range is "bytes start-end/size".
+	size := aws.ToInt64(output.ContentLength)
+	if contentRange := aws.ToString(output.ContentRange); contentRange != "" {
+		if _, total, ok := strings.Cut(contentRange, "/"); ok {
+			size, _ = strconv.ParseInt(total, 10, 64)
+		}
+	}
+
+	return output.Body, Object{
+		Key:         key,
+		Size:        size,
+		ContentType: aws.ToString(output.ContentType),
+		ModTime:     aws.ToTime(output.LastModified),
+	}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: aws.String(key)})
+	return err
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Object, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: &s.bucket, Key: aws.String(key)})
+	if err != nil {
+		return Object{}, mapS3Error(err)
+	}
+
+	return Object{
+		Key:         key,
+		Size:        aws.ToInt64(output.ContentLength),
+		ContentType: aws.ToString(output.ContentType),
+		ModTime:     aws.ToTime(output.LastModified),
+	}, nil
+}
+
+func (s *S3) List(ctx context.Context, prefix string) ([]Object, error) {
+	objects := []Object{}
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{Bucket: &s.bucket, Prefix: aws.String(prefix)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, obj := range page.Contents {
+			objects = append(objects, Object{Key: aws.ToString(obj.Key), Size: aws.ToInt64(obj.Size), ModTime: aws.ToTime(obj.LastModified)})
+		}
+	}
+
+	return objects, nil
+}
+
+func mapS3Error(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && (apiErr.ErrorCode() == "NoSuchKey" || apiErr.ErrorCode() == "NotFound") {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+func (s *S3) PresignPut(ctx context.Context, key, contentType string, size int64, ttl time.Duration) (PresignedUpload, error) {
+	presigner := s3.NewPresignClient(s.client)
+	req, err := presigner.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket:        &s.bucket,
+		Key:           aws.String(key),
+		ContentType:   aws.String(contentType),
+		ContentLength: aws.Int64(size),
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return PresignedUpload{}, err
+	}
+
+	headers := make(map[string]string)
+	for name := range req.SignedHeader {
+		if name != "Host" {
+			headers[name] = req.SignedHeader.Get(name)
+		}
+	}
+
+	return PresignedUpload{URL: req.URL, Method: req.Method, Headers: headers, ExpiresAt: time.Now().Add(ttl)}, nil
+}
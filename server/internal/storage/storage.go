@@ -0,0 +1,68 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+)
+
+var ErrNotFound = errors.New("File not found.")
+
+type Object struct {
+	Key         string    `json:"key"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"contentType"`
+	ModTime     time.Time `json:"modTime"`
+}
+
+// Storage is an object store addressed by slash separated keys (e.g. "chatify-3/<uuid>image.png").
+// Get and Stat return ErrNotFound for missing keys, Delete of a missing key is not an error.
+type Storage interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadCloser, Object, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (Object, error)
+	List(ctx context.Context, prefix string) ([]Object, error)
+}
+
+// Returns the storage driver selected by cfg.Driver ("s3", "local" or "memory"), instrumented (see
+// metrics.StorageOperations). s3 stores files in cfg.Bucket, local under cfg.Dir.
+func New(cfg config.Storage) (Storage, error) {
+	var store Storage
+	var err error
+	switch cfg.Driver {
+	case config.DriverS3:
+		store, err = NewS3(context.Background(), cfg.Bucket)
+	case config.DriverLocal:
+		store, err = NewLocal(cfg.Dir)
+	case config.DriverMemory:
+		store = NewMemory()
+	default:
+		err = fmt.Errorf("Unknown storage driver %q.", cfg.Driver)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return instrumented{store}, nil
+}
+
+// Checks that store is reachable by looking up a key that doesn't exist.
+func Ping(ctx context.Context, store Storage) error {
+	_, err := store.Stat(ctx, "chatify-3/.ping")
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+
+	return err
+}
+
+// Returns true if the files of store aren't publicly reachable and must be served by the server.
+func ServedByServer(store Storage) bool {
+	_, isS3 := As[*S3](store)
+	return !isS3
+}
@@ -0,0 +1,184 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// every driver has to pass the same suite, s3 is left out since it needs a bucket.
+func TestStorage(t *testing.T) {
+	implementations := []struct {
+		name string
+		new  func(t *testing.T) Storage
+	}{
+		{"memory", func(t *testing.T) Storage { return NewMemory() }},
+		{"local", func(t *testing.T) Storage {
+			store, err := NewLocal(filepath.Join(t.TempDir(), "files"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+	}
+
+	for _, impl := range implementations {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Run("objects", func(t *testing.T) { testObjects(t, impl.new(t)) })
+			t.Run("ranges", func(t *testing.T) { testRanges(t, impl.new(t)) })
+			t.Run("list", func(t *testing.T) { testList(t, impl.new(t)) })
+		})
+	}
+}
+
+const testContent = "hello from chatify"
+
+func put(t *testing.T, store Storage, key, content string) {
+	t.Helper()
+	if err := store.Put(context.Background(), key, strings.NewReader(content), int64(len(content)), "text/plain; charset=utf-8"); err != nil {
+		t.Fatalf("Put %q: %v", key, err)
+	}
+}
+
+func testObjects(t *testing.T, store Storage) {
+	ctx := context.Background()
+	key := "chatify-3/dir/file.txt"
+
+	if _, err := store.Stat(ctx, key); err != ErrNotFound {
+		t.Fatalf("Stat of a missing key = %v", err)
+	}
+	if _, _, err := store.Get(ctx, key); err != ErrNotFound {
+		t.Fatalf("Get of a missing key = %v", err)
+	}
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete of a missing key = %v", err)
+	}
+	if err := Ping(ctx, store); err != nil {
+		t.Fatalf("Ping = %v", err)
+	}
+
+	put(t, store, key, testContent)
+	obj, err := store.Stat(ctx, key)
+	if err != nil || obj.Key != key || obj.Size != int64(len(testContent)) || obj.ContentType != "text/plain; charset=utf-8" || obj.ModTime.IsZero() {
+		t.Fatalf("Stat = %+v, %v", obj, err)
+	}
+
+	body, obj, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != testContent || obj.Size != int64(len(testContent)) {
+		t.Fatalf("Get = %q, %+v, %v", data, obj, err)
+	}
+
+	// a second Put replaces the object.
+	put(t, store, key, "replaced")
+	if obj, err := store.Stat(ctx, key); err != nil || obj.Size != int64(len("replaced")) {
+		t.Fatalf("Stat after replacing = %+v, %v", obj, err)
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stat(ctx, key); err != ErrNotFound {
+		t.Fatalf("Stat after Delete = %v", err)
+	}
+}
+
+// drivers that aren't a RangeGetter must return bodies that can seek, http.ServeContent serves ranges from them.
+func testRanges(t *testing.T, store Storage) {
+	if _, ok := store.(RangeGetter); ok {
+		return
+	}
+
+	key := "chatify-3/audio.webm"
+	put(t, store, key, testContent)
+
+	body, _, err := store.Get(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer body.Close()
+	seeker, ok := body.(io.ReadSeeker)
+	if !ok {
+		t.Fatalf("Get body %T can't seek", body)
+	}
+
+	if size, err := seeker.Seek(0, io.SeekEnd); err != nil || size != int64(len(testContent)) {
+		t.Fatalf("Seek to the end = %d, %v", size, err)
+	}
+	if _, err := seeker.Seek(6, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	part := make([]byte, 4)
+	if _, err := io.ReadFull(seeker, part); err != nil || string(part) != "from" {
+		t.Fatalf("range 6-9 = %q, %v", part, err)
+	}
+}
+
+func testList(t *testing.T, store Storage) {
+	for _, key := range []string{"chatify-3/b.txt", "chatify-3/a.txt", "chatify-3/nested/c.txt", "other/d.txt"} {
+		put(t, store, key, testContent)
+	}
+
+	objects, err := store.List(context.Background(), "chatify-3/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	if strings.Join(keys, ",") != "chatify-3/a.txt,chatify-3/b.txt,chatify-3/nested/c.txt" {
+		t.Fatalf("List = %v", keys)
+	}
+
+	if objects, err := store.List(context.Background(), "missing/"); err != nil || objects == nil || len(objects) != 0 {
+		t.Fatalf("List of an empty prefix = %v, %v", objects, err)
+	}
+}
+
+func TestLocalRejectsKeysOutsideTheRoot(t *testing.T) {
+	ctx := context.Background()
+	parent := t.TempDir()
+	store, err := NewLocal(filepath.Join(parent, "files"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "secret"), []byte("secret"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"", "../secret", "../files2/x", "/etc/passwd", "a/../../secret", "a/../b", "./a", "a//b", "a/", "chatify-3/.."} {
+		if err := store.Put(ctx, key, bytes.NewReader(nil), 0, ""); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Put %q = %v", key, err)
+		}
+		if _, _, err := store.Get(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Get %q = %v", key, err)
+		}
+		if _, err := store.Stat(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Stat %q = %v", key, err)
+		}
+		if err := store.Delete(ctx, key); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("Delete %q = %v", key, err)
+		}
+	}
+
+	entries, err := os.ReadDir(parent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("files written outside the root: %v", entries)
+	}
+	if data, _ := os.ReadFile(filepath.Join(parent, "secret")); string(data) != "secret" {
+		t.Fatal("a file outside the root was changed")
+	}
+}
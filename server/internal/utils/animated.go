@@ -0,0 +1,301 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+
+	"github.com/chai2010/webp"
+)
+
+var ErrTooManyFrames = errors.New("Animated image has too many frames.")
+
+// the sum of the area of all frames. animations are checked from their headers before anything is decoded.
+const MaxAnimationPixels int64 = 150_000_000
+
+// Frame information read from the container of a gif or webp without decoding any frame.
+type animation struct {
+	frames   int
+	pixels   int64
+	duration int // milliseconds
+	// webp only: the payload of the first ANMF chunk.
+	firstFrame []byte
+}
+
+// Reads the frame count of a gif or webp and checks it against maxFrames and MaxAnimationPixels.
+// returns nil for still images (a single frame, or any other format).
+func inspectAnimation(data []byte, format string, maxFrames int) (*animation, error) {
+	var anim *animation
+	var err error
+
+	switch format {
+	case "gif":
+		anim, err = scanGIF(data, maxFrames)
+	case "webp":
+		anim, err = scanWebP(data)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if anim.frames <= 1 {
+		return nil, nil
+	}
+	if anim.frames > maxFrames {
+		return nil, ErrTooManyFrames
+	}
+	if anim.pixels > MaxAnimationPixels {
+		return nil, ErrImageDimensions
+	}
+
+	return anim, nil
+}
+
+func scanGIF(data []byte, maxFrames int) (*animation, error) {
+	if len(data) < 13 {
+		return nil, ErrCorruptImage
+	}
+
+	anim := &animation{}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1)
+	}
+
+	var err error
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension
+			if pos+2 < len(data) && data[pos+1] == 0xF9 && data[pos+2] >= 4 && pos+6 < len(data) {
+				// graphic control extension, delay in 1/100s
+				anim.duration += int(binary.LittleEndian.Uint16(data[pos+4:])) * 10
+			}
+			pos, err = skipGIFSubBlocks(data, pos+2)
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return nil, ErrCorruptImage
+			}
+			width := binary.LittleEndian.Uint16(data[pos+5:])
+			height := binary.LittleEndian.Uint16(data[pos+7:])
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			// skips the LZW minimum code size
+			pos, err = skipGIFSubBlocks(data, pos+1)
+
+			anim.frames++
+			anim.pixels += int64(width) * int64(height)
+			if anim.frames > maxFrames {
+				return anim, nil
+			}
+		case 0x3B: // trailer
+			return anim, nil
+		default:
+			return nil, ErrCorruptImage
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return anim, nil
+}
+
+func skipGIFSubBlocks(data []byte, pos int) (int, error) {
+	for {
+		if pos >= len(data) {
+			return 0, ErrCorruptImage
+		}
+		size := int(data[pos])
+		pos++
+		if size == 0 {
+			return pos, nil
+		}
+		pos += size
+	}
+}
+
+type webpChunk struct {
+	fourCC  string
+	payload []byte
+}
+
+// Splits a RIFF WEBP container into its chunks.
+func webpChunks(data []byte) ([]webpChunk, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, ErrCorruptImage
+	}
+
+	var chunks []webpChunk
+	pos := 12
+	for pos+8 <= len(data) {
+		size := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		start := pos + 8
+		if size < 0 || start+size > len(data) {
+			return nil, ErrCorruptImage
+		}
+		chunks = append(chunks, webpChunk{string(data[pos : pos+4]), data[start : start+size]})
+		pos = start + size + size%2
+	}
+
+	return chunks, nil
+}
+
+func scanWebP(data []byte) (*animation, error) {
+	chunks, err := webpChunks(data)
+	if err != nil {
+		return nil, err
+	}
+
+	anim := &animation{}
+	for _, chunk := range chunks {
+		if chunk.fourCC != "ANMF" {
+			continue
+		}
+		if len(chunk.payload) < 16 {
+			return nil, ErrCorruptImage
+		}
+
+		width := uint24(chunk.payload[6:]) + 1
+		height := uint24(chunk.payload[9:]) + 1
+		if anim.frames == 0 {
+			anim.firstFrame = chunk.payload
+		}
+		anim.frames++
+		anim.pixels += int64(width) * int64(height)
+		anim.duration += uint24(chunk.payload[12:])
+	}
+
+	return anim, nil
+}
+
+func uint24(b []byte) int {
+	return int(b[0]) | int(b[1])<<8 | int(b[2])<<16
+}
+
+func putUint24(b []byte, v int) {
+	b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16)
+}
+
+func appendWebPChunk(buf []byte, fourCC string, payload []byte) []byte {
+	buf = append(buf, fourCC...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = append(buf, payload...)
+	if len(payload)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func riffWebP(body []byte) []byte {
+	buf := make([]byte, 0, len(body)+12)
+	buf = append(buf, "RIFF"...)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(body)+4))
+	buf = append(buf, "WEBP"...)
+	return append(buf, body...)
+}
+
+// Decodes the first frame of an animation and draws it on a transparent canvas of the animation's size.
+func decodePoster(data []byte, format string, anim *animation) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrCorruptImage
+	}
+
+	var frame image.Image
+	offset := image.Point{}
+	switch format {
+	case "gif":
+		frame, err = gif.Decode(bytes.NewReader(data))
+	case "webp":
+		offset = image.Pt(uint24(anim.firstFrame[0:])*2, uint24(anim.firstFrame[3:])*2)
+		frame, err = decodeWebPFrame(anim.firstFrame)
+	}
+	if err != nil {
+		return nil, ErrCorruptImage
+	}
+
+	poster := image.NewNRGBA(image.Rect(0, 0, config.Width, config.Height))
+	bounds := frame.Bounds()
+	draw.Draw(poster, bounds.Add(offset), frame, bounds.Min, draw.Over)
+
+	return poster, nil
+}
+
+// Wraps the bitstream of an ANMF chunk in a still webp container and decodes it.
+func decodeWebPFrame(anmf []byte) (image.Image, error) {
+	frameChunks, err := webpChunks(riffWebP(anmf[16:]))
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	for _, chunk := range frameChunks {
+		if chunk.fourCC == "ALPH" {
+			// alpha needs the extended format header.
+			vp8x := make([]byte, 10)
+			vp8x[0] = 0x10
+			putUint24(vp8x[4:], uint24(anmf[6:]))
+			putUint24(vp8x[7:], uint24(anmf[9:]))
+			body = appendWebPChunk(body, "VP8X", vp8x)
+			break
+		}
+	}
+	for _, chunk := range frameChunks {
+		switch chunk.fourCC {
+		case "ALPH", "VP8 ", "VP8L":
+			body = appendWebPChunk(body, chunk.fourCC, chunk.payload)
+		}
+	}
+
+	return webp.Decode(bytes.NewReader(riffWebP(body)))
+}
+
+// Returns the animation that gets stored: gifs are re-encoded (which drops comments and application data)
+// and webps lose their EXIF and XMP chunks.
+func sanitizeAnimation(data []byte, format string) ([]byte, error) {
+	switch format {
+	case "gif":
+		g, err := gif.DecodeAll(bytes.NewReader(data))
+		if err != nil {
+			return nil, ErrCorruptImage
+		}
+
+		var buf bytes.Buffer
+		if err = gif.EncodeAll(&buf, g); err != nil {
+			return nil, ErrEncodeImage
+		}
+		return buf.Bytes(), nil
+	case "webp":
+		chunks, err := webpChunks(data)
+		if err != nil {
+			return nil, err
+		}
+
+		var body []byte
+		for _, chunk := range chunks {
+			switch chunk.fourCC {
+			case "EXIF", "XMP ":
+				continue
+			case "VP8X":
+				if len(chunk.payload) < 10 {
+					return nil, ErrCorruptImage
+				}
+				vp8x := bytes.Clone(chunk.payload)
+				vp8x[0] &^= 0x08 | 0x04 // EXIF and XMP flags
+				body = appendWebPChunk(body, chunk.fourCC, vp8x)
+			default:
+				body = appendWebPChunk(body, chunk.fourCC, chunk.payload)
+			}
+		}
+		return riffWebP(body), nil
+	default:
+		return nil, ErrUnsupportedImage
+	}
+}
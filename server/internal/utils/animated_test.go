@@ -0,0 +1,259 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"testing"
+
+	"github.com/chai2010/webp"
+)
+
+// a gif of frames frames of width x height, each shown for 100ms.
+func animatedGIF(t testing.TB, frames, width, height int) []byte {
+	t.Helper()
+
+	g := &gif.GIF{Config: image.Config{ColorModel: color.Palette(palette.Plan9), Width: width, Height: height}}
+	for i := range frames {
+		frame := image.NewPaletted(image.Rect(0, 0, width, height), palette.Plan9)
+		for x := range width {
+			frame.SetColorIndex(x, 0, uint8(i))
+		}
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// the chunks of a still webp, without its RIFF header.
+func webpFrameChunks(t testing.TB, img image.Image, lossless bool) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := webp.Encode(&buf, img, &webp.Options{Lossless: lossless, Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	body := buf.Bytes()[12:]
+	// a lossy frame with alpha comes with its own VP8X, ANMF frames can't have one.
+	if string(body[:4]) == "VP8X" {
+		body = body[8+10:]
+	}
+	return body
+}
+
+// an animated webp of frames placed at offset (even, like the format requires) on a width x height canvas,
+// encoded as lossless or lossy with an ALPH chunk.
+// extra chunks (EXIF, XMP) are appended after the frames.
+func animatedWebP(t testing.TB, width, height int, offset image.Point, lossless bool, frames []image.Image, extra ...webpChunk) []byte {
+	t.Helper()
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = 0x02 | 0x10 // animation and alpha
+	for _, chunk := range extra {
+		switch chunk.fourCC {
+		case "EXIF":
+			vp8x[0] |= 0x08
+		case "XMP ":
+			vp8x[0] |= 0x04
+		}
+	}
+	putUint24(vp8x[4:], width-1)
+	putUint24(vp8x[7:], height-1)
+	body := appendWebPChunk(nil, "VP8X", vp8x)
+	body = appendWebPChunk(body, "ANIM", []byte{0, 0, 0, 0, 0, 0}) // transparent background, loop forever
+
+	for _, frame := range frames {
+		anmf := make([]byte, 16)
+		putUint24(anmf[0:], offset.X/2)
+		putUint24(anmf[3:], offset.Y/2)
+		putUint24(anmf[6:], frame.Bounds().Dx()-1)
+		putUint24(anmf[9:], frame.Bounds().Dy()-1)
+		putUint24(anmf[12:], 100)
+		body = appendWebPChunk(body, "ANMF", append(anmf, webpFrameChunks(t, frame, lossless)...))
+	}
+	for _, chunk := range extra {
+		body = appendWebPChunk(body, chunk.fourCC, chunk.payload)
+	}
+
+	return riffWebP(body)
+}
+
+// a half transparent frame: the left half red, the right half clear.
+func webpTestFrame(width, height int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := range width / 2 {
+		for y := range height {
+			img.Set(x, y, color.NRGBA{R: 255, A: 255})
+		}
+	}
+	return img
+}
+
+func TestInspectAnimation(t *testing.T) {
+	frames := []image.Image{webpTestFrame(16, 8), webpTestFrame(16, 8), webpTestFrame(16, 8)}
+	gifAnimation := animatedGIF(t, 3, 10, 6)
+	webpAnimation := animatedWebP(t, 32, 32, image.Pt(4, 2), true, frames)
+
+	// a gif frame descriptor claiming the largest size a gif can hold.
+	hugeGIF := animatedGIF(t, 3, 4, 4)
+	for pos := 0; pos+9 < len(hugeGIF); pos++ {
+		if hugeGIF[pos] == 0x2C && hugeGIF[pos+5] == 4 && hugeGIF[pos+7] == 4 {
+			binary.LittleEndian.PutUint16(hugeGIF[pos+5:], 0xFFFF)
+			binary.LittleEndian.PutUint16(hugeGIF[pos+7:], 0xFFFF)
+		}
+	}
+
+	badANMF := riffWebP(append(appendWebPChunk(nil, "ANMF", make([]byte, 20)), appendWebPChunk(nil, "ANMF", make([]byte, 8))...))
+
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		frames   int
+		duration int
+		pixels   int64
+		still    bool
+		err      error
+	}{
+		{name: "animated gif", data: animatedGIF(t, 4, 10, 6), format: "gif", frames: 4, duration: 400, pixels: 4 * 60},
+		{name: "animated webp", data: webpAnimation, format: "webp", frames: 3, duration: 300, pixels: 3 * 128},
+		{name: "still gif", data: animatedGIF(t, 1, 10, 6), format: "gif", still: true},
+		{name: "still webp", data: encodeImage(t, "webp", testImage(8, 8)), format: "webp", still: true},
+		{name: "png", data: encodeImage(t, "png", testImage(8, 8)), format: "png", still: true},
+
+		{name: "gif with too many frames", data: animatedGIF(t, 6, 2, 2), format: "gif", err: ErrTooManyFrames},
+		{name: "webp with too many frames", data: animatedWebP(t, 16, 8, image.Point{}, true, append(frames, frames...)), format: "webp", err: ErrTooManyFrames},
+		{name: "gif with huge frames", data: hugeGIF, format: "gif", err: ErrImageDimensions},
+		{name: "gif truncated mid frame", data: gifAnimation[:len(gifAnimation)-10], format: "gif", err: ErrCorruptImage},
+		{name: "gif with an unknown block", data: append(bytes.Clone(gifAnimation[:13+3*256]), 0x99), format: "gif", err: ErrCorruptImage},
+		{name: "webp truncated mid chunk", data: webpAnimation[:len(webpAnimation)-10], format: "webp", err: ErrCorruptImage},
+		{name: "webp with a short ANMF", data: badANMF, format: "webp", err: ErrCorruptImage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			anim, err := inspectAnimation(test.data, test.format, 5)
+			if err != test.err {
+				t.Fatalf("inspectAnimation error = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if test.still {
+				if anim != nil {
+					t.Fatalf("still image inspected as %+v", anim)
+				}
+				return
+			}
+			if anim == nil || anim.frames != test.frames || anim.duration != test.duration || anim.pixels != test.pixels {
+				t.Fatalf("inspectAnimation = %+v", anim)
+			}
+		})
+	}
+}
+
+func TestDecodePoster(t *testing.T) {
+	frames := []image.Image{webpTestFrame(16, 8), webpTestFrame(16, 8)}
+	for name, data := range map[string][]byte{
+		"lossless": animatedWebP(t, 32, 32, image.Pt(4, 2), true, frames),
+		"lossy":    animatedWebP(t, 32, 32, image.Pt(4, 2), false, frames),
+	} {
+		anim, err := inspectAnimation(data, "webp", 5)
+		if err != nil || anim == nil {
+			t.Fatalf("%s: inspectAnimation = %+v, %v", name, anim, err)
+		}
+		poster, err := decodePoster(data, "webp", anim)
+		if err != nil {
+			t.Fatalf("%s: decodePoster error = %v", name, err)
+		}
+
+		// the frame is drawn at its offset on the canvas, the rest stays transparent.
+		if poster.Bounds() != image.Rect(0, 0, 32, 32) {
+			t.Fatalf("%s: poster bounds = %v", name, poster.Bounds())
+		}
+		for _, pixel := range []struct {
+			x, y   int
+			opaque bool
+		}{{4, 2, true}, {11, 9, true}, {12, 2, false}, {0, 0, false}, {4, 10, false}} {
+			if _, _, _, a := poster.At(pixel.x, pixel.y).RGBA(); (a > 0xF000) != pixel.opaque {
+				t.Errorf("%s: alpha at %d,%d = %d", name, pixel.x, pixel.y, a)
+			}
+		}
+	}
+
+	gifFile := animatedGIF(t, 3, 10, 6)
+	anim, _ := inspectAnimation(gifFile, "gif", 5)
+	if poster, err := decodePoster(gifFile, "gif", anim); err != nil || poster.Bounds() != image.Rect(0, 0, 10, 6) {
+		t.Fatalf("gif decodePoster = %v, %v", poster, err)
+	}
+}
+
+func TestSanitizeAnimation(t *testing.T) {
+	frames := []image.Image{webpTestFrame(16, 8), webpTestFrame(16, 8)}
+	exif := webpChunk{"EXIF", []byte("Exif\x00\x00GPS 48.8584 N 2.2945 E")}
+	xmp := webpChunk{"XMP ", []byte("<x:xmpmeta/>")}
+
+	sanitized, err := sanitizeAnimation(animatedWebP(t, 32, 32, image.Point{}, true, frames, exif, xmp), "webp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(sanitized, exif.payload) || bytes.Contains(sanitized, xmp.payload) {
+		t.Fatal("metadata wasn't stripped")
+	}
+	if !bytes.Equal(sanitized, animatedWebP(t, 32, 32, image.Point{}, true, frames)) {
+		t.Fatal("sanitizing changed more than the metadata")
+	}
+
+	// gifs are re-encoded, which drops their comments.
+	gifFile := animatedGIF(t, 3, 10, 6)
+	comment := append([]byte{0x21, 0xFE, 6}, "secret"...)
+	withComment := append(append(bytes.Clone(gifFile[:len(gifFile)-1]), append(comment, 0)...), 0x3B)
+	sanitized, err = sanitizeAnimation(withComment, "gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if anim, _ := inspectAnimation(sanitized, "gif", 5); bytes.Contains(sanitized, []byte("secret")) || anim == nil || anim.frames != 3 {
+		t.Fatalf("sanitized gif: %+v", anim)
+	}
+
+	if _, err := sanitizeAnimation(gifFile[:len(gifFile)-10], "gif"); err != ErrCorruptImage {
+		t.Fatalf("sanitizing a truncated gif: %v", err)
+	}
+}
+
+func FuzzInspectImage(f *testing.F) {
+	f.Add(animatedGIF(f, 3, 10, 6))
+	f.Add(animatedWebP(f, 32, 32, image.Pt(4, 2), false, []image.Image{webpTestFrame(16, 8), webpTestFrame(16, 8)}))
+	f.Add(encodeImage(f, "png", testImage(8, 8)))
+
+	f.Fuzz(func(t *testing.T, file []byte) {
+		data, format, err := ReadImage(bytes.NewReader(file), 1<<20, testMaxSide, testMaxPixels)
+		if err != nil {
+			return
+		}
+		anim, err := inspectAnimation(data, format, 5)
+		if err != nil || anim == nil {
+			return
+		}
+		if anim.frames < 2 || anim.frames > 5 || anim.pixels > MaxAnimationPixels {
+			t.Fatalf("inspectAnimation = %+v", anim)
+		}
+
+		config, _, _ := image.DecodeConfig(bytes.NewReader(data))
+		if poster, err := decodePoster(data, format, anim); err == nil && poster.Bounds() != image.Rect(0, 0, config.Width, config.Height) {
+			t.Fatalf("poster bounds = %v, want %dx%d", poster.Bounds(), config.Width, config.Height)
+		}
+		if sanitized, err := sanitizeAnimation(data, format); err == nil {
+			if sniffed, ok := SniffImage(sanitized); !ok || sniffed != format {
+				t.Fatalf("sanitized %s sniffed as %q", format, sniffed)
+			}
+		}
+	})
+}
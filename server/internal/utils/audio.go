@@ -0,0 +1,442 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnsupportedAudio = errors.New("Unsupported file type, only opus/ogg and webm audio are allowed.")
+	ErrAudioTooLarge    = errors.New("Audio file is too large.")
+	ErrAudioTooLong     = errors.New("Voice notes can be 5 minutes long at most.")
+	ErrCorruptAudio     = errors.New("Audio is corrupt or couldn't be read.")
+)
+
+const (
+	MaxVoiceNoteDuration = 5 * time.Minute
+	// number of bars in AudioMeta.Waveform.
+	waveformBars = 64
+)
+
+var AudioContentTypes = map[string]string{
+	"ogg":  "audio/ogg",
+	"webm": "audio/webm",
+}
+
+// What clients need to render a voice note without downloading it.
+type AudioMeta struct {
+	// milliseconds.
+	Duration int    `json:"duration" bson:"duration"`
+	Codec    string `json:"codec" bson:"codec"`
+	// loudness of each slice of the voice note, from 0 to 100.
+	Waveform []int `json:"waveform" bson:"waveform"`
+}
+
+// A compressed packet and where it starts in the audio.
+type audioPacket struct {
+	at   time.Duration
+	size int
+}
+
+// Validates a voice note (see ReadAudio) and stores it as-is under "chatify-3/audio/<uuid>.<ogg|webm>".
+// returns its path and metadata.
+func (m *Media) ProcessAudio(r io.Reader) (string, AudioMeta, error) {
+	defer metrics.Time(metrics.MediaProcessing, "audio")()
+
+	data, format, meta, err := ReadAudio(r, m.MaxUploadBytes)
+	if err != nil {
+		return "", AudioMeta{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	audioPath := "chatify-3/audio/" + uuid.New().String() + "." + format
+	err = m.Store.Put(ctx, audioPath, bytes.NewReader(data), int64(len(data)), AudioContentTypes[format])
+	if err != nil {
+		return "", AudioMeta{}, err
+	}
+
+	return audioPath, meta, nil
+}
+
+// Reads an opus/ogg or webm file of at most maxBytes, walks its container to check that it only holds
+// audio, and measures its duration and waveform. returns the file bytes and its format ("ogg" or "webm").
+func ReadAudio(r io.Reader, maxBytes int64) ([]byte, string, AudioMeta, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, "", AudioMeta{}, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", AudioMeta{}, ErrAudioTooLarge
+	}
+
+	var format, codec string
+	var duration time.Duration
+	var packets []audioPacket
+	switch {
+	case bytes.HasPrefix(data, []byte("OggS")):
+		format, codec = "ogg", "opus"
+		duration, packets, err = scanOggOpus(data)
+	case bytes.HasPrefix(data, []byte{0x1A, 0x45, 0xDF, 0xA3}):
+		format = "webm"
+		codec, duration, packets, err = scanWebM(data)
+	default:
+		return nil, "", AudioMeta{}, ErrUnsupportedAudio
+	}
+	if err != nil {
+		return nil, "", AudioMeta{}, err
+	}
+	if duration <= 0 || len(packets) == 0 {
+		return nil, "", AudioMeta{}, ErrCorruptAudio
+	}
+	if duration > MaxVoiceNoteDuration {
+		return nil, "", AudioMeta{}, ErrAudioTooLong
+	}
+
+	meta := AudioMeta{
+		Duration: int(duration.Milliseconds()),
+		Codec:    codec,
+		Waveform: waveform(packets, duration),
+	}
+
+	return data, format, meta, nil
+}
+
+// Maps audio validation errors to http status codes, anything else is a 500.
+func AudioErrorStatus(err error) int {
+	switch err {
+	case ErrUnsupportedAudio:
+		return http.StatusUnsupportedMediaType
+	case ErrAudioTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case ErrAudioTooLong, ErrCorruptAudio:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Opus and vorbis can't be decoded without cgo, so the waveform is approximated from the size of the
+// compressed packets: both are variable bitrate and quiet parts compress to much smaller packets.
+// each bar is the average packet size of its slice, bars without packets repeat the previous one.
+func waveform(packets []audioPacket, duration time.Duration) []int {
+	sums := make([]float64, waveformBars)
+	counts := make([]int, waveformBars)
+	for _, packet := range packets {
+		bar := int(int64(packet.at) * waveformBars / int64(duration))
+		bar = min(max(bar, 0), waveformBars-1)
+		sums[bar] += float64(packet.size)
+		counts[bar]++
+	}
+
+	peak := 0.0
+	for i := range sums {
+		if counts[i] > 0 {
+			sums[i] /= float64(counts[i])
+		} else if i > 0 {
+			sums[i] = sums[i-1]
+		}
+		peak = max(peak, sums[i])
+	}
+
+	bars := make([]int, waveformBars)
+	if peak == 0 {
+		return bars
+	}
+	for i, avg := range sums {
+		bars[i] = int(math.Round(avg * 100 / peak))
+	}
+
+	return bars
+}
+
+// Duration of an opus packet, read from its TOC byte (RFC 6716 section 3.1).
+func opusPacketDuration(packet []byte) time.Duration {
+	if len(packet) == 0 {
+		return 0
+	}
+
+	config := packet[0] >> 3
+	var frame time.Duration
+	switch {
+	case config < 12: // SILK
+		frame = []time.Duration{10, 20, 40, 60}[config%4] * time.Millisecond
+	case config < 16: // hybrid
+		frame = []time.Duration{10, 20}[config%2] * time.Millisecond
+	default: // CELT
+		frame = []time.Duration{2500, 5000, 10000, 20000}[config%4] * time.Microsecond
+	}
+
+	frames := 1
+	switch packet[0] & 0x03 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return 0
+		}
+		frames = int(packet[1] & 0x3F)
+	}
+
+	return frame * time.Duration(frames)
+}
+
+// Walks the pages of an ogg file holding a single opus stream (RFC 7845).
+func scanOggOpus(data []byte) (time.Duration, []audioPacket, error) {
+	var packets []audioPacket
+	var packet []byte
+	var serial uint32
+	var preSkip, granule int64
+	var at time.Duration
+	headers := 0
+
+	for pos := 0; pos < len(data); {
+		if pos+27 > len(data) || string(data[pos:pos+4]) != "OggS" {
+			return 0, nil, ErrCorruptAudio
+		}
+		pageSerial := binary.LittleEndian.Uint32(data[pos+14:])
+		if pos == 0 {
+			serial = pageSerial
+		} else if pageSerial != serial {
+			// chained or multiplexed streams, could be anything.
+			return 0, nil, ErrUnsupportedAudio
+		}
+		if pageGranule := int64(binary.LittleEndian.Uint64(data[pos+6:])); pageGranule != -1 {
+			granule = pageGranule
+		}
+
+		segments := int(data[pos+26])
+		lacing := pos + 27
+		body := lacing + segments
+		if body > len(data) {
+			return 0, nil, ErrCorruptAudio
+		}
+
+		for i := range segments {
+			size := int(data[lacing+i])
+			if body+size > len(data) {
+				return 0, nil, ErrCorruptAudio
+			}
+			packet = append(packet, data[body:body+size]...)
+			body += size
+			if size == 255 {
+				continue
+			}
+
+			switch headers {
+			case 0:
+				if len(packet) < 19 || string(packet[:8]) != "OpusHead" {
+					return 0, nil, ErrUnsupportedAudio
+				}
+				preSkip = int64(binary.LittleEndian.Uint16(packet[10:]))
+				headers++
+			case 1:
+				if len(packet) < 8 || string(packet[:8]) != "OpusTags" {
+					return 0, nil, ErrCorruptAudio
+				}
+				headers++
+			default:
+				packets = append(packets, audioPacket{at, len(packet)})
+				at += opusPacketDuration(packet)
+			}
+			packet = packet[:0]
+		}
+
+		pos = body
+	}
+
+	// granule positions are always counted at 48kHz.
+	duration := time.Duration(granule-preSkip) * time.Second / 48000
+
+	return duration, packets, nil
+}
+
+// ids of the matroska elements used by scanWebM.
+const (
+	ebmlHeaderID    = 0x1A45DFA3
+	ebmlDocTypeID   = 0x4282
+	segmentID       = 0x18538067
+	infoID          = 0x1549A966
+	timecodeScaleID = 0x2AD7B1
+	durationID      = 0x4489
+	tracksID        = 0x1654AE6B
+	trackEntryID    = 0xAE
+	trackNumberID   = 0xD7
+	trackTypeID     = 0x83
+	codecIDID       = 0x86
+	clusterID       = 0x1F43B675
+	timecodeID      = 0xE7
+	blockGroupID    = 0xA0
+	blockID         = 0xA1
+	simpleBlockID   = 0xA3
+)
+
+// elements scanWebM steps into instead of skipping. their children are read as if they were siblings,
+// which also handles the unknown-size segments and clusters written by MediaRecorder.
+var webmMasters = map[uint32]bool{
+	ebmlHeaderID: true, segmentID: true, infoID: true, tracksID: true,
+	trackEntryID: true, clusterID: true, blockGroupID: true,
+}
+
+// Reads an EBML variable size integer. for ids the length marker is kept.
+// unknown is true for the reserved all-ones size.
+func readVint(data []byte, keepMarker bool) (value uint64, length int, unknown bool, err error) {
+	if len(data) == 0 || data[0] == 0 {
+		return 0, 0, false, ErrCorruptAudio
+	}
+
+	length = 1
+	for mask := byte(0x80); data[0]&mask == 0; mask >>= 1 {
+		length++
+	}
+	if length > 8 || length > len(data) {
+		return 0, 0, false, ErrCorruptAudio
+	}
+
+	value = uint64(data[0])
+	if !keepMarker {
+		value &= uint64(0xFF >> length)
+	}
+	allOnes := value == uint64(0xFF>>length)
+	for _, b := range data[1:length] {
+		value = value<<8 | uint64(b)
+		allOnes = allOnes && b == 0xFF
+	}
+
+	return value, length, !keepMarker && allOnes, nil
+}
+
+func readUint(data []byte) uint64 {
+	var v uint64
+	for _, b := range data {
+		v = v<<8 | uint64(b)
+	}
+	return v
+}
+
+type webmTrack struct {
+	number    uint64
+	trackType uint64
+	codec     string
+}
+
+// Walks a webm file holding only opus or vorbis audio tracks.
+func scanWebM(data []byte) (string, time.Duration, []audioPacket, error) {
+	var docType string
+	var scale uint64 = 1_000_000 // nanoseconds per timecode unit
+	var infoDuration float64
+	var clusterTimecode uint64
+	var tracks []webmTrack
+	var blocks []audioPacket
+	var blockTrack []uint64
+	var lastBlock []byte
+
+	for pos := 0; pos < len(data); {
+		id, idLen, _, err := readVint(data[pos:], true)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		size, sizeLen, unknown, err := readVint(data[pos+idLen:], false)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		start := pos + idLen + sizeLen
+
+		if webmMasters[uint32(id)] {
+			if id == trackEntryID {
+				tracks = append(tracks, webmTrack{})
+			}
+			pos = start
+			continue
+		}
+		if unknown || size > uint64(len(data)-start) {
+			return "", 0, nil, ErrCorruptAudio
+		}
+		payload := data[start : start+int(size)]
+		pos = start + int(size)
+
+		switch id {
+		case ebmlDocTypeID:
+			docType = string(payload)
+		case timecodeScaleID:
+			scale = readUint(payload)
+		case durationID:
+			switch len(payload) {
+			case 4:
+				infoDuration = float64(math.Float32frombits(binary.BigEndian.Uint32(payload)))
+			case 8:
+				infoDuration = math.Float64frombits(binary.BigEndian.Uint64(payload))
+			}
+		case trackNumberID, trackTypeID, codecIDID:
+			if len(tracks) == 0 {
+				return "", 0, nil, ErrCorruptAudio
+			}
+			track := &tracks[len(tracks)-1]
+			switch id {
+			case trackNumberID:
+				track.number = readUint(payload)
+			case trackTypeID:
+				track.trackType = readUint(payload)
+			case codecIDID:
+				track.codec = string(bytes.TrimRight(payload, "\x00"))
+			}
+		case timecodeID:
+			clusterTimecode = readUint(payload)
+		case blockID, simpleBlockID:
+			track, trackLen, _, err := readVint(payload, false)
+			if err != nil || len(payload) < trackLen+3 {
+				return "", 0, nil, ErrCorruptAudio
+			}
+			relative := int64(int16(binary.BigEndian.Uint16(payload[trackLen:])))
+			timecode := max(int64(clusterTimecode)+relative, 0)
+
+			blocks = append(blocks, audioPacket{time.Duration(uint64(timecode) * scale), len(payload) - trackLen - 3})
+			blockTrack = append(blockTrack, track)
+			lastBlock = payload[trackLen+3:]
+		}
+	}
+
+	if docType != "webm" || len(tracks) == 0 {
+		return "", 0, nil, ErrUnsupportedAudio
+	}
+	for _, track := range tracks {
+		// 2 is the audio track type
+		if track.trackType != 2 || (track.codec != "A_OPUS" && track.codec != "A_VORBIS") {
+			return "", 0, nil, ErrUnsupportedAudio
+		}
+	}
+
+	audio := tracks[0]
+	var packets []audioPacket
+	for i, block := range blocks {
+		if blockTrack[i] == audio.number {
+			packets = append(packets, block)
+		}
+	}
+
+	// MediaRecorder doesn't write the duration, the end of the last block is used instead.
+	duration := time.Duration(infoDuration * float64(scale))
+	if duration <= 0 && len(packets) > 0 {
+		duration = packets[len(packets)-1].at
+		if audio.codec == "A_OPUS" {
+			duration += opusPacketDuration(lastBlock)
+		}
+	}
+
+	codec := "opus"
+	if audio.codec == "A_VORBIS" {
+		codec = "vorbis"
+	}
+
+	return codec, duration, packets, nil
+}
@@ -0,0 +1,282 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+// The fixtures are laid out like MediaRecorder's recordings: chrome records audio/webm with an unknown-size
+// segment and clusters of SimpleBlocks, firefox records audio/ogg with a page per second. the opus packets are
+// 20ms frames: the 3 byte packet libopus emits for digital silence, and larger voiced packets.
+var (
+	silentPacket = []byte{0xF8, 0xFF, 0xFE}
+	voicedPacket = append([]byte{0x78}, bytes.Repeat([]byte{0x5A}, 80)...)
+)
+
+const opusPreSkip = 312
+
+// half a second of silence per second of recording, then voice.
+func recording(d time.Duration) [][]byte {
+	packets := make([][]byte, d/(20*time.Millisecond))
+	for i := range packets {
+		packets[i] = silentPacket
+		if i >= len(packets)/2 {
+			packets[i] = voicedPacket
+		}
+	}
+	return packets
+}
+
+// the waveform of a recording: the silent half relative to the voiced one.
+func recordingWaveform() []int {
+	quiet := int(math.Round(float64(len(silentPacket)) * 100 / float64(len(voicedPacket))))
+	return append(slices.Repeat([]int{quiet}, waveformBars/2), slices.Repeat([]int{100}, waveformBars/2)...)
+}
+
+func ebmlSize(size int) []byte {
+	switch {
+	case size < 0x7F:
+		return []byte{0x80 | byte(size)}
+	case size < 0x3FFF:
+		return []byte{0x40 | byte(size>>8), byte(size)}
+	default:
+		return binary.BigEndian.AppendUint32(nil, 0x10000000|uint32(size))
+	}
+}
+
+// the size MediaRecorder writes for the segment and clusters, it doesn't know them while recording.
+var unknownSize = []byte{0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}
+
+func ebmlID(id uint32) []byte {
+	b := binary.BigEndian.AppendUint32(nil, id)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return b
+}
+
+func ebmlElement(id uint32, children ...[]byte) []byte {
+	payload := bytes.Join(children, nil)
+	return append(append(ebmlID(id), ebmlSize(len(payload))...), payload...)
+}
+
+func ebmlUint(id uint32, v uint64) []byte {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	for len(b) > 1 && b[0] == 0 {
+		b = b[1:]
+	}
+	return ebmlElement(id, b)
+}
+
+func ebmlFloat(id uint32, v float64) []byte {
+	return ebmlElement(id, binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+}
+
+func opusHead(preSkip uint16) []byte {
+	head := []byte("OpusHead\x01\x01")
+	head = binary.LittleEndian.AppendUint16(head, preSkip)
+	head = binary.LittleEndian.AppendUint32(head, 48000)
+	return append(head, 0, 0, 0)
+}
+
+func webmHeader(docType string) []byte {
+	return ebmlElement(ebmlHeaderID,
+		ebmlUint(0x4286, 1), ebmlUint(0x42F7, 1), ebmlUint(0x42F2, 4), ebmlUint(0x42F3, 8),
+		ebmlElement(ebmlDocTypeID, []byte(docType)), ebmlUint(0x4287, 4), ebmlUint(0x4285, 2))
+}
+
+func webmTrackEntry(number, trackType uint64, codec string) []byte {
+	entry := [][]byte{
+		ebmlUint(trackNumberID, number), ebmlUint(0x73C5, 0x1F2E3D4C+number), ebmlUint(trackTypeID, trackType),
+		ebmlElement(codecIDID, []byte(codec)),
+	}
+	if trackType == 2 {
+		entry = append(entry, ebmlElement(0x63A2, opusHead(opusPreSkip)),
+			ebmlElement(0xE1, ebmlFloat(0xB5, 48000), ebmlUint(0x9F, 1)))
+	} else {
+		entry = append(entry, ebmlElement(0xE0, ebmlUint(0xB0, 640), ebmlUint(0xBA, 480)))
+	}
+	return ebmlElement(trackEntryID, entry...)
+}
+
+// a recording as chrome writes it, info holds the extra elements of the Info element (e.g. a duration).
+func chromeWebM(packets [][]byte, tracks []byte, info ...[]byte) []byte {
+	file := webmHeader("webm")
+	file = append(append(file, ebmlID(segmentID)...), unknownSize...)
+	info = append([][]byte{ebmlUint(timecodeScaleID, 1_000_000), ebmlElement(0x4D80, []byte("Chrome")),
+		ebmlElement(0x5741, []byte("Chrome"))}, info...)
+	file = append(file, ebmlElement(infoID, info...)...)
+	file = append(file, ebmlElement(tracksID, tracks)...)
+
+	// a cluster every 2 seconds, blocks are timed relative to it.
+	const perCluster = 100
+	for i, packet := range packets {
+		if i%perCluster == 0 {
+			file = append(append(file, ebmlID(clusterID)...), unknownSize...)
+			file = append(file, ebmlUint(timecodeID, uint64(i*20))...)
+		}
+		block := binary.BigEndian.AppendUint16([]byte{0x81}, uint16(i%perCluster*20))
+		block = append(append(block, 0x80), packet...)
+		file = append(file, ebmlElement(simpleBlockID, block)...)
+	}
+
+	return file
+}
+
+var oggCRCTable = func() (table [256]uint32) {
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04C11DB7
+			} else {
+				crc <<= 1
+			}
+		}
+		table[i] = crc
+	}
+	return table
+}()
+
+func oggPage(headerType byte, granule int64, serial, sequence uint32, packets ...[]byte) []byte {
+	page := []byte("OggS\x00")
+	page = append(page, headerType)
+	page = binary.LittleEndian.AppendUint64(page, uint64(granule))
+	page = binary.LittleEndian.AppendUint32(page, serial)
+	page = binary.LittleEndian.AppendUint32(page, sequence)
+	page = append(page, 0, 0, 0, 0)
+
+	var lacing, body []byte
+	for _, packet := range packets {
+		for size := len(packet); ; size -= 255 {
+			lacing = append(lacing, byte(min(size, 255)))
+			if size < 255 {
+				break
+			}
+		}
+		body = append(body, packet...)
+	}
+	page = append(append(append(page, byte(len(lacing))), lacing...), body...)
+
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	binary.LittleEndian.PutUint32(page[22:], crc)
+
+	return page
+}
+
+func oggHeaders(serial uint32) []byte {
+	tags := binary.LittleEndian.AppendUint32([]byte("OpusTags"), 7)
+	tags = binary.LittleEndian.AppendUint32(append(tags, "Mozilla"...), 0)
+	return append(oggPage(0x02, 0, serial, 0, opusHead(opusPreSkip)), oggPage(0x00, 0, serial, 1, tags)...)
+}
+
+// a recording as firefox writes it, a page per second.
+func firefoxOgg(packets [][]byte) []byte {
+	const serial, perPage = 0x5EED, 50
+
+	file := oggHeaders(serial)
+	for i := 0; i < len(packets); i += perPage {
+		end := min(i+perPage, len(packets))
+		headerType := byte(0)
+		if end == len(packets) {
+			headerType = 0x04
+		}
+		file = append(file, oggPage(headerType, opusPreSkip+int64(end*960), serial, uint32(2+i/perPage), packets[i:end]...)...)
+	}
+
+	return file
+}
+
+func TestReadAudio(t *testing.T) {
+	audioTrack := webmTrackEntry(1, 2, "A_OPUS")
+	chrome := chromeWebM(recording(3*time.Second), audioTrack)
+	firefox := firefoxOgg(recording(2 * time.Second))
+	ebmlStart := append(webmHeader("webm"), append(ebmlID(segmentID), unknownSize...)...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		format   string
+		duration int
+		waveform []int
+		err      error
+	}{
+		{name: "chrome webm", data: chrome, format: "webm", duration: 3000, waveform: recordingWaveform()},
+		{name: "firefox ogg", data: firefox, format: "ogg", duration: 2000, waveform: recordingWaveform()},
+		// the duration in Info wins over the blocks when it's there.
+		{name: "webm with a duration", data: chromeWebM(recording(3*time.Second), audioTrack, ebmlFloat(durationID, 2500)), format: "webm", duration: 2500},
+
+		{name: "webm with a video track", data: chromeWebM(recording(time.Second), append(webmTrackEntry(1, 2, "A_OPUS"), webmTrackEntry(2, 1, "V_VP8")...)), err: ErrUnsupportedAudio},
+		{name: "webm with only video", data: chromeWebM(recording(time.Second), webmTrackEntry(1, 1, "V_VP8")), err: ErrUnsupportedAudio},
+		{name: "matroska", data: append(webmHeader("matroska"), chrome[len(webmHeader("webm")):]...), err: ErrUnsupportedAudio},
+		{name: "webm truncated mid block", data: chrome[:len(chrome)-10], err: ErrCorruptAudio},
+		{name: "webm element larger than the file", data: append(ebmlStart, 0xEC, 0x01, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF), err: ErrCorruptAudio},
+		{name: "webm block of unknown size", data: append(ebmlStart, append([]byte{simpleBlockID}, unknownSize...)...), err: ErrCorruptAudio},
+		{name: "webm id longer than 8 bytes", data: append(ebmlStart, 0x00, 0x81, 0x00), err: ErrCorruptAudio},
+		{name: "webm without blocks", data: chromeWebM(nil, audioTrack), err: ErrCorruptAudio},
+
+		{name: "ogg truncated mid page", data: firefox[:len(firefox)-10], err: ErrCorruptAudio},
+		{name: "ogg truncated page header", data: firefox[:20], err: ErrCorruptAudio},
+		{name: "ogg with another stream", data: append(firefox, oggPage(0x02, 0, 0xF00D, 0, []byte("\x80theora"))...), err: ErrUnsupportedAudio},
+		{name: "ogg vorbis", data: oggPage(0x02, 0, 1, 0, []byte("\x01vorbis\x00\x00\x00\x00\x01\x44\xAC\x00\x00")), err: ErrUnsupportedAudio},
+		{name: "ogg without packets", data: oggHeaders(1), err: ErrCorruptAudio},
+		{name: "ogg longer than 5 minutes", data: append(oggHeaders(1), oggPage(0x04, opusPreSkip+48000*301, 1, 2, voicedPacket)...), err: ErrAudioTooLong},
+
+		{name: "mp3", data: []byte("ID3\x04\x00\x00\x00\x00\x00\x00"), err: ErrUnsupportedAudio},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, format, meta, err := ReadAudio(bytes.NewReader(test.data), 1<<20)
+			if err != test.err {
+				t.Fatalf("ReadAudio error = %v, want %v", err, test.err)
+			}
+			if err != nil {
+				return
+			}
+			if format != test.format || meta.Codec != "opus" || meta.Duration != test.duration || !bytes.Equal(data, test.data) {
+				t.Fatalf("ReadAudio = %s, %+v", format, meta)
+			}
+			if test.waveform != nil && !slices.Equal(meta.Waveform, test.waveform) {
+				t.Fatalf("waveform = %v, want %v", meta.Waveform, test.waveform)
+			}
+		})
+	}
+}
+
+func TestReadAudioTooLarge(t *testing.T) {
+	data := firefoxOgg(recording(time.Second))
+	if _, _, _, err := ReadAudio(bytes.NewReader(data), int64(len(data)-1)); err != ErrAudioTooLarge {
+		t.Fatalf("ReadAudio error = %v, want %v", err, ErrAudioTooLarge)
+	}
+}
+
+func FuzzReadAudio(f *testing.F) {
+	f.Add(chromeWebM(recording(time.Second), webmTrackEntry(1, 2, "A_OPUS")))
+	f.Add(chromeWebM(recording(time.Second), append(webmTrackEntry(1, 2, "A_OPUS"), webmTrackEntry(2, 1, "V_VP8")...)))
+	f.Add(firefoxOgg(recording(time.Second)))
+
+	f.Fuzz(func(t *testing.T, file []byte) {
+		data, format, meta, err := ReadAudio(bytes.NewReader(file), 1<<20)
+		if err != nil {
+			return
+		}
+		if (format != "ogg" && format != "webm") || !bytes.Equal(data, file) {
+			t.Fatalf("ReadAudio = %q, %d bytes", format, len(data))
+		}
+		if meta.Duration < 0 || time.Duration(meta.Duration)*time.Millisecond > MaxVoiceNoteDuration || len(meta.Waveform) != waveformBars {
+			t.Fatalf("ReadAudio = %+v", meta)
+		}
+		for _, bar := range meta.Waveform {
+			if bar < 0 || bar > 100 {
+				t.Fatalf("waveform = %v", meta.Waveform)
+			}
+		}
+	})
+}
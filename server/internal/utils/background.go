@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// Background tracks the work started in the background by requests, shutdown waits for it (see Wait).
+// main shares one between the server, the hub, the cache and the media, the zero value is ready to use.
+type Background struct {
+	wg sync.WaitGroup
+
+	mu      sync.Mutex
+	waiting bool
+}
+
+// Runs fn in its own goroutine, tracked so it isn't cut off by a shutdown.
+// once Wait has started fn runs inline instead, WaitGroup.Add must not race with Wait.
+func (b *Background) Go(fn func()) {
+	b.mu.Lock()
+	if b.waiting {
+		b.mu.Unlock()
+		fn()
+		return
+	}
+	b.wg.Add(1)
+	b.mu.Unlock()
+
+	go func() {
+		defer b.wg.Done()
+		fn()
+	}()
+}
+
+// Waits for the work started with Go, or until ctx is done.
+func (b *Background) Wait(ctx context.Context) error {
+	b.mu.Lock()
+	b.waiting = true
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
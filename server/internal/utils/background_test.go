@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBackgroundWait(t *testing.T) {
+	var b Background
+	release := make(chan struct{})
+	b.Go(func() { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	if err := b.Wait(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Wait with work still running = %v", err)
+	}
+
+	// after Wait has started Go runs fn before returning.
+	ran := false
+	b.Go(func() { ran = true })
+	if !ran {
+		t.Fatal("Go after Wait didn't run fn inline")
+	}
+
+	close(release)
+	if err := b.Wait(context.Background()); err != nil {
+		t.Fatalf("Wait = %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+)
+
+// Media stores the uploaded images and voice notes in Store and deletes them once they're replaced or removed.
+type Media struct {
+	Store storage.Storage
+	// schedules the deletion of files, main points it to the durable deletion queue (redis.Cache.EnqueueDeletion).
+	// when nil the files are deleted right away, in Background.
+	Queue      func(filePaths ...string)
+	Background *Background
+
+	// MAX_UPLOAD_BYTES, 10MB by default.
+	MaxUploadBytes int64
+	// MAX_IMAGE_SIDE and MAX_IMAGE_PIXELS, checked before an image is decoded.
+	MaxImageSide   int
+	MaxImagePixels int
+	// MAX_ANIMATION_FRAMES, 300 by default.
+	MaxAnimationFrames int
+	// IMAGE_QUALITY, the webp quality stored images are encoded with.
+	ImageQuality float32
+	// MEDIA_URL_TTL, how long signed media urls stay valid.
+	URLTTL time.Duration
+
+	signingKey []byte
+	// limits how many images are decoded at once (MAX_IMAGE_DECODES), decoding is what costs memory.
+	decoding chan struct{}
+}
+
+func NewMedia(cfg config.Media, store storage.Storage, queue func(filePaths ...string), background *Background) *Media {
+	return &Media{
+		Store:              store,
+		Queue:              queue,
+		Background:         background,
+		MaxUploadBytes:     cfg.MaxUploadBytes,
+		MaxImageSide:       cfg.MaxImageSide,
+		MaxImagePixels:     cfg.MaxImagePixels,
+		MaxAnimationFrames: cfg.MaxAnimationFrames,
+		ImageQuality:       cfg.ImageQuality,
+		URLTTL:             cfg.URLTTL,
+		signingKey:         mediaSigningKey(cfg.SigningKey),
+		decoding:           make(chan struct{}, cfg.MaxDecodes),
+	}
+}
+
+// Schedules the deletion of files and, for images, all of their variants.
+func (m *Media) DeleteLater(filePaths ...string) {
+	if m.Queue != nil {
+		m.Queue(filePaths...)
+		return
+	}
+
+	for _, filePath := range filePaths {
+		m.Background.Go(func() { DeleteFile(m.Store, filePath) })
+	}
+}
+
+// Deletes a file and, for images, all of its variants.
+func DeleteFile(store storage.Storage, filePath string) error {
+	if filePath == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var errs []error
+	for _, p := range VariantPaths(filePath) {
+		if err := store.Delete(ctx, p); err != nil {
+			slog.Error("Couldn't delete file", "key", p, "error", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Downloads a raw upload from storage and runs it through the image pipeline.
+// the raw object is deleted afterwards. returns the path of the main variant.
+func (m *Media) ProcessRawUpload(ctx context.Context, rawKey string) (string, ImageMeta, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	body, _, err := m.Store.Get(ctx, rawKey)
+	if err != nil {
+		return "", ImageMeta{}, err
+	}
+	defer body.Close()
+
+	path, meta, err := m.ProcessImage(body)
+	if err != nil {
+		// the raw object will never be a valid image, so it's not kept around.
+		if ImageErrorStatus(err) != http.StatusInternalServerError {
+			m.DeleteLater(rawKey)
+		}
+		return "", ImageMeta{}, err
+	}
+
+	m.DeleteLater(rawKey)
+
+	return path, meta, nil
+}
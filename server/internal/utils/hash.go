@@ -1,9 +1,126 @@
 package utils
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
 
-func HashPassword(password string) (string, error) {
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), 12)
-	return string(hashedBytes), err
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrMismatchedPassword = errors.New("Password doesn't match.")
+	ErrUnknownHash        = errors.New("Unknown password hash format.")
+)
+
+type Argon2Params struct {
+	Memory  uint32 // in KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// PasswordHasher hashes and verifies passwords.
+type PasswordHasher struct {
+	// the parameters new hashes are created with. hashes created with other parameters are rehashed on the next login.
+	Params Argon2Params
+	// limits how many argon2id hashes are computed at once, each one allocates Params.Memory.
+	slots chan struct{}
+}
+
+// The parameters are tuned with ARGON2_MEMORY (KiB), ARGON2_TIME and ARGON2_THREADS, and ARGON2_CONCURRENCY
+// limits how many passwords are hashed at once.
+func NewPasswordHasher(cfg config.Auth) *PasswordHasher {
+	return &PasswordHasher{
+		Params: Argon2Params{Memory: cfg.Argon2Memory, Time: cfg.Argon2Time, Threads: cfg.Argon2Threads, SaltLen: 16, KeyLen: 32},
+		slots:  make(chan struct{}, cfg.Argon2Concurrency),
+	}
 }
 
+// Computes an argon2id key, waiting for a free slot first.
+func (h *PasswordHasher) idKey(password, salt []byte, p Argon2Params) []byte {
+	h.slots <- struct{}{}
+	defer func() { <-h.slots }()
+
+	return argon2.IDKey(password, salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+}
+
+// Hashes a password with argon2id, encoded in the PHC string format:
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	p := h.Params
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := h.idKey([]byte(password), salt, p)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Compares a password with a stored hash (argon2id or legacy bcrypt).
+// needsRehash is true when the password matched but the hash is bcrypt or uses outdated argon2 parameters.
+func (h *PasswordHasher) Verify(hash, password string) (needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return h.verifyArgon2id(hash, password)
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+			return false, ErrMismatchedPassword
+		}
+		return true, nil
+	default:
+		return false, ErrUnknownHash
+	}
+}
+
+func (h *PasswordHasher) verifyArgon2id(hash, password string) (bool, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, ErrUnknownHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnknownHash
+	}
+
+	var p Argon2Params
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return false, ErrUnknownHash
+	}
+	// argon2 panics on zero time or threads.
+	if p.Memory == 0 || p.Time == 0 || p.Threads == 0 {
+		return false, ErrUnknownHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrUnknownHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, ErrUnknownHash
+	}
+	if len(salt) == 0 || len(key) == 0 {
+		return false, ErrUnknownHash
+	}
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+
+	other := h.idKey([]byte(password), salt, p)
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, ErrMismatchedPassword
+	}
+
+	return p != h.Params, nil
+}
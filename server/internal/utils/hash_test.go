@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func testHasher() *PasswordHasher {
+	return NewPasswordHasher(config.Auth{Argon2Memory: 1024, Argon2Time: 1, Argon2Threads: 1, Argon2Concurrency: 1})
+}
+
+func TestPasswordHashRoundTrip(t *testing.T) {
+	h := testHasher()
+
+	hash, err := h.Hash("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("hash = %q", hash)
+	}
+	if again, _ := h.Hash("correct horse battery staple"); again == hash {
+		t.Fatal("two hashes of the same password share their salt")
+	}
+
+	if needsRehash, err := h.Verify(hash, "correct horse battery staple"); err != nil || needsRehash {
+		t.Fatalf("Verify = %v, %v", needsRehash, err)
+	}
+	if _, err := h.Verify(hash, "correct horse battery stapler"); err != ErrMismatchedPassword {
+		t.Fatalf("Verify of a wrong password error = %v", err)
+	}
+
+	// hashes made with other parameters still verify, and are rehashed.
+	stronger := NewPasswordHasher(config.Auth{Argon2Memory: 2048, Argon2Time: 1, Argon2Threads: 1, Argon2Concurrency: 1})
+	if needsRehash, err := stronger.Verify(hash, "correct horse battery staple"); err != nil || !needsRehash {
+		t.Fatalf("Verify with new parameters = %v, %v", needsRehash, err)
+	}
+}
+
+func TestPasswordVerifiesBcrypt(t *testing.T) {
+	h := testHasher()
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse battery staple"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if needsRehash, err := h.Verify(string(hash), "correct horse battery staple"); err != nil || !needsRehash {
+		t.Fatalf("Verify = %v, %v", needsRehash, err)
+	}
+	if needsRehash, err := h.Verify(string(hash), "wrong"); err != ErrMismatchedPassword || needsRehash {
+		t.Fatalf("Verify of a wrong password = %v, %v", needsRehash, err)
+	}
+}
+
+func TestPasswordRejectsMalformedHashes(t *testing.T) {
+	h := testHasher()
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+
+	for _, hash := range []string{
+		"",
+		"plaintext",
+		"$argon2i$v=19$m=1024,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=1024,t=1,p=1$" + salt,
+		"$argon2id$v=16$m=1024,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=1024,t=0,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=1024,t=1,p=0$" + salt + "$" + key,
+		"$argon2id$v=19$m=1024,t=1,p=256$" + salt + "$" + key,
+		"$argon2id$v=19$m=1024,t=1,p=1$not base64!$" + key,
+		"$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$",
+		"$argon2id$v=19$m=1024,t=1,p=1$" + salt + "$" + key + "$extra",
+	} {
+		if _, err := h.Verify(hash, "correct horse battery staple"); err != ErrUnknownHash {
+			t.Errorf("Verify(%q) error = %v", hash, err)
+		}
+	}
+}
+
+func TestValidatePasswordPolicy(t *testing.T) {
+	tests := []struct {
+		password string
+		ok       bool
+	}{
+		{"correct horse battery staple", true},
+		{"short", false},
+		{strings.Repeat("a", MinPasswordLength-1) + "b", true},
+		{strings.Repeat("a", MinPasswordLength-2) + "b", false},
+		{strings.Repeat("ab", MaxPasswordLength/2), true},
+		{strings.Repeat("ab", MaxPasswordLength/2) + "c", false},
+		// the length is counted in characters, not bytes.
+		{"pässwörd", true},
+		{"pâss", false},
+		{"password", false},
+		{"PassWord1", false},
+		{"qwertyuiop", false},
+	}
+	for _, test := range tests {
+		if err := ValidatePasswordPolicy(test.password); (err == nil) != test.ok {
+			t.Errorf("ValidatePasswordPolicy(%q) = %v", test.password, err)
+		}
+	}
+}
@@ -1,66 +1,82 @@
 package utils
 
 import (
-	"bytes"
 	"errors"
-	"image"
-	"log"
-	"mime/multipart"
 	"net/http"
-	"strings"
 
-	"github.com/chai2010/webp"
-	"github.com/disintegration/imaging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
-func IsImage(fileHeader *multipart.FileHeader) bool {
-	fileType := fileHeader.Header.Get("Content-Type")
-	return strings.HasPrefix(fileType, "image/")
+// room for the multipart boundaries and headers, and the form's other fields.
+const multipartOverhead = 64 << 10
+
+// Caps the request body at the max upload size, so an oversized upload is cut off while it's read instead of
+// being spooled to disk first. call it before the multipart form is parsed (e.g. by binding).
+func (m *Media) LimitBody(w http.ResponseWriter, req *http.Request) {
+	req.Body = http.MaxBytesReader(w, req.Body, m.MaxUploadBytes+multipartOverhead)
+}
+
+// Reports whether err is from reading past the body's limit, see LimitBody.
+func IsBodyTooLarge(err error) bool {
+	var tooLarge *http.MaxBytesError
+	return errors.As(err, &tooLarge)
 }
 
-func ExtractFileAndUpload(req *http.Request, fieldName string) (string, int, error) {
+func (m *Media) ExtractFileAndUpload(w http.ResponseWriter, req *http.Request, fieldName string) (string, ImageMeta, int, error) {
+	m.LimitBody(w, req)
 	file, fileHeader, err := req.FormFile(fieldName)
-	if err != nil {
-		return "", http.StatusBadRequest, errors.New("File missing or invalid.")
+	if IsBodyTooLarge(err) {
+		return "", ImageMeta{}, http.StatusRequestEntityTooLarge, ErrImageTooLarge
+	} else if err != nil {
+		return "", ImageMeta{}, http.StatusBadRequest, errors.New("File missing or invalid.")
 	}
 
-	if !IsImage(fileHeader) {
-		return "", http.StatusBadRequest, errors.New("Only images are allowed.")
-	}
+	defer file.Close()
 
-	imgBytes, err := compressImage(file)
-	if err != nil {
-		return "", http.StatusInternalServerError, errors.New("Failed to decode the image.")
+	if fileHeader.Size > m.MaxUploadBytes {
+		return "", ImageMeta{}, http.StatusRequestEntityTooLarge, ErrImageTooLarge
 	}
 
-	filePath, err := UploadFileToS3(imgBytes, fileHeader)
+	filePath, meta, err := m.ProcessImage(file)
 	if err != nil {
-		log.Println(err)
-		return "", http.StatusInternalServerError, errors.New("Failed to upload the image.")
+		code := ImageErrorStatus(err)
+		if code == http.StatusInternalServerError && err != ErrEncodeImage {
+			logging.From(req.Context()).Error("Couldn't process image", "error", err)
+			err = errors.New("Failed to upload the image.")
+		}
+		return "", ImageMeta{}, code, err
 	}
 
-	return filePath, 0, nil
+	return filePath, meta, 0, nil
 }
 
-func compressImage(file multipart.File) ([]byte, error) {
-	img, _, err := image.Decode(file)
-	if err != nil {
-		return nil, errors.New("Failed to decode the image.")
+func (m *Media) ExtractAudioAndUpload(w http.ResponseWriter, req *http.Request, fieldName string) (string, AudioMeta, int, error) {
+	m.LimitBody(w, req)
+	file, fileHeader, err := req.FormFile(fieldName)
+	if IsBodyTooLarge(err) {
+		return "", AudioMeta{}, http.StatusRequestEntityTooLarge, ErrAudioTooLarge
+	} else if err != nil {
+		return "", AudioMeta{}, http.StatusBadRequest, errors.New("File missing or invalid.")
 	}
 
-	img = imaging.Resize(img, 512, 0, imaging.Lanczos)
+	defer file.Close()
+
+	if fileHeader.Size > m.MaxUploadBytes {
+		return "", AudioMeta{}, http.StatusRequestEntityTooLarge, ErrAudioTooLarge
+	}
 
-	var buf bytes.Buffer
-	err = webp.Encode(&buf, img, &webp.Options{
-		Lossless: false,
-		Quality:  75,
-	})
+	filePath, meta, err := m.ProcessAudio(file)
 	if err != nil {
-		return nil, errors.New("Failed to encode the image.")
+		code := AudioErrorStatus(err)
+		if code == http.StatusInternalServerError {
+			logging.From(req.Context()).Error("Couldn't process voice note", "error", err)
+			err = errors.New("Failed to upload the voice note.")
+		}
+		return "", AudioMeta{}, code, err
 	}
 
-	return buf.Bytes(), nil
+	return filePath, meta, 0, nil
 }
 
 func GetOtherParticipant(userID bson.ObjectID, participants [2]bson.ObjectID) bson.ObjectID {
@@ -0,0 +1,221 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"io"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/chai2010/webp"
+	"github.com/disintegration/imaging"
+	"github.com/google/uuid"
+)
+
+var ErrEncodeImage = errors.New("Failed to encode the image.")
+
+// Layout information of an uploaded image so clients can reserve space and show a placeholder before it loads.
+type ImageMeta struct {
+	Width    int    `json:"width" bson:"width"`
+	Height   int    `json:"height" bson:"height"`
+	Blurhash string `json:"blurhash" bson:"blurhash"`
+	// variant name -> storage path.
+	Variants map[string]string `json:"variants,omitempty" bson:"variants,omitempty"`
+	// animated images keep their original format, the still variants are made from the first frame.
+	Animated bool `json:"animated,omitempty" bson:"animated,omitempty"`
+	Frames   int  `json:"frames,omitempty" bson:"frames,omitempty"`
+	// total duration of one loop in milliseconds.
+	Duration int    `json:"duration,omitempty" bson:"duration,omitempty"`
+	Poster   string `json:"poster,omitempty" bson:"poster,omitempty"`
+}
+
+type imageVariant struct {
+	name  string
+	width int
+}
+
+// every image is stored as "chatify-3/<uuid>/<variant>.webp". images are never upscaled.
+var imageVariants = []imageVariant{{"thumb", 160}, {"medium", 512}, {"large", 1280}}
+
+// the variant whose path is stored on messages and users (what used to be the only 512px image).
+const mainVariant = "medium"
+
+// animations are stored next to their still variants as "chatify-3/<uuid>/animated.<gif|webp>".
+const animatedVariant = "animated"
+
+// Validates and decodes an image (see ReadImage), applies its EXIF orientation and stores a webp of every variant.
+// every upload path goes through here, so it waits for one of the decoding slots first.
+// the webp encoding drops all EXIF metadata. returns the path of the main variant and the image metadata.
+// animated images are stored as-is (see sanitizeAnimation) and their path is returned instead.
+func (m *Media) ProcessImage(r io.Reader) (string, ImageMeta, error) {
+	m.decoding <- struct{}{}
+	defer func() { <-m.decoding }()
+
+	data, format, err := ReadImage(r, m.MaxUploadBytes, m.MaxImageSide, m.MaxImagePixels)
+	if err != nil {
+		return "", ImageMeta{}, err
+	}
+
+	anim, err := inspectAnimation(data, format, m.MaxAnimationFrames)
+	if err != nil {
+		return "", ImageMeta{}, err
+	}
+	if anim != nil {
+		defer metrics.Time(metrics.MediaProcessing, "animation")()
+		return m.processAnimation(data, format, anim)
+	}
+	defer metrics.Time(metrics.MediaProcessing, "image")()
+
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return "", ImageMeta{}, ErrCorruptImage
+	}
+	data = nil // let the encoded bytes be collected while the variants are encoded
+
+	dir := "chatify-3/" + uuid.New().String()
+	meta, err := m.storeVariants(img, dir)
+	if err != nil {
+		return "", ImageMeta{}, err
+	}
+
+	return meta.Variants[mainVariant], meta, nil
+}
+
+func (m *Media) processAnimation(data []byte, format string, anim *animation) (string, ImageMeta, error) {
+	poster, err := decodePoster(data, format, anim)
+	if err != nil {
+		return "", ImageMeta{}, err
+	}
+	animated, err := sanitizeAnimation(data, format)
+	if err != nil {
+		return "", ImageMeta{}, err
+	}
+	data = nil
+
+	dir := "chatify-3/" + uuid.New().String()
+	meta, err := m.storeVariants(poster, dir)
+	if err != nil {
+		return "", ImageMeta{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	animatedPath := dir + "/" + animatedVariant + "." + format
+	err = m.Store.Put(ctx, animatedPath, bytes.NewReader(animated), int64(len(animated)), ImageContentTypes[format])
+	if err != nil {
+		m.deleteVariants(meta.Variants)
+		return "", ImageMeta{}, err
+	}
+
+	meta.Variants[animatedVariant] = animatedPath
+	meta.Animated = true
+	meta.Frames = anim.frames
+	meta.Duration = anim.duration
+	meta.Poster = meta.Variants[mainVariant]
+
+	return animatedPath, meta, nil
+}
+
+// Stores a webp of every variant of img under dir.
+func (m *Media) storeVariants(img image.Image, dir string) (ImageMeta, error) {
+	bounds := img.Bounds()
+	meta := ImageMeta{
+		Width:    bounds.Dx(),
+		Height:   bounds.Dy(),
+		Blurhash: EncodeBlurhash(imaging.Resize(img, 32, 0, imaging.Box), 4, 3),
+		Variants: make(map[string]string, len(imageVariants)),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	for _, variant := range imageVariants {
+		resized := img
+		if meta.Width > variant.width {
+			resized = imaging.Resize(img, variant.width, 0, imaging.Lanczos)
+		}
+
+		imgBytes, err := encodeWebp(resized, m.ImageQuality)
+		if err != nil {
+			m.deleteVariants(meta.Variants)
+			return ImageMeta{}, err
+		}
+
+		variantPath := dir + "/" + variant.name + ".webp"
+		err = m.Store.Put(ctx, variantPath, bytes.NewReader(imgBytes), int64(len(imgBytes)), "image/webp")
+		if err != nil {
+			m.deleteVariants(meta.Variants)
+			return ImageMeta{}, err
+		}
+		meta.Variants[variant.name] = variantPath
+	}
+
+	return meta, nil
+}
+
+func encodeWebp(img image.Image, quality float32) ([]byte, error) {
+	var buf bytes.Buffer
+	err := webp.Encode(&buf, img, &webp.Options{
+		Lossless: false,
+		Quality:  quality,
+	})
+	if err != nil {
+		return nil, ErrEncodeImage
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (m *Media) deleteVariants(variants map[string]string) {
+	m.DeleteLater(slices.Collect(maps.Values(variants))...)
+}
+
+// Returns the paths a message or user may have stored for the file at key: key itself and, for a variant of an
+// image stored by ProcessImage, the path of its main variant and of its animation.
+func StoredPaths(key string) []string {
+	dir, base := path.Split(key)
+	isVariant := base == animatedVariant+".gif" || base == animatedVariant+".webp"
+	for _, variant := range imageVariants {
+		isVariant = isVariant || base == variant.name+".webp"
+	}
+	if !isVariant || !strings.HasPrefix(key, "chatify-3/") {
+		return []string{key}
+	}
+
+	paths := []string{key}
+	for _, p := range []string{dir + mainVariant + ".webp", dir + animatedVariant + ".gif", dir + animatedVariant + ".webp"} {
+		if p != key {
+			paths = append(paths, p)
+		}
+	}
+
+	return paths
+}
+
+// Returns every stored file belonging to the image at filePath: all variants for images stored by ProcessImage,
+// or just filePath for older single-file images.
+func VariantPaths(filePath string) []string {
+	base := path.Base(filePath)
+	animated := strings.HasPrefix(base, animatedVariant+".")
+	if (base != mainVariant+".webp" && !animated) || !strings.HasPrefix(filePath, "chatify-3/") {
+		return []string{filePath}
+	}
+
+	dir := path.Dir(filePath)
+	paths := make([]string, len(imageVariants), len(imageVariants)+1)
+	for i, variant := range imageVariants {
+		paths[i] = dir + "/" + variant.name + ".webp"
+	}
+	if animated {
+		paths = append(paths, filePath)
+	}
+
+	return paths
+}
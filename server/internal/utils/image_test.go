@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/storage"
+)
+
+// multipart, presigned and resumable uploads all decode through ProcessImage, so they share its slots.
+func TestProcessImageWaitsForADecodingSlot(t *testing.T) {
+	cfg := config.Default().Media
+	cfg.SigningKey = "secret"
+	cfg.MaxDecodes = 1
+	m := NewMedia(cfg, storage.NewMemory(), nil, &Background{})
+	img := encodeImage(t, "png", testImage(24, 16))
+
+	m.decoding <- struct{}{}
+	done := make(chan error)
+	go func() {
+		_, _, err := m.ProcessImage(bytes.NewReader(img))
+		done <- err
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("the image was decoded while every slot was taken")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	<-m.decoding
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the image wasn't decoded once a slot was free")
+	}
+}
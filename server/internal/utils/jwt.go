@@ -2,34 +2,36 @@ package utils
 
 import (
 	"errors"
-	"os"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func VerifyToken(token string) (string, error) {
-	secret := os.Getenv("AUTH_SECRET")
-	if secret == "" {
-		panic("AUTH_SECRET is a required env variable.")
-	}
-
-	parsedToken, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
-	})
-
+func (k *Keyring) VerifyToken(token string) (string, error) {
+	parsedToken, err := k.Parse(token)
 	if err != nil || !parsedToken.Valid {
-		return "", err
+		return "", errors.New("Invalid token")
 	}
 
 	claims, ok := parsedToken.Claims.(jwt.MapClaims)
 	if !ok {
-		return "", err
+		return "", errors.New("Invalid token")
 	}
 
-	userId := string(claims["id"].(string))
+	userId, _ := claims["id"].(string)
 	if userId == "" {
 		return "", errors.New("Invalid token")
 	}
 
 	return userId, nil
-}
\ No newline at end of file
+}
+
+// Signs a token for the given user with the keyring's signing key.
+func (k *Keyring) SignToken(userID string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	return k.Sign(jwt.MapClaims{
+		"id":  userID,
+		"iat": now.Unix(),
+		"exp": now.Add(ttl).Unix(),
+	})
+}
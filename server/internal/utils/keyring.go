@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// the key id used for tokens that have no "kid" header (tokens signed by the frontend with AUTH_SECRET).
+const LegacyKeyID = "legacy"
+
+type JWTKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private key ([]byte, *rsa.PrivateKey or ed25519.PrivateKey), nil for verification-only keys.
+	signKey any
+	// []byte, *rsa.PublicKey or ed25519.PublicKey.
+	verifyKey any
+}
+
+type Keyring struct {
+	signingKID string
+	keys       map[string]*JWTKey
+}
+
+func NewKeyring() *Keyring {
+	return &Keyring{keys: make(map[string]*JWTKey)}
+}
+
+// Builds the keyring from the auth config:
+//   - Secret (AUTH_SECRET): HS256 secret verifying tokens without a "kid" header.
+//   - HMACKeys (JWT_HMAC_KEYS): extra HS256 keys as "kid:secret".
+//   - KeysDir (JWT_KEYS_DIR): a directory of "<kid>.pem" files. RSA keys are used with RS256, Ed25519 keys with EdDSA.
+//     private keys can sign and verify, public keys only verify (retired keys).
+//   - SigningKID (JWT_SIGNING_KID): the key used to sign new tokens, the legacy key by default.
+func LoadKeyring(cfg config.Auth) (*Keyring, error) {
+	keyring := NewKeyring()
+	keyring.AddHMAC(LegacyKeyID, []byte(cfg.Secret))
+
+	for _, pair := range cfg.HMACKeys {
+		kid, secret, _ := strings.Cut(pair, ":")
+		if _, ok := keyring.keys[kid]; ok {
+			return nil, fmt.Errorf("JWT_HMAC_KEYS: key id %q is already in the keyring.", kid)
+		}
+		keyring.AddHMAC(kid, []byte(secret))
+	}
+
+	if cfg.KeysDir != "" {
+		if err := keyring.LoadDir(cfg.KeysDir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := keyring.SetSigningKey(cfg.SigningKID); err != nil {
+		return nil, err
+	}
+
+	return keyring, nil
+}
+
+func (k *Keyring) AddHMAC(kid string, secret []byte) {
+	k.keys[kid] = &JWTKey{ID: kid, Method: jwt.SigningMethodHS256, signKey: secret, verifyKey: secret}
+}
+
+// Adds an asymmetric key. key may be a *rsa.PrivateKey, *rsa.PublicKey, ed25519.PrivateKey or ed25519.PublicKey.
+func (k *Keyring) Add(kid string, key any) error {
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		k.keys[kid] = &JWTKey{ID: kid, Method: jwt.SigningMethodRS256, signKey: key, verifyKey: &key.PublicKey}
+	case *rsa.PublicKey:
+		k.keys[kid] = &JWTKey{ID: kid, Method: jwt.SigningMethodRS256, verifyKey: key}
+	case ed25519.PrivateKey:
+		k.keys[kid] = &JWTKey{ID: kid, Method: jwt.SigningMethodEdDSA, signKey: key, verifyKey: key.Public()}
+	case ed25519.PublicKey:
+		k.keys[kid] = &JWTKey{ID: kid, Method: jwt.SigningMethodEdDSA, verifyKey: key}
+	default:
+		return fmt.Errorf("Unsupported key type %T for kid %q.", key, kid)
+	}
+
+	return nil
+}
+
+// Loads every "<kid>.pem" file of dir into the keyring. a kid that is already in the keyring is an error,
+// a file must never replace a key, e.g. "legacy.pem" replacing the key that verifies tokens without a kid.
+func (k *Keyring) LoadDir(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(file), ".pem")
+		if _, ok := k.keys[kid]; ok {
+			return fmt.Errorf("%s: key id %q is already in the keyring.", file, kid)
+		}
+
+		key, err := parsePEMKey(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+
+		if err = k.Add(kid, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func parsePEMKey(data []byte) (any, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM block found.")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("Unsupported PEM block %q.", block.Type)
+	}
+}
+
+func (k *Keyring) SetSigningKey(kid string) error {
+	key, ok := k.keys[kid]
+	if !ok {
+		return fmt.Errorf("Signing key %q not found.", kid)
+	}
+	if key.signKey == nil {
+		return fmt.Errorf("Signing key %q has no private key.", kid)
+	}
+
+	k.signingKID = kid
+	return nil
+}
+
+// Returns the allowed algorithms, one per key type in the keyring.
+func (k *Keyring) Methods() []string {
+	seen := map[string]bool{}
+	methods := []string{}
+	for _, key := range k.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+
+	return methods
+}
+
+// jwt.Keyfunc resolving the verification key by the "kid" header and enforcing the key's algorithm.
+func (k *Keyring) keyFunc(t *jwt.Token) (any, error) {
+	kid, _ := t.Header["kid"].(string)
+	if kid == "" {
+		kid = LegacyKeyID
+	}
+
+	key, ok := k.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown key id %q.", kid)
+	}
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method %q for key %q.", t.Method.Alg(), kid)
+	}
+
+	return key.verifyKey, nil
+}
+
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	key := k.keys[k.signingKID]
+	token := jwt.NewWithClaims(key.Method, claims)
+	if key.ID != LegacyKeyID {
+		token.Header["kid"] = key.ID
+	}
+
+	return token.SignedString(key.signKey)
+}
+
+func (k *Keyring) Parse(token string) (*jwt.Token, error) {
+	return jwt.Parse(token, k.keyFunc, jwt.WithValidMethods(k.Methods()))
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Returns the public keys of the keyring as a JSON Web Key Set. HMAC keys are never published.
+func (k *Keyring) JWKS() []JWK {
+	keys := []JWK{}
+	for _, key := range k.keys {
+		switch pub := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, JWK{Kty: "RSA", Kid: key.ID, Use: "sig", Alg: key.Method.Alg(),
+				N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())})
+		case ed25519.PublicKey:
+			keys = append(keys, JWK{Kty: "OKP", Kid: key.ID, Use: "sig", Alg: key.Method.Alg(),
+				Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(pub)})
+		}
+	}
+	slices.SortFunc(keys, func(a, b JWK) int { return strings.Compare(a.Kid, b.Kid) })
+
+	return keys
+}
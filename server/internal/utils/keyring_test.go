@@ -0,0 +1,147 @@
+package utils
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// a keyring with the legacy secret, an extra HMAC key, an RSA key and an Ed25519 key, and the RSA key's public PEM.
+func testKeyring(t *testing.T) (*Keyring, []byte) {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := NewKeyring()
+	keyring.AddHMAC(LegacyKeyID, []byte("legacy secret"))
+	keyring.AddHMAC("hmac-2", []byte("second secret"))
+	if err := keyring.Add("rsa-1", rsaKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := keyring.Add("ed-1", edKey); err != nil {
+		t.Fatal(err)
+	}
+
+	der, _ := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	return keyring, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+}
+
+// signs claims for user "42" with method and key, adding a kid header unless it's empty.
+func signWith(t *testing.T, method jwt.SigningMethod, kid string, key any) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, jwt.MapClaims{"id": "42", "exp": time.Now().Add(time.Minute).Unix()})
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestKeyringSignsAndVerifiesByKid(t *testing.T) {
+	keyring, _ := testKeyring(t)
+
+	for _, kid := range []string{LegacyKeyID, "hmac-2", "rsa-1", "ed-1"} {
+		if err := keyring.SetSigningKey(kid); err != nil {
+			t.Fatal(err)
+		}
+		token, err := keyring.SignToken("42", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		parsed, _, _ := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+		if header, _ := parsed.Header["kid"].(string); (kid == LegacyKeyID) != (header == "") || (header != "" && header != kid) {
+			t.Fatalf("%s: kid header = %q", kid, header)
+		}
+		if id, err := keyring.VerifyToken(token); err != nil || id != "42" {
+			t.Fatalf("%s: VerifyToken = %q, %v", kid, id, err)
+		}
+	}
+}
+
+func TestKeyringRejectsTokens(t *testing.T) {
+	keyring, rsaPublicPEM := testKeyring(t)
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		// tokens signed by the frontend with AUTH_SECRET have no kid.
+		{name: "no kid, legacy secret", token: signWith(t, jwt.SigningMethodHS256, "", []byte("legacy secret")), valid: true},
+		{name: "no kid, another hmac key", token: signWith(t, jwt.SigningMethodHS256, "", []byte("second secret"))},
+		{name: "unknown kid", token: signWith(t, jwt.SigningMethodHS256, "hmac-3", []byte("legacy secret"))},
+		{name: "kid of another hmac key", token: signWith(t, jwt.SigningMethodHS256, "hmac-2", []byte("legacy secret"))},
+		// algorithm confusion: the public key is no secret, it must never be accepted as an HMAC key.
+		{name: "HS256 with the rsa kid's public key", token: signWith(t, jwt.SigningMethodHS256, "rsa-1", rsaPublicPEM)},
+		{name: "unsigned", token: signWith(t, jwt.SigningMethodNone, LegacyKeyID, jwt.UnsafeAllowNoneSignatureType)},
+	}
+	for _, test := range tests {
+		if _, err := keyring.VerifyToken(test.token); (err == nil) != test.valid {
+			t.Errorf("%s: VerifyToken error = %v", test.name, err)
+		}
+	}
+}
+
+func TestKeyringJWKSLeavesOutHMACKeys(t *testing.T) {
+	keyring, _ := testKeyring(t)
+
+	jwks := keyring.JWKS()
+	if len(jwks) != 2 || jwks[0].Kid != "ed-1" || jwks[0].Kty != "OKP" || jwks[1].Kid != "rsa-1" || jwks[1].Kty != "RSA" {
+		t.Fatalf("JWKS = %+v", jwks)
+	}
+}
+
+func TestLoadKeyring(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeKey := func(dir, name string) {
+		t.Helper()
+		block := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+		if err := os.WriteFile(filepath.Join(dir, name), block, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	writeKey(dir, "2026-10.pem")
+	keyring, err := LoadKeyring(config.Auth{Secret: "legacy secret", KeysDir: dir, SigningKID: "2026-10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, _ := keyring.SignToken("42", time.Minute)
+	if id, err := keyring.VerifyToken(token); err != nil || id != "42" {
+		t.Fatalf("VerifyToken = %q, %v", id, err)
+	}
+
+	// a file named after a key that is already loaded would replace it.
+	writeKey(dir, LegacyKeyID+".pem")
+	if _, err := LoadKeyring(config.Auth{Secret: "legacy secret", KeysDir: dir, SigningKID: LegacyKeyID}); err == nil {
+		t.Fatal("legacy.pem replaced the legacy key")
+	}
+	if _, err := LoadKeyring(config.Auth{Secret: "legacy secret", HMACKeys: []string{"legacy:other"}, SigningKID: LegacyKeyID}); err == nil {
+		t.Fatal("JWT_HMAC_KEYS replaced the legacy key")
+	}
+}
@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+)
+
+var ErrInvalidMediaSignature = errors.New("Invalid or expired media url.")
+
+// Derives the key signing media urls from MEDIA_SIGNING_KEY (AUTH_SECRET if it's not set), so a leaked media url
+// key can't be used to sign tokens.
+func mediaSigningKey(secret string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("chatify media urls"))
+	return mac.Sum(nil)
+}
+
+func (m *Media) mediaSignature(key string, expires int64) string {
+	mac := hmac.New(sha256.New, m.signingKey)
+	mac.Write([]byte(key + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the url path serving key without authentication until it expires after ttl.
+func (m *Media) SignURL(key string, ttl time.Duration) (string, time.Time) {
+	expiresAt := time.Now().Add(ttl).Truncate(time.Second)
+	expires := expiresAt.Unix()
+
+	return "/signed/" + key + "?exp=" + strconv.FormatInt(expires, 10) + "&sig=" + m.mediaSignature(key, expires), expiresAt
+}
+
+// Checks the exp and sig query values of a signed media url. returns when the url expires.
+func (m *Media) VerifyURL(key, exp, sig string) (time.Time, error) {
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return time.Time{}, ErrInvalidMediaSignature
+	}
+
+	expiresAt := time.Unix(expires, 0)
+	if time.Now().After(expiresAt) {
+		return time.Time{}, ErrInvalidMediaSignature
+	}
+	if !hmac.Equal([]byte(sig), []byte(m.mediaSignature(key, expires))) {
+		return time.Time{}, ErrInvalidMediaSignature
+	}
+
+	return expiresAt, nil
+}
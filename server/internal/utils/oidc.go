@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrNonceMismatch   = errors.New("ID token nonce doesn't match.")
+	ErrEmailUnverified = errors.New("The provider didn't return a verified email.")
+)
+
+type OIDCProvider struct {
+	Name     string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+// The claims Chatify needs from an ID token.
+type OIDCClaims struct {
+	Subject       string `json:"sub"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	Name          string `json:"name"`
+	Picture       string `json:"picture"`
+}
+
+// Discovers every provider in cfg.Providers (OIDC_PROVIDERS) and returns them by name. each provider is configured
+// with OIDC_<NAME>_ISSUER, OIDC_<NAME>_CLIENT_ID and OIDC_<NAME>_CLIENT_SECRET. the callback url is
+// {OIDC_REDIRECT_BASE}/oauth/{name}/callback. providers that fail discovery are logged and skipped.
+func NewOIDCProviders(cfg config.OIDC) map[string]*OIDCProvider {
+	providers := map[string]*OIDCProvider{}
+	for _, name := range cfg.Providers {
+		client := cfg.Clients[name]
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+		provider, err := NewOIDCProvider(ctx, name, client.Issuer, client.ClientID,
+			client.ClientSecret, fmt.Sprintf("%s/oauth/%s/callback", cfg.RedirectBase, name))
+		cancel()
+		if err != nil {
+			slog.Error("Couldn't set up OIDC provider", "provider", name, "error", err)
+			continue
+		}
+
+		providers[name] = provider
+	}
+
+	return providers
+}
+
+// Fetches the provider's discovery document ({issuer}/.well-known/openid-configuration) and builds an oauth2 client for it.
+func NewOIDCProvider(ctx context.Context, name, issuer, clientID, clientSecret, redirectURL string) (*OIDCProvider, error) {
+	if issuer == "" || clientID == "" {
+		return nil, errors.New("issuer and client id are required")
+	}
+
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OIDCProvider{
+		Name: name,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: clientSecret,
+			RedirectURL:  redirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}, nil
+}
+
+// Returns random state, nonce and PKCE verifier for a new authorization request.
+func NewOIDCSecrets() (state, nonce, verifier string) {
+	return randomToken(), randomToken(), oauth2.GenerateVerifier()
+}
+
+func randomToken() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// Builds the provider's authorization url with the state, the nonce and the S256 PKCE challenge.
+func (p *OIDCProvider) AuthURL(state, nonce, verifier string) string {
+	return p.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+}
+
+// Exchanges an authorization code for tokens, verifies the ID token (signature, issuer, audience, expiry and nonce)
+// and returns its claims. the email must be verified by the provider.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier, nonce string) (OIDCClaims, error) {
+	var claims OIDCClaims
+
+	token, err := p.config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return claims, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return claims, errors.New("No id_token in token response.")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return claims, err
+	}
+	if idToken.Nonce != nonce {
+		return claims, ErrNonceMismatch
+	}
+
+	if err = idToken.Claims(&claims); err != nil {
+		return claims, err
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		return claims, ErrEmailUnverified
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func setupOIDC(t *testing.T) (*oidctest.Provider, *OIDCProvider) {
+	mock := oidctest.New(t)
+	provider, err := NewOIDCProvider(context.Background(), "mock", mock.URL, oidctest.ClientID, oidctest.ClientSecret, "http://localhost:8080/oauth/mock/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return mock, provider
+}
+
+var verifiedUser = jwt.MapClaims{"sub": "42", "email": "jane@chatify.dev", "email_verified": true, "name": "Jane"}
+
+func TestOIDCLogin(t *testing.T) {
+	mock, provider := setupOIDC(t)
+
+	state, nonce, verifier := NewOIDCSecrets()
+	code, returnedState := mock.Authorize(t, provider.AuthURL(state, nonce, verifier), verifiedUser)
+	if returnedState != state {
+		t.Fatalf("state was not forwarded, got %q", returnedState)
+	}
+
+	claims, err := provider.Exchange(context.Background(), code, verifier, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "42" || claims.Email != "jane@chatify.dev" || claims.Name != "Jane" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestOIDCRejectsWrongVerifier(t *testing.T) {
+	mock, provider := setupOIDC(t)
+
+	state, nonce, verifier := NewOIDCSecrets()
+	code, _ := mock.Authorize(t, provider.AuthURL(state, nonce, verifier), verifiedUser)
+
+	_, _, otherVerifier := NewOIDCSecrets()
+	if _, err := provider.Exchange(context.Background(), code, otherVerifier, nonce); err == nil {
+		t.Fatal("expected the exchange with a wrong PKCE verifier to fail")
+	}
+}
+
+func TestOIDCRejectsNonceMismatch(t *testing.T) {
+	mock, provider := setupOIDC(t)
+
+	state, nonce, verifier := NewOIDCSecrets()
+	code, _ := mock.Authorize(t, provider.AuthURL(state, nonce, verifier), verifiedUser)
+
+	if _, err := provider.Exchange(context.Background(), code, verifier, "other-nonce"); err != ErrNonceMismatch {
+		t.Fatalf("expected ErrNonceMismatch, got %v", err)
+	}
+}
+
+func TestOIDCRequiresVerifiedEmail(t *testing.T) {
+	mock, provider := setupOIDC(t)
+
+	state, nonce, verifier := NewOIDCSecrets()
+	claims := jwt.MapClaims{"sub": "43", "email": "joe@chatify.dev", "email_verified": false}
+	code, _ := mock.Authorize(t, provider.AuthURL(state, nonce, verifier), claims)
+
+	if _, err := provider.Exchange(context.Background(), code, verifier, nonce); err != ErrEmailUnverified {
+		t.Fatalf("expected ErrEmailUnverified, got %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	MinPasswordLength = 8
+	MaxPasswordLength = 128
+)
+
+var ErrCommonPassword = errors.New("This password is too common, please choose another one.")
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = loadCommonPasswords()
+
+func loadCommonPasswords() map[string]struct{} {
+	passwords := make(map[string]struct{})
+	scanner := bufio.NewScanner(strings.NewReader(commonPasswordsFile))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			passwords[strings.ToLower(line)] = struct{}{}
+		}
+	}
+
+	return passwords
+}
+
+// Checks a new password against the password policy: length limits and the bundled list of common passwords.
+func ValidatePasswordPolicy(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < MinPasswordLength || length > MaxPasswordLength {
+		return fmt.Errorf("Password must be at least %d characters and %d at most.", MinPasswordLength, MaxPasswordLength)
+	}
+
+	if _, ok := commonPasswords[strings.ToLower(password)]; ok {
+		return ErrCommonPassword
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"net/http"
+)
+
+var (
+	ErrUnsupportedImage = errors.New("Unsupported file type, only jpeg, png, gif and webp images are allowed.")
+	ErrImageTooLarge    = errors.New("Image file is too large.")
+	ErrImageDimensions  = errors.New("Image dimensions are too large.")
+	ErrCorruptImage     = errors.New("Image is corrupt or couldn't be decoded.")
+)
+
+// content types of the formats accepted by SniffImage.
+var ImageContentTypes = map[string]string{
+	"jpeg": "image/jpeg",
+	"png":  "image/png",
+	"gif":  "image/gif",
+	"webp": "image/webp",
+}
+
+func IsImageContentType(contentType string) bool {
+	for _, t := range ImageContentTypes {
+		if t == contentType {
+			return true
+		}
+	}
+	return false
+}
+
+// Identifies an image format by its magic bytes, the client's content type is never trusted.
+func SniffImage(head []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0xFF, 0xD8, 0xFF}):
+		return "jpeg", true
+	case bytes.HasPrefix(head, []byte("\x89PNG\r\n\x1a\n")):
+		return "png", true
+	case bytes.HasPrefix(head, []byte("GIF87a")), bytes.HasPrefix(head, []byte("GIF89a")):
+		return "gif", true
+	case len(head) >= 12 && bytes.Equal(head[:4], []byte("RIFF")) && bytes.Equal(head[8:12], []byte("WEBP")):
+		return "webp", true
+	default:
+		return "", false
+	}
+}
+
+// Reads an image of at most maxBytes, checks its magic bytes and its dimensions (from the header only)
+// against maxSide and maxPixels. returns the image bytes and its format.
+func ReadImage(r io.Reader, maxBytes int64, maxSide, maxPixels int) ([]byte, string, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBytes+1))
+	if err != nil {
+		return nil, "", err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, "", ErrImageTooLarge
+	}
+
+	format, ok := SniffImage(data)
+	if !ok {
+		return nil, "", ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", ErrCorruptImage
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return nil, "", ErrCorruptImage
+	}
+	if config.Width > maxSide || config.Height > maxSide || config.Width*config.Height > maxPixels {
+		return nil, "", ErrImageDimensions
+	}
+
+	return data, format, nil
+}
+
+// Maps image validation errors to http status codes, anything else is a 500.
+func ImageErrorStatus(err error) int {
+	switch err {
+	case ErrUnsupportedImage:
+		return http.StatusUnsupportedMediaType
+	case ErrImageTooLarge, ErrImageDimensions, ErrTooManyFrames:
+		return http.StatusRequestEntityTooLarge
+	case ErrCorruptImage:
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
+	}
+}
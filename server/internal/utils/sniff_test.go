@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/chai2010/webp"
+)
+
+// the default limits (config.Media).
+const (
+	testMaxSide   = 12_000
+	testMaxPixels = 40_000_000
+)
+
+func testImage(width, height int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := range width {
+		for y := range height {
+			img.Set(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 8), B: 128, A: 255})
+		}
+	}
+	return img
+}
+
+func encodeImage(t testing.TB, format string, img image.Image) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case "png":
+		err = png.Encode(&buf, img)
+	case "jpeg":
+		err = jpeg.Encode(&buf, img, nil)
+	case "gif":
+		err = gif.Encode(&buf, img, nil)
+	case "webp":
+		err = webp.Encode(&buf, img, &webp.Options{Lossless: true})
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// a png whose IHDR claims width x height, without the pixels. DecodeConfig only reads the header.
+func pngHeader(width, height uint32) []byte {
+	ihdr := binary.BigEndian.AppendUint32([]byte("IHDR"), width)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, height)
+	ihdr = append(ihdr, 8, 6, 0, 0, 0) // 8 bit RGBA
+
+	file := []byte("\x89PNG\r\n\x1a\n")
+	file = binary.BigEndian.AppendUint32(file, uint32(len(ihdr)-4))
+	file = append(file, ihdr...)
+	return binary.BigEndian.AppendUint32(file, crc32.ChecksumIEEE(ihdr))
+}
+
+func TestReadImage(t *testing.T) {
+	img := testImage(24, 16)
+	pngFile := encodeImage(t, "png", img)
+
+	tests := []struct {
+		name   string
+		data   []byte
+		format string
+		err    error
+	}{
+		{name: "png", data: pngFile, format: "png"},
+		{name: "jpeg", data: encodeImage(t, "jpeg", img), format: "jpeg"},
+		{name: "gif", data: encodeImage(t, "gif", img), format: "gif"},
+		{name: "webp", data: encodeImage(t, "webp", img), format: "webp"},
+
+		{name: "svg", data: []byte(`<svg xmlns="http://www.w3.org/2000/svg"/>`), err: ErrUnsupportedImage},
+		{name: "empty", data: nil, err: ErrUnsupportedImage},
+		{name: "png truncated header", data: pngFile[:20], err: ErrCorruptImage},
+		{name: "png without width", data: pngHeader(0, 16), err: ErrCorruptImage},
+		{name: "webp without chunks", data: []byte("RIFF\x04\x00\x00\x00WEBP"), err: ErrCorruptImage},
+		{name: "png wider than the max side", data: pngHeader(testMaxSide+1, 1), err: ErrImageDimensions},
+		{name: "png with too many pixels", data: pngHeader(testMaxSide, testMaxSide), err: ErrImageDimensions},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data, format, err := ReadImage(bytes.NewReader(test.data), 1<<20, testMaxSide, testMaxPixels)
+			if err != test.err {
+				t.Fatalf("ReadImage error = %v, want %v", err, test.err)
+			}
+			if err == nil && (format != test.format || !bytes.Equal(data, test.data)) {
+				t.Fatalf("ReadImage = %q, %d bytes", format, len(data))
+			}
+		})
+	}
+}
+
+func TestReadImageTooLarge(t *testing.T) {
+	data := encodeImage(t, "png", testImage(24, 16))
+	if _, _, err := ReadImage(bytes.NewReader(data), int64(len(data)-1), testMaxSide, testMaxPixels); err != ErrImageTooLarge {
+		t.Fatalf("ReadImage error = %v, want %v", err, ErrImageTooLarge)
+	}
+}
+
+func TestSniffImage(t *testing.T) {
+	tests := []struct {
+		name   string
+		head   string
+		format string
+	}{
+		{name: "jpeg", head: "\xFF\xD8\xFF\xE0", format: "jpeg"},
+		{name: "gif87a", head: "GIF87a", format: "gif"},
+		{name: "webp", head: "RIFF\x00\x00\x00\x00WEBPVP8 ", format: "webp"},
+		{name: "wav", head: "RIFF\x00\x00\x00\x00WAVEfmt "},
+		{name: "short riff", head: "RIFF\x00\x00"},
+		{name: "html", head: "<!doctype html>"},
+	}
+	for _, test := range tests {
+		if format, ok := SniffImage([]byte(test.head)); format != test.format || ok != (test.format != "") {
+			t.Errorf("%s: SniffImage = %q, %v", test.name, format, ok)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"errors"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+var ErrPasskeyCloned = errors.New("Passkey signature counter did not increase, the authenticator may be cloned.")
+
+// NewWebAuthn configures the relying party used for passkey registration and login.
+// the rp id defaults to "localhost" and the origins to the frontend url (see config.WebAuthn).
+func NewWebAuthn(rpID string, origins []string) (*webauthn.WebAuthn, error) {
+	return webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: "Chatify",
+		RPOrigins:     origins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationPreferred,
+		},
+	})
+}
+
+// Takes the raw json body of a navigator.credentials.create() response and verifies it against the registration session.
+func FinishPasskeyRegistration(w *webauthn.WebAuthn, user webauthn.User, session webauthn.SessionData, body []byte) (*webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(body)
+	if err != nil {
+		return nil, err
+	}
+
+	return w.CreateCredential(user, session, parsed)
+}
+
+// Takes the raw json body of a navigator.credentials.get() response and verifies it against the login session.
+// If the session was started for a specific user (session.UserID is set), lookup is called with that user handle,
+// otherwise it's a discoverable login and lookup is called with the user handle returned by the authenticator.
+// A signature counter that didn't increase is rejected with ErrPasskeyCloned.
+func FinishPasskeyLogin(w *webauthn.WebAuthn, session webauthn.SessionData, body []byte, lookup webauthn.DiscoverableUserHandler) (webauthn.User, *webauthn.Credential, error) {
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var user webauthn.User
+	var credential *webauthn.Credential
+	if len(session.UserID) > 0 {
+		user, err = lookup(parsed.RawID, session.UserID)
+		if err != nil {
+			return nil, nil, err
+		}
+		credential, err = w.ValidateLogin(user, session, parsed)
+	} else {
+		user, credential, err = w.ValidatePasskeyLogin(lookup, session, parsed)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if credential.Authenticator.CloneWarning {
+		return nil, nil, ErrPasskeyCloned
+	}
+
+	return user, credential, nil
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+const (
+	testRPID   = "localhost"
+	testOrigin = "http://localhost:3000"
+)
+
+// softAuthenticator is a minimal software authenticator producing "none" attestations and ES256 assertions.
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	counter      uint32
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return &softAuthenticator{key: key, credentialID: id}
+}
+
+type testUser struct {
+	id          []byte
+	credentials []webauthn.Credential
+}
+
+func (u *testUser) WebAuthnID() []byte                         { return u.id }
+func (u *testUser) WebAuthnName() string                       { return "test@chatify.dev" }
+func (u *testUser) WebAuthnDisplayName() string                { return "Test" }
+func (u *testUser) WebAuthnCredentials() []webauthn.Credential { return u.credentials }
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func clientData(t *testing.T, ceremony, challenge string) []byte {
+	data, err := json.Marshal(map[string]any{"type": ceremony, "challenge": challenge, "origin": testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *softAuthenticator) authData(flags byte, attested []byte) []byte {
+	rpHash := sha256.Sum256([]byte(testRPID))
+	data := append([]byte{}, rpHash[:]...)
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	return append(data, attested...)
+}
+
+func (a *softAuthenticator) create(t *testing.T, creation *protocol.CredentialCreation) []byte {
+	a.userHandle = creation.Response.User.ID.(protocol.URLEncodedBase64)
+
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.PublicKey.X.FillBytes(x)
+	a.key.PublicKey.Y.FillBytes(y)
+	coseKey, err := webauthncbor.Marshal(map[int]any{1: 2, 3: -7, -1: 1, -2: x, -3: y})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, coseKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]any{
+		"fmt":      "none",
+		"attStmt":  map[string]any{},
+		"authData": a.authData(0x45, attested), // UP | UV | AT
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(clientData(t, "webauthn.create", creation.Response.Challenge.String())),
+			"attestationObject": b64(attestation),
+		},
+	})
+	return body
+}
+
+func (a *softAuthenticator) get(t *testing.T, assertion *protocol.CredentialAssertion) []byte {
+	authData := a.authData(0x05, nil) // UP | UV
+	cData := clientData(t, "webauthn.get", assertion.Response.Challenge.String())
+	cDataHash := sha256.Sum256(cData)
+
+	digest := sha256.Sum256(append(append([]byte{}, authData...), cDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"id":    b64(a.credentialID),
+		"rawId": b64(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    b64(cData),
+			"authenticatorData": b64(authData),
+			"signature":         b64(signature),
+			"userHandle":        b64(a.userHandle),
+		},
+	})
+	return body
+}
+
+func setupPasskey(t *testing.T) (*webauthn.WebAuthn, *testUser, *softAuthenticator) {
+	w, err := NewWebAuthn(testRPID, []string{testOrigin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := &testUser{id: []byte("0123456789ab")}
+	authenticator := newSoftAuthenticator(t)
+
+	creation, session, err := w.BeginRegistration(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := FinishPasskeyRegistration(w, user, *session, authenticator.create(t, creation))
+	if err != nil {
+		t.Fatalf("registration failed: %v", err)
+	}
+	user.credentials = append(user.credentials, *credential)
+
+	return w, user, authenticator
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	w, user, authenticator := setupPasskey(t)
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) { return user, nil }
+
+	for _, discoverable := range []bool{false, true} {
+		var assertion *protocol.CredentialAssertion
+		var session *webauthn.SessionData
+		var err error
+		if discoverable {
+			assertion, session, err = w.BeginDiscoverableLogin()
+		} else {
+			assertion, session, err = w.BeginLogin(user)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		authenticator.counter++
+		_, credential, err := FinishPasskeyLogin(w, *session, authenticator.get(t, assertion), lookup)
+		if err != nil {
+			t.Fatalf("login (discoverable=%v) failed: %v", discoverable, err)
+		}
+		if credential.Authenticator.SignCount != authenticator.counter {
+			t.Fatalf("expected sign count %d, got %d", authenticator.counter, credential.Authenticator.SignCount)
+		}
+		user.credentials[0] = *credential
+	}
+}
+
+func TestPasskeyLoginRejectsReplayedCounter(t *testing.T) {
+	w, user, authenticator := setupPasskey(t)
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) { return user, nil }
+
+	authenticator.counter = 5
+	assertion, session, _ := w.BeginLogin(user)
+	_, credential, err := FinishPasskeyLogin(w, *session, authenticator.get(t, assertion), lookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user.credentials[0] = *credential
+
+	assertion, session, _ = w.BeginLogin(user)
+	_, _, err = FinishPasskeyLogin(w, *session, authenticator.get(t, assertion), lookup)
+	if err != ErrPasskeyCloned {
+		t.Fatalf("expected ErrPasskeyCloned, got %v", err)
+	}
+}
+
+func TestPasskeyLoginRejectsWrongChallenge(t *testing.T) {
+	w, user, authenticator := setupPasskey(t)
+	lookup := func(rawID, userHandle []byte) (webauthn.User, error) { return user, nil }
+
+	assertion, _, _ := w.BeginLogin(user)
+	_, otherSession, _ := w.BeginLogin(user)
+
+	authenticator.counter++
+	if _, _, err := FinishPasskeyLogin(w, *otherSession, authenticator.get(t, assertion), lookup); err == nil {
+		t.Fatal("expected login with a foreign challenge to fail")
+	}
+}
@@ -0,0 +1,90 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	snapws "github.com/Atheer-Ganayem/SnapWS"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var ErrDraining = errors.New("Server is restarting, please try again.")
+
+// Returns true once the server started shutting down, new connections and messages are refused.
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// Called before a message is saved. returns false when the server is shutting down, otherwise endSave must
+// be called once the message is handled.
+func (h *Hub) startSave() bool {
+	h.saving.RLock()
+	if h.draining.Load() {
+		h.saving.RUnlock()
+		return false
+	}
+
+	return true
+}
+
+func (h *Hub) endSave() {
+	h.saving.RUnlock()
+}
+
+// Connect upgrades the request and registers the connection like snapws' Manager.Connect. Drain only reaches the
+// connections registered before it started, so a connection registered once the hub is draining is told to
+// reconnect and closed here, and ErrDraining is returned.
+func (h *Hub) Connect(userID bson.ObjectID, w http.ResponseWriter, r *http.Request) (*snapws.ManagedConn[bson.ObjectID], error) {
+	conn, err := h.Manager.Connect(userID, w, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if h.draining.Load() {
+		h.sendReconnect(context.Background(), conn)
+		conn.Close()
+		return nil, ErrDraining
+	}
+
+	return conn, nil
+}
+
+// Tells conn to reconnect once, a connection registered just as Drain starts is reached by both Drain and Connect.
+func (h *Hub) sendReconnect(ctx context.Context, conn *snapws.ManagedConn[bson.ObjectID]) {
+	if _, sent := conn.MetaData.LoadOrStore("reconnectSent", true); !sent {
+		SendJSON(ctx, conn, gin.H{"type": "reconnect"})
+	}
+}
+
+// Drain stops accepting messages, tells every connection to reconnect (to another instance), waits for the
+// messages that are being saved and closes the connections. returns ctx's error if it's done first.
+func (h *Hub) Drain(ctx context.Context) error {
+	h.draining.Store(true)
+
+	for _, conn := range h.GetAllConns() {
+		h.sendReconnect(ctx, conn)
+	}
+
+	saved := make(chan struct{})
+	go func() {
+		h.saving.Lock()
+		h.saving.Unlock()
+		close(saved)
+	}()
+
+	var err error
+	select {
+	case <-saved:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	// snapws' Manager.Shutdown closes the connections while holding the manager's lock, which Close needs too.
+	for _, conn := range h.GetAllConns() {
+		conn.Close()
+	}
+
+	return err
+}
@@ -0,0 +1,109 @@
+package ws
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+
+	snapws "github.com/Atheer-Ganayem/SnapWS"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Hub holds the websocket connections of the server, keyed by user, and what their messages are saved with.
+type Hub struct {
+	*snapws.Manager[bson.ObjectID]
+
+	conversations models.Conversations
+	messages      models.Messages
+	cache         *redis.Cache
+	// what messages leave to do once they're sent, like updating the conversation's last message.
+	background *utils.Background
+
+	draining atomic.Bool
+	// every message being saved holds a read lock, Drain takes the write lock to wait for them.
+	saving sync.RWMutex
+}
+
+func NewHub(cfg config.Server, conversations models.Conversations, messages models.Messages, cache *redis.Cache, background *utils.Background) *Hub {
+	u := snapws.NewUpgrader(&snapws.Options{MaxMessageSize: cfg.MaxMessageSize,
+		ReaderMaxFragments: 5,
+	})
+
+	u.Limiter = snapws.NewRateLimiter(cfg.WSRate, cfg.WSBurst)
+	u.Limiter.OnRateLimitHit = func(conn *snapws.Conn) error {
+		metrics.WSRateLimitHits.Inc()
+		metrics.WSMessagesOut.WithLabelValues("err").Inc()
+		conn.SendJSON(context.Background(), gin.H{"type": "err", "message": "Too fast."})
+		return nil
+	}
+
+	hub := &Hub{
+		Manager:       snapws.NewManager[bson.ObjectID](u),
+		conversations: conversations,
+		messages:      messages,
+		cache:         cache,
+		background:    background,
+	}
+	hub.OnRegister = hub.onRegister
+	hub.OnUnregister = hub.onUnregister
+
+	return hub
+}
+
+// Tells the user's participants that they're online.
+func (h *Hub) onRegister(conn *snapws.ManagedConn[bson.ObjectID]) {
+	ids, err := h.conversations.GetParticipantsIDs(context.Background(), conn.Key)
+	if err != nil {
+		slog.Error("Couldn't get participants of a connecting user", "user_id", conn.Key.Hex(), "error", err)
+		// report error to client
+		conn.Close()
+		return
+	}
+	conn.MetaData.Store("participantsIDs", NewParticipantIDs(ids))
+
+	for _, pID := range ids {
+		if pConn, ok := h.GetConn(pID); ok {
+			SendJSON(context.Background(), pConn, gin.H{"type": "status", "userId": conn.Key, "online": true})
+		}
+	}
+}
+
+func (h *Hub) onUnregister(conn *snapws.ManagedConn[bson.ObjectID]) {
+	val, ok := conn.MetaData.Load("participantsIDs")
+	if !ok {
+		return
+	}
+
+	safeIDs, ok := val.(*SafeIDs)
+	if !ok {
+		return
+	}
+
+	safeIDs.Mu.RLock()
+	defer safeIDs.Mu.RUnlock()
+
+	for _, pID := range safeIDs.IDs {
+		if pConn, ok := h.GetConn(pID); ok {
+			SendJSON(context.Background(), pConn, gin.H{"type": "status", "userId": conn.Key, "online": false})
+		}
+	}
+}
+
+// Returns the users of ids that are connected.
+func (h *Hub) FilterOnlineUsers(ids []bson.ObjectID) []bson.ObjectID {
+	online := make([]bson.ObjectID, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := h.GetConn(id); ok {
+			online = append(online, id)
+		}
+	}
+
+	return online
+}
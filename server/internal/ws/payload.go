@@ -1,7 +1,9 @@
 package ws
 
 import (
+	"context"
 	"errors"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -17,10 +19,24 @@ type WSPayload struct {
 	ConversationID string `json:"conversationId"`
 	Message        string `json:"message"`
 	Image          string `json:"image"` // this is the value of the image path that the client received when they uploaded the image
+	Audio          string `json:"audio"` // same as image, for voice notes
 }
 
-func (payload *WSPayload) ProccessMessage(userID, conversationID bson.ObjectID) (models.Message, bson.ObjectID, error) {
-	conversation, err := models.FindConversation(bson.M{"_id": conversationID, "participants": userID})
+// payloads are logged without their text, only its length.
+func (payload WSPayload) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.String("id", payload.ID),
+		slog.String("type", payload.Type),
+		slog.String("conversation_id", payload.ConversationID),
+		slog.Int("text_length", len(payload.Message)),
+		slog.Bool("image", payload.Image != ""),
+		slog.Bool("audio", payload.Audio != ""),
+	)
+}
+
+// Saves the message of payload, returns it and the id of the user it's sent to.
+func (h *Hub) processMessage(ctx context.Context, payload *WSPayload, userID, conversationID bson.ObjectID, imageMeta *utils.ImageMeta, audioMeta *utils.AudioMeta) (models.Message, bson.ObjectID, error) {
+	conversation, err := h.conversations.FindWithParticipant(ctx, conversationID, userID)
 	if err != nil {
 		return models.Message{}, bson.NewObjectID(), err
 	}
@@ -28,14 +44,20 @@ func (payload *WSPayload) ProccessMessage(userID, conversationID bson.ObjectID)
 	message := models.Message{ID: bson.NewObjectID(), Sender: userID, ConversationID: conversationID, Text: payload.Message, CreatedAt: time.Now()}
 	if payload.Image != "" {
 		message.Image = payload.Image
+		message.ImageMeta = imageMeta
+	}
+	if payload.Audio != "" {
+		message.Audio = payload.Audio
+		message.AudioMeta = audioMeta
 	}
 
-	err = message.Save()
+	err = h.messages.Save(ctx, message)
 	if err != nil {
 		return models.Message{}, bson.NewObjectID(), err
 	}
 
-	go conversation.UpdateLastMessage(message.ID)
+	bgCtx := context.WithoutCancel(ctx)
+	h.background.Go(func() { h.conversations.UpdateLastMessage(bgCtx, conversation.ID, message.ID) })
 
 	return message, utils.GetOtherParticipant(userID, [2]bson.ObjectID(conversation.Participants)), nil
 }
@@ -46,8 +68,10 @@ func (payload *WSPayload) Validate() (bson.ObjectID, error) {
 		return bson.NilObjectID, errors.New("Invalid request ID. Must be a valid UUID.")
 	} else if payload.Type != "msg" {
 		return bson.NilObjectID, errors.New("Invalid type.")
-	} else if payload.Message == "" {
+	} else if payload.Message == "" && payload.Audio == "" {
 		return bson.NilObjectID, errors.New("Message cannot be empty.")
+	} else if payload.Image != "" && payload.Audio != "" {
+		return bson.NilObjectID, errors.New("A message can't have both an image and a voice note.")
 	}
 
 	conversationID, err := bson.ObjectIDFromHex(payload.ConversationID)
@@ -0,0 +1,77 @@
+package ws
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/config"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/models"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+)
+
+func TestValidate(t *testing.T) {
+	conversationID := bson.NewObjectID()
+	valid := WSPayload{ID: uuid.NewString(), Type: "msg", ConversationID: conversationID.Hex(), Message: " hi "}
+
+	payload := valid
+	if id, err := payload.Validate(); err != nil || id != conversationID || payload.Message != "hi" {
+		t.Fatalf("Validate = %v, %v, message %q", id, err, payload.Message)
+	}
+
+	invalid := map[string]func(p *WSPayload){
+		"request id":      func(p *WSPayload) { p.ID = "1" },
+		"type":            func(p *WSPayload) { p.Type = "delete" },
+		"empty message":   func(p *WSPayload) { p.Message = "  " },
+		"image and audio": func(p *WSPayload) { p.Image, p.Audio = "images/a.webp", "audio/a.ogg" },
+		"conversation id": func(p *WSPayload) { p.ConversationID = "nope" },
+	}
+	for name, change := range invalid {
+		payload := valid
+		change(&payload)
+		if _, err := payload.Validate(); err == nil {
+			t.Errorf("invalid %s passed validation", name)
+		}
+	}
+}
+
+func TestProcessMessage(t *testing.T) {
+	ctx := context.Background()
+	memory := models.NewMemory(10)
+	background := &utils.Background{}
+	hub := NewHub(config.Server{MaxMessageSize: 1 << 20, WSRate: 10, WSBurst: 10}, memory.Conversations, memory.Messages, nil, background)
+
+	alice, bob := bson.NewObjectID(), bson.NewObjectID()
+	conversationID, _, _ := memory.Conversations.Create(ctx, [2]bson.ObjectID{alice, bob})
+
+	payload := &WSPayload{ID: uuid.NewString(), Type: "msg", ConversationID: conversationID.Hex(), Message: "hi", Image: "images/a.webp"}
+	imageMeta := &utils.ImageMeta{Width: 4, Height: 3}
+	message, receiver, err := hub.processMessage(ctx, payload, alice, conversationID, imageMeta, nil)
+	if err != nil || receiver != bob {
+		t.Fatalf("processMessage = %v, %v", receiver.Hex(), err)
+	}
+	if message.Sender != alice || message.Text != "hi" || message.Image != "images/a.webp" || message.ImageMeta != imageMeta {
+		t.Fatalf("processMessage returned %+v", message)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, time.Second*5)
+	defer cancel()
+	if err := background.Wait(waitCtx); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err := memory.Messages.FindBySender(ctx, message.ID, alice); err != nil || saved.ImageMeta.Width != 4 {
+		t.Fatalf("saved message = %+v, %v", saved, err)
+	}
+	if conversation, _ := memory.Conversations.FindWithParticipant(ctx, conversationID, alice); conversation.LastMessage != message.ID {
+		t.Fatalf("last message wasn't updated: %v", conversation.LastMessage.Hex())
+	}
+
+	// only participants can send to a conversation.
+	if _, _, err := hub.processMessage(ctx, payload, bson.NewObjectID(), conversationID, nil, nil); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Fatal("processMessage by a non participant:", err)
+	}
+}
@@ -2,65 +2,128 @@ package ws
 
 import (
 	"context"
-	"fmt"
-	"log"
+	"errors"
 
 	snapws "github.com/Atheer-Ganayem/SnapWS"
-	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/redis"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/logging"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/tracing"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/utils"
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func ReadPump(manager *snapws.Manager[bson.ObjectID], conn *snapws.ManagedConn[bson.ObjectID], userID bson.ObjectID) {
+var (
+	errImageMismatch = errors.New("the image isn't the user's pending image")
+	errAudioMismatch = errors.New("the voice note isn't the user's pending voice note")
+)
+
+// Reads messages from conn until it's closed. ctx carries the connection's logger (see logging.With). every
+// message is traced on its own, from validation to delivery.
+func (h *Hub) ReadPump(ctx context.Context, conn *snapws.ManagedConn[bson.ObjectID], userID bson.ObjectID) {
+	logger := logging.From(ctx)
 	for {
 		// Read conn & validate payload
 		var payload WSPayload
 		err := conn.ReadJSON(&payload)
 		if snapws.IsFatalErr(err) {
-			/// here it error
-			fmt.Println("reader fatal", err)
+			logger.Info("Websocket read failed, closing the connection", "error", err)
+			metrics.WSFatalReadErrors.Inc()
 			return
 		} else if err != nil {
-			fmt.Println(err)
+			logger.Warn("Couldn't read websocket message", "error", err)
 			// must report to client
 			continue
 		}
+		metrics.WSMessagesIn.Inc()
 
-		conversationID, err := payload.Validate()
-		if err != nil {
-			fmt.Println(payload)
-			conn.SendJSON(context.Background(), gin.H{"type": "err", "message": err.Error()})
+		if !h.startSave() {
+			SendJSON(context.Background(), conn, gin.H{"type": "err", "message": "Server is restarting, please reconnect and send again."})
 			continue
 		}
+		msgCtx, span := tracing.Start(ctx, "ws.message", trace.WithNewRoot(), trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithAttributes(attribute.String("chatify.user_id", userID.Hex()), attribute.String("chatify.request_id", payload.ID)))
+		err = h.handlePayload(logging.WithTrace(msgCtx), conn, userID, payload)
+		tracing.End(span, err)
+		h.endSave()
+	}
+}
+
+// Validates, saves and delivers a message read from conn. returns why the message wasn't sent, the client
+// is told already.
+func (h *Hub) handlePayload(ctx context.Context, conn *snapws.ManagedConn[bson.ObjectID], userID bson.ObjectID, payload WSPayload) error {
+	logger := logging.From(ctx).With("payload", payload)
 
-		// check if user sent an image, if yes, validate its existience and owner in redis
-		if payload.Image != "" {
-			path, err := redis.GetTempImage(userID)
-			if err != nil {
-				conn.SendJSON(context.Background(), gin.H{"type": "err", "message": "Couldn't send image."})
-				continue
-			} else if path != payload.Image {
-				conn.SendJSON(context.Background(), gin.H{"type": "err", "message": "Couldn't send image."})
-				continue
-			}
+	_, validateSpan := tracing.Start(ctx, "ws.validate")
+	conversationID, err := payload.Validate()
+	tracing.End(validateSpan, err)
+	if err != nil {
+		logger.Debug("Invalid websocket message", "error", err)
+		SendJSON(ctx, conn, gin.H{"type": "err", "message": err.Error()})
+		return err
+	}
+
+	// check if user sent an image, if yes, validate its existience and owner in redis
+	var imageMeta *utils.ImageMeta
+	if payload.Image != "" {
+		path, err := h.cache.GetTempImage(ctx, userID)
+		if err != nil {
+			SendJSON(ctx, conn, gin.H{"type": "err", "message": "Couldn't send image."})
+			return err
+		} else if path != payload.Image {
+			SendJSON(ctx, conn, gin.H{"type": "err", "message": "Couldn't send image."})
+			return errImageMismatch
 		}
 
-		// saving & sending messages to other participant and ACK to client
-		message, receiverID, err := payload.ProccessMessage(userID, conversationID)
+		imageMeta, err = h.cache.GetTempImageMeta(ctx, userID)
 		if err != nil {
-			conn.SendJSON(context.Background(), gin.H{"type": "err", "message": "Couldn't send message."})
-			continue
+			logger.Warn("Couldn't get image metadata", "error", err)
 		}
-		go redis.DeleteKeys(userID) // cleanup redies after successfull message saving
+	}
 
-		receiverConn, ok := manager.GetConn(receiverID)
-		if receiverConn != nil && ok {
-			if err := receiverConn.SendJSON(context.Background(), gin.H{"type": "msg", "message": message}); err != nil {
-				log.Printf("Failed to send to %s: %v", receiverID.Hex(), err)
-			}
+	// same for voice notes
+	var audioMeta *utils.AudioMeta
+	if payload.Audio != "" {
+		path, err := h.cache.GetTempAudio(ctx, userID)
+		if err == nil && path != payload.Audio {
+			err = errAudioMismatch
 		}
-		if err := conn.SendJSON(context.Background(), gin.H{"type": "acknowledged", "message": message, "id": payload.ID}); err != nil {
-			log.Printf("Failed to send to %s: %v", receiverID.Hex(), err)
+		if err != nil {
+			SendJSON(ctx, conn, gin.H{"type": "err", "message": "Couldn't send voice note."})
+			return err
 		}
+
+		audioMeta, err = h.cache.GetTempAudioMeta(ctx, userID)
+		if err != nil {
+			logger.Warn("Couldn't get voice note metadata", "error", err)
+		}
+	}
+
+	// saving & sending messages to other participant and ACK to client
+	message, receiverID, err := h.processMessage(ctx, &payload, userID, conversationID, imageMeta, audioMeta)
+	if err != nil {
+		logger.Error("Couldn't save message", "error", err)
+		SendJSON(ctx, conn, gin.H{"type": "err", "message": "Couldn't send message."})
+		return err
+	}
+	// cleanup redies after successfull message saving, still part of the message's trace.
+	bgCtx := context.WithoutCancel(ctx)
+	h.background.Go(func() { h.cache.DeleteKeys(bgCtx, userID) })
+	if payload.Audio != "" {
+		h.background.Go(func() { h.cache.DeleteAudioKeys(bgCtx, userID) })
 	}
+
+	receiverConn, ok := h.GetConn(receiverID)
+	if receiverConn != nil && ok {
+		if err := SendJSON(ctx, receiverConn, gin.H{"type": "msg", "message": message}); err != nil {
+			logger.Warn("Couldn't send message to the receiver", "receiver_id", receiverID.Hex(), "error", err)
+		}
+	}
+	if err := SendJSON(ctx, conn, gin.H{"type": "acknowledged", "message": message, "id": payload.ID}); err != nil {
+		logger.Warn("Couldn't acknowledge message", "error", err)
+	}
+
+	return nil
 }
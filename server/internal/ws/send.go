@@ -0,0 +1,26 @@
+package ws
+
+import (
+	"context"
+
+	snapws "github.com/Atheer-Ganayem/SnapWS"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/metrics"
+	"github.com/Chatify-Chat-App-in-Go-and-Next.js/server-snapws/internal/tracing"
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Sends an event to conn and counts it by its "type". traced if ctx has a span.
+func SendJSON(ctx context.Context, conn *snapws.ManagedConn[bson.ObjectID], event gin.H) error {
+	eventType, _ := event["type"].(string)
+	metrics.WSMessagesOut.WithLabelValues(eventType).Inc()
+
+	ctx, span := tracing.StartChild(ctx, "ws.send "+eventType, trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(attribute.String("chatify.user_id", conn.Key.Hex())))
+	err := conn.SendJSON(ctx, event)
+	tracing.End(span, err)
+
+	return err
+}